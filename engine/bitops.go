@@ -0,0 +1,603 @@
+package godis
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// BITOP operations
+const (
+	BITOP_AND = iota
+	BITOP_OR
+	BITOP_XOR
+	BITOP_NOT
+)
+
+// BITFIELD subcommands
+const (
+	BITFIELD_GET = iota
+	BITFIELD_SET
+	BITFIELD_INCRBY
+)
+
+// BITFIELD overflow behaviors
+const (
+	BITFIELD_OVERFLOW_WRAP = iota
+	BITFIELD_OVERFLOW_SAT
+	BITFIELD_OVERFLOW_FAIL
+)
+
+// bitfieldOp is a parsed subcommand of BITFIELD
+type bitfieldOp struct {
+	opcode   int
+	offset   int64
+	value    int64 // value of SET or increment of INCRBY
+	bits     int
+	signed   bool
+	overflow int
+}
+
+// getBitOffsetOrReply parses the offset of bits in a string,
+// the offset prefixed with '#' is multiplied by width if hash is true
+func getBitOffsetOrReply(c *Client, o *Object, hash bool, width int) (int64, bool) {
+	str := o.Ptr.(string)
+	var mul int64 = 1
+	if hash && len(str) > 0 && str[0] == '#' {
+		str = str[1:]
+		mul = int64(width)
+	}
+
+	// the last bit accessed must be in a string of max size
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || v < 0 || v > (MaxBulkLen*8-int64(width))/mul {
+		addReplyError(c, "ERR bit offset is not an integer or out of range")
+		return 0, false
+	}
+	return v * mul, true
+}
+
+// getBitfieldTypeOrReply parses the type of BITFIELD like i8 or u16
+func getBitfieldTypeOrReply(c *Client, o *Object) (bool, int, bool) {
+	str := strings.ToLower(o.Ptr.(string))
+	if len(str) > 1 && (str[0] == 'i' || str[0] == 'u') {
+		signed := str[0] == 'i'
+		width, err := strconv.Atoi(str[1:])
+		if err == nil && width >= 1 && (signed && width <= 64 || !signed && width <= 63) {
+			return signed, width, true
+		}
+	}
+	addReplyError(c, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	return false, 0, false
+}
+
+// bitLookupWrite return the string stored at key which is created if not exists,
+// the string grows with 0 so that the byte at maxByte is accessible
+func bitLookupWrite(c *Client, key *Object, maxByte int64) (*Sdshdr, bool) {
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return nil, false
+	}
+	if str == nil {
+		str = SdsNewEmpty()
+		c.Db.Add(key, NewObject(OBJSDS, str))
+	}
+	str.SdsGrowZero(int(maxByte) + 1)
+	return str, true
+}
+
+// getBit return the bit at offset, bits after the end of buf are 0
+func getBit(buf []byte, offset int64) byte {
+	if offset>>3 >= int64(len(buf)) {
+		return 0
+	}
+	return buf[offset>>3] >> (7 - uint(offset&7)) & 1
+}
+
+// setBit sets the bit at offset and return the old one
+func setBit(buf []byte, offset int64, bit byte) byte {
+	shift := 7 - uint(offset&7)
+	old := buf[offset>>3] >> shift & 1
+	buf[offset>>3] = buf[offset>>3]&^(1<<shift) | bit<<shift
+	return old
+}
+
+// bitRange converts start and end of BITCOUNT and BITPOS into the inclusive range of bits in a string of length l,
+// they are indexes of bytes unless isBit is true, false is returned if the range is empty
+func bitRange(start int64, end int64, isBit bool, l int) (int64, int64, bool) {
+	total := l
+	if isBit {
+		total = l * 8
+	}
+	s, e, ok := sdsRangeIndex(int(start), int(end), total)
+	if !ok {
+		return 0, 0, false
+	}
+	if isBit {
+		return int64(s), int64(e), true
+	}
+	return int64(s) * 8, int64(e)*8 + 7, true
+}
+
+// getBitRangeOrReply parses the start, end and the optional unit BYTE|BIT of BITCOUNT and BITPOS
+func getBitRangeOrReply(c *Client, args []*Object) (start int64, end int64, isBit bool, ok bool) {
+	if start, ok = getInt64FromObjectOrReply(c, args[0]); !ok {
+		return
+	}
+	end = -1
+	if len(args) > 1 {
+		if end, ok = getInt64FromObjectOrReply(c, args[1]); !ok {
+			return
+		}
+	}
+	if len(args) > 2 {
+		switch strings.ToLower(args[2].Ptr.(string)) {
+		case "bit":
+			isBit = true
+		case "byte":
+		default:
+			addReplyError(c, "ERR syntax error")
+			return 0, 0, false, false
+		}
+	}
+	return start, end, isBit, true
+}
+
+// bitCount return the number of bits set to 1 from start to end
+func bitCount(buf []byte, start int64, end int64) int64 {
+	first, last := start>>3, end>>3
+	var count int64
+	for _, b := range buf[first : last+1] {
+		count += int64(bits.OnesCount8(b))
+	}
+	// exclude the bits out of range in the first and the last byte
+	count -= int64(bits.OnesCount8(buf[first] >> (8 - uint(start&7))))
+	count -= int64(bits.OnesCount8(buf[last] << (uint(end&7) + 1)))
+	return count
+}
+
+// bitPos return the position of the first bit which is equal to bit from start to end, -1 if not found
+func bitPos(buf []byte, bit byte, start int64, end int64) int64 {
+	first, last := start>>3, end>>3
+	for i := first; i <= last; i++ {
+		b := buf[i]
+		if bit == 0 {
+			b = ^b
+		}
+		if i == first {
+			b &= 0xff >> uint(start&7)
+		}
+		if i == last {
+			b &= 0xff << (7 - uint(end&7))
+		}
+		if b != 0 {
+			return i*8 + int64(bits.LeadingZeros8(b))
+		}
+	}
+	return -1
+}
+
+// getUnsignedBitfield reads an unsigned integer of width bits from offset
+func getUnsignedBitfield(buf []byte, offset int64, width int) uint64 {
+	var value uint64
+	for i := int64(0); i < int64(width); i++ {
+		value = value<<1 | uint64(getBit(buf, offset+i))
+	}
+	return value
+}
+
+// getSignedBitfield reads a signed integer of width bits from offset
+func getSignedBitfield(buf []byte, offset int64, width int) int64 {
+	value := getUnsignedBitfield(buf, offset, width)
+	// extend the sign bit
+	if width < 64 && value&(1<<uint(width-1)) != 0 {
+		value |= math.MaxUint64 << uint(width)
+	}
+	return int64(value)
+}
+
+// setUnsignedBitfield writes the lowest width bits of value from offset
+func setUnsignedBitfield(buf []byte, offset int64, width int, value uint64) {
+	for i := 0; i < width; i++ {
+		setBit(buf, offset+int64(i), byte(value>>uint(width-1-i))&1)
+	}
+}
+
+// checkUnsignedBitfieldOverflow return value+incr handled by the overflow behavior,
+// and whether it overflows an unsigned integer of width bits
+func checkUnsignedBitfieldOverflow(value uint64, incr int64, width int, overflow int) (uint64, bool) {
+	max := uint64(1)<<uint(width) - 1
+	switch {
+	case value > max || incr > 0 && uint64(incr) > max-value:
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return max, true
+		}
+	case incr < 0 && uint64(-incr) > value:
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return 0, true
+		}
+	default:
+		return value + uint64(incr), false
+	}
+	return (value + uint64(incr)) & max, true
+}
+
+// checkSignedBitfieldOverflow return value+incr handled by the overflow behavior,
+// and whether it overflows a signed integer of width bits
+func checkSignedBitfieldOverflow(value int64, incr int64, width int, overflow int) (int64, bool) {
+	var max int64 = math.MaxInt64
+	if width < 64 {
+		max = 1<<uint(width-1) - 1
+	}
+	min := -max - 1
+	switch {
+	case value > max || incr > 0 && value > max-incr:
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return max, true
+		}
+	case value < min || incr < 0 && value < min-incr:
+		if overflow == BITFIELD_OVERFLOW_SAT {
+			return min, true
+		}
+	default:
+		return value + incr, false
+	}
+
+	// wrap around by keeping the lowest width bits and extending the sign bit
+	result := uint64(value) + uint64(incr)
+	if width < 64 {
+		if result&(1<<uint(width-1)) != 0 {
+			result |= math.MaxUint64 << uint(width)
+		} else {
+			result &^= math.MaxUint64 << uint(width)
+		}
+	}
+	return int64(result), true
+}
+
+// SetBitCommand ...
+// SETBIT key offset value
+func SetBitCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'setbit' command")
+		return
+	}
+
+	offset, ok := getBitOffsetOrReply(c, c.Argv[2], false, 1)
+	if !ok {
+		return
+	}
+	value := c.Argv[3].Ptr.(string)
+	if value != "0" && value != "1" {
+		addReplyError(c, "ERR bit is not an integer or out of range")
+		return
+	}
+
+	str, ok := bitLookupWrite(c, c.Argv[1], offset>>3)
+	if !ok {
+		return
+	}
+	old := setBit(str.SdsBuf(), offset, value[0]-'0')
+	s.Dirty++
+	addReplyInt(c, int64(old))
+}
+
+// GetBitCommand ...
+// GETBIT key offset
+func GetBitCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'getbit' command")
+		return
+	}
+
+	offset, ok := getBitOffsetOrReply(c, c.Argv[2], false, 1)
+	if !ok {
+		return
+	}
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if str == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(getBit(str.SdsBuf(), offset)))
+}
+
+// BitCountCommand ...
+// BITCOUNT key [start end [BYTE|BIT]]
+func BitCountCommand(c *Client, s *Server) {
+	if c.Argc < 2 || c.Argc > 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'bitcount' command")
+		return
+	}
+	if c.Argc == 3 {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+
+	var start, end int64 = 0, -1
+	isBit := false
+	if c.Argc > 2 {
+		var ok bool
+		if start, end, isBit, ok = getBitRangeOrReply(c, c.Argv[2:]); !ok {
+			return
+		}
+	}
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if str == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	start, end, ok = bitRange(start, end, isBit, str.SdsLen())
+	if !ok {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, bitCount(str.SdsBuf(), start, end))
+}
+
+// BitPosCommand ...
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func BitPosCommand(c *Client, s *Server) {
+	if c.Argc < 3 || c.Argc > 6 {
+		addReplyError(c, "ERR wrong number of arguments for 'bitpos' command")
+		return
+	}
+
+	bitArg := c.Argv[2].Ptr.(string)
+	if bitArg != "0" && bitArg != "1" {
+		addReplyError(c, "ERR The bit argument must be 1 or 0.")
+		return
+	}
+	bit := bitArg[0] - '0'
+	var start, end int64 = 0, -1
+	isBit := false
+	if c.Argc > 3 {
+		var ok bool
+		if start, end, isBit, ok = getBitRangeOrReply(c, c.Argv[3:]); !ok {
+			return
+		}
+	}
+
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	// a key which doesn't exist is regarded as an empty string padded with 0
+	if str == nil {
+		if bit == 1 {
+			addReplyInt(c, -1)
+		} else {
+			addReplyInt(c, 0)
+		}
+		return
+	}
+
+	start, end, ok = bitRange(start, end, isBit, str.SdsLen())
+	if !ok {
+		addReplyInt(c, -1)
+		return
+	}
+	pos := bitPos(str.SdsBuf(), bit, start, end)
+	// the string is padded with 0 on the right if end isn't given
+	if pos == -1 && bit == 0 && c.Argc <= 4 {
+		pos = end + 1
+	}
+	addReplyInt(c, pos)
+}
+
+// BitOpCommand ...
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func BitOpCommand(c *Client, s *Server) {
+	if c.Argc < 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'bitop' command")
+		return
+	}
+
+	var op int
+	switch strings.ToLower(c.Argv[1].Ptr.(string)) {
+	case "and":
+		op = BITOP_AND
+	case "or":
+		op = BITOP_OR
+	case "xor":
+		op = BITOP_XOR
+	case "not":
+		op = BITOP_NOT
+	default:
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	if op == BITOP_NOT && c.Argc != 4 {
+		addReplyError(c, "ERR BITOP NOT must be called with a single source key.")
+		return
+	}
+
+	srcs := make([][]byte, 0, c.Argc-3)
+	maxLen := 0
+	for i := 3; i < c.Argc; i++ {
+		str, ok := stringLookupRead(c, c.Argv[i])
+		if !ok {
+			return
+		}
+		var buf []byte
+		if str != nil {
+			buf = str.SdsBuf()
+		}
+		srcs = append(srcs, buf)
+		if len(buf) > maxLen {
+			maxLen = len(buf)
+		}
+	}
+
+	// shorter strings are regarded as padded with 0
+	res := make([]byte, maxLen)
+	for i := 0; i < maxLen; i++ {
+		var b byte
+		for j, src := range srcs {
+			var v byte
+			if i < len(src) {
+				v = src[i]
+			}
+			switch {
+			case j == 0:
+				b = v
+			case op == BITOP_AND:
+				b &= v
+			case op == BITOP_OR:
+				b |= v
+			case op == BITOP_XOR:
+				b ^= v
+			}
+		}
+		if op == BITOP_NOT {
+			b = ^b
+		}
+		res[i] = b
+	}
+
+	dest := c.Argv[2]
+	if maxLen == 0 {
+		if c.Db.Delete(dest) {
+			s.Dirty++
+		}
+	} else {
+		c.Db.SetKey(dest, NewObject(OBJSDS, SdsNewBuf(res)), false)
+		s.Dirty++
+	}
+	addReplyInt(c, int64(maxLen))
+}
+
+// BitFieldCommand ...
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func BitFieldCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'bitfield' command")
+		return
+	}
+
+	ops := make([]*bitfieldOp, 0)
+	overflow := BITFIELD_OVERFLOW_WRAP
+	var maxByte int64 = -1
+	for i := 2; i < c.Argc; i++ {
+		sub := strings.ToLower(c.Argv[i].Ptr.(string))
+		if sub == "overflow" && i+1 < c.Argc {
+			i++
+			switch strings.ToLower(c.Argv[i].Ptr.(string)) {
+			case "wrap":
+				overflow = BITFIELD_OVERFLOW_WRAP
+			case "sat":
+				overflow = BITFIELD_OVERFLOW_SAT
+			case "fail":
+				overflow = BITFIELD_OVERFLOW_FAIL
+			default:
+				addReplyError(c, "ERR Invalid OVERFLOW type specified")
+				return
+			}
+			continue
+		}
+
+		op := &bitfieldOp{overflow: overflow}
+		switch {
+		case sub == "get" && i+2 < c.Argc:
+			op.opcode = BITFIELD_GET
+		case sub == "set" && i+3 < c.Argc:
+			op.opcode = BITFIELD_SET
+		case sub == "incrby" && i+3 < c.Argc:
+			op.opcode = BITFIELD_INCRBY
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		var ok bool
+		if op.signed, op.bits, ok = getBitfieldTypeOrReply(c, c.Argv[i+1]); !ok {
+			return
+		}
+		if op.offset, ok = getBitOffsetOrReply(c, c.Argv[i+2], true, op.bits); !ok {
+			return
+		}
+		i += 2
+		if op.opcode != BITFIELD_GET {
+			i++
+			if op.value, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			if last := (op.offset + int64(op.bits) - 1) >> 3; last > maxByte {
+				maxByte = last
+			}
+		}
+		ops = append(ops, op)
+	}
+
+	// the string is created and grown only if there are SET or INCRBY
+	var str *Sdshdr
+	var ok bool
+	if maxByte >= 0 {
+		str, ok = bitLookupWrite(c, c.Argv[1], maxByte)
+	} else {
+		str, ok = stringLookupRead(c, c.Argv[1])
+	}
+	if !ok {
+		return
+	}
+	var buf []byte
+	if str != nil {
+		buf = str.SdsBuf()
+	}
+
+	array := make([]*EncodeData, 0, len(ops))
+	for _, op := range ops {
+		var reply, value int64
+		failed := false
+		if op.signed {
+			old := getSignedBitfield(buf, op.offset, op.bits)
+			reply = old
+			switch op.opcode {
+			case BITFIELD_SET:
+				var overflowed bool
+				value, overflowed = checkSignedBitfieldOverflow(op.value, 0, op.bits, op.overflow)
+				failed = overflowed && op.overflow == BITFIELD_OVERFLOW_FAIL
+			case BITFIELD_INCRBY:
+				var overflowed bool
+				value, overflowed = checkSignedBitfieldOverflow(old, op.value, op.bits, op.overflow)
+				failed = overflowed && op.overflow == BITFIELD_OVERFLOW_FAIL
+				reply = value
+			}
+		} else {
+			old := getUnsignedBitfield(buf, op.offset, op.bits)
+			reply = int64(old)
+			var uvalue uint64
+			var overflowed bool
+			switch op.opcode {
+			case BITFIELD_SET:
+				uvalue, overflowed = checkUnsignedBitfieldOverflow(uint64(op.value), 0, op.bits, op.overflow)
+			case BITFIELD_INCRBY:
+				uvalue, overflowed = checkUnsignedBitfieldOverflow(old, op.value, op.bits, op.overflow)
+				reply = int64(uvalue)
+			}
+			failed = overflowed && op.overflow == BITFIELD_OVERFLOW_FAIL
+			value = int64(uvalue)
+		}
+
+		if failed {
+			array = append(array, NewBulk(nil))
+			continue
+		}
+		if op.opcode != BITFIELD_GET {
+			setUnsignedBitfield(buf, op.offset, op.bits, uint64(value))
+		}
+		array = append(array, NewInt([]byte(strconv.FormatInt(reply, 10))))
+	}
+
+	if maxByte >= 0 {
+		s.Dirty++
+	}
+	addReplyArray(c, array)
+}
@@ -0,0 +1,135 @@
+package godis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBitCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, args := range [][]string{
+		{"set", "foo", "foobar"},
+		{"set", "abc", "abcdef"},
+		{"set", "ones", "\xff\xf0\x00"},
+		{"set", "zeros", "\x00\xff\xf0"},
+		{"set", "full", "\xff\xff\xff"},
+		{"hset", "h", "f", "v"},
+	} {
+		execCommand(s, c, args...)
+	}
+
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"setbit b 7 1", ":0\r\n"},
+		{"get b", "$1\r\n\x01\r\n"},
+		{"setbit b 7 0", ":1\r\n"},
+		{"setbit b 17 1", ":0\r\n"},
+		{"get b", "$3\r\n\x00\x00\x40\r\n"},
+		{"getbit b 17", ":1\r\n"},
+		{"getbit b 100", ":0\r\n"},
+		{"getbit nope 0", ":0\r\n"},
+		{"setbit b -1 1", "-ERR bit offset is not an integer or out of range\r\n"},
+		{"setbit b 4294967296 1", "-ERR bit offset is not an integer or out of range\r\n"},
+		{"setbit b 0 2", "-ERR bit is not an integer or out of range\r\n"},
+		{"setbit h 0 1", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{"bitcount foo", ":26\r\n"},
+		{"bitcount foo 0 0", ":4\r\n"},
+		{"bitcount foo 1 1", ":6\r\n"},
+		{"bitcount foo -2 -1", ":7\r\n"},
+		{"bitcount foo 5 30 bit", ":17\r\n"},
+		{"bitcount foo 3 2", ":0\r\n"},
+		{"bitcount foo 0", "-ERR syntax error\r\n"},
+		{"bitcount nope", ":0\r\n"},
+
+		{"bitpos ones 0", ":12\r\n"},
+		{"bitpos zeros 1 0", ":8\r\n"},
+		{"bitpos zeros 1 2", ":16\r\n"},
+		{"bitpos zeros 1 2 -1 byte", ":16\r\n"},
+		{"bitpos zeros 1 7 15 bit", ":8\r\n"},
+		{"bitpos zeros 1 17 -1 bit", ":17\r\n"},
+		{"bitpos full 0", ":24\r\n"},
+		{"bitpos full 0 0 -1", ":-1\r\n"},
+		{"bitpos nope 0", ":0\r\n"},
+		{"bitpos nope 1", ":-1\r\n"},
+		{"bitpos foo 2", "-ERR The bit argument must be 1 or 0.\r\n"},
+
+		{"bitop and dest foo abc", ":6\r\n"},
+		{"get dest", "$6\r\n`bc`ab\r\n"},
+		{"bitop or dest foo abc", ":6\r\n"},
+		{"get dest", "$6\r\ngoofev\r\n"},
+		{"bitop xor dest foo b", ":6\r\n"},
+		{"get dest", "$6\r\nfo/bar\r\n"},
+		{"bitop not dest ones", ":3\r\n"},
+		{"get dest", "$3\r\n\x00\x0f\xff\r\n"},
+		{"bitop not dest foo abc", "-ERR BITOP NOT must be called with a single source key.\r\n"},
+		{"bitop and dest nope", ":0\r\n"},
+		{"exists dest", ":0\r\n"},
+		{"bitop nand dest foo", "-ERR syntax error\r\n"},
+
+		{"bitfield bf incrby i5 100 1 get u4 0", "*2\r\n:1\r\n:0\r\n"},
+		{"bitfield bf2 set i8 #0 -100 get i8 0 get u8 0", "*3\r\n:0\r\n:-100\r\n:156\r\n"},
+		{"bitfield bf2 overflow sat incrby i8 0 -100", "*1\r\n:-128\r\n"},
+		{"bitfield bf2 overflow wrap incrby i8 0 -100", "*1\r\n:28\r\n"},
+		{"bitfield bf2 overflow fail incrby i8 0 100 incrby i8 0 99", "*2\r\n$-1\r\n:127\r\n"},
+		{"bitfield bf2 overflow sat set u4 #2 100 get u4 8", "*2\r\n:0\r\n:15\r\n"},
+		{"bitfield bf2 set i64 #1 -1 get u63 64", "*2\r\n:0\r\n:9223372036854775807\r\n"},
+		{"bitfield bf2 overflow wrap incrby i64 #1 -9223372036854775808", "*1\r\n:9223372036854775807\r\n"},
+		{"bitfield nope get u8 0", "*1\r\n:0\r\n"},
+		{"exists nope", ":0\r\n"},
+		{"bitfield bf3 overflow fail incrby u2 100 4", "*1\r\n$-1\r\n"},
+		{"strlen bf3", ":13\r\n"},
+		{"bitfield bf get u64 0", "-ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.\r\n"},
+		{"bitfield bf overflow none get u8 0", "-ERR Invalid OVERFLOW type specified\r\n"},
+		{"bitfield bf get u8", "-ERR syntax error\r\n"},
+		{"bitfield bf get u8 4294967289", "-ERR bit offset is not an integer or out of range\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, key := range []string{"b", "bf", "bf2", "bf3", "dest"} {
+		if r, expect := execCommand(loaded, lc, "get", key), execCommand(s, c, "get", key); r != expect {
+			t.Fatalf("%s is %q after loading, expect %q", key, r, expect)
+		}
+	}
+}
+
+// TestBitfieldOverflow checks the edge of every width
+func TestBitfieldOverflow(t *testing.T) {
+	for width := 1; width <= 64; width++ {
+		var max int64 = 1<<uint(width-1) - 1
+		min := -max - 1
+		if v, overflowed := checkSignedBitfieldOverflow(max, 1, width, BITFIELD_OVERFLOW_WRAP); !overflowed || v != min {
+			t.Fatalf("i%d: max+1 wraps to %d, overflowed %v", width, v, overflowed)
+		}
+		if v, overflowed := checkSignedBitfieldOverflow(min, -1, width, BITFIELD_OVERFLOW_SAT); !overflowed || v != min {
+			t.Fatalf("i%d: min-1 saturates to %d, overflowed %v", width, v, overflowed)
+		}
+		if v, overflowed := checkSignedBitfieldOverflow(min, max, width, BITFIELD_OVERFLOW_FAIL); overflowed || v != -1 {
+			t.Fatalf("i%d: min+max is %d, overflowed %v", width, v, overflowed)
+		}
+		if width == 64 {
+			continue
+		}
+		umax := uint64(1)<<uint(width) - 1
+		if v, overflowed := checkUnsignedBitfieldOverflow(umax, 1, width, BITFIELD_OVERFLOW_WRAP); !overflowed || v != 0 {
+			t.Fatalf("u%d: max+1 wraps to %d, overflowed %v", width, v, overflowed)
+		}
+		if v, overflowed := checkUnsignedBitfieldOverflow(0, -1, width, BITFIELD_OVERFLOW_SAT); !overflowed || v != 0 {
+			t.Fatalf("u%d: 0-1 saturates to %d, overflowed %v", width, v, overflowed)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package godis
+
+import (
+	"strconv"
+	"time"
+)
+
+// blockingState stores what a blocked client is waiting for
+type blockingState struct {
+	keys     []*Object
+	objType  int       // the type of value the client waits for
+	deadline time.Time // zero if the client blocks forever
+	// serve is called with the key that is ready, it pops the value and replies the client
+	serve        func(c *Client, s *Server, key *Object)
+	timeoutReply func(c *Client)
+	// ready replaces blockingKeyReady if it isn't nil, for clients waiting for more than a non-empty value
+	ready func(key *Object, value *Object) bool
+}
+
+// getBlockingTimeoutOrReply parse the timeout in seconds of blocking commands,
+// the zero time returned means blocking forever
+func getBlockingTimeoutOrReply(c *Client, o *Object) (time.Time, bool) {
+	timeout, err := strconv.ParseFloat(o.Ptr.(string), 64)
+	if err != nil {
+		addReplyError(c, "ERR timeout is not a float or out of range")
+		return time.Time{}, false
+	}
+	if timeout < 0 {
+		addReplyError(c, "ERR timeout is negative")
+		return time.Time{}, false
+	}
+	if timeout == 0 {
+		return time.Time{}, true
+	}
+	return time.Now().Add(time.Duration(timeout * float64(time.Second))), true
+}
+
+// blockingKeyReady return true if value can be popped by the client waiting for objType
+func blockingKeyReady(value *Object, objType int) bool {
+	if value == nil || value.ObjectType != objType {
+		return false
+	}
+	switch objType {
+	case OBJList:
+		return value.Ptr.(*List).Length() > 0
+	case OBJZset:
+		return value.Ptr.(*ZskipList).length > 0
+	}
+	return false
+}
+
+// keyReady return true if value stored at key can be served to the client
+func (bs *blockingState) keyReady(key *Object, value *Object) bool {
+	if bs.ready != nil {
+		return value.ObjectType == bs.objType && bs.ready(key, value)
+	}
+	return blockingKeyReady(value, bs.objType)
+}
+
+// blockForKeys blocks client until one of keys is ready or timeout,
+// the client is served in the order it's blocked
+func blockForKeys(c *Client, keys []*Object, objType int, deadline time.Time,
+	serve func(c *Client, s *Server, key *Object), timeoutReply func(c *Client)) {
+	c.blocked = &blockingState{
+		keys:         keys,
+		objType:      objType,
+		deadline:     deadline,
+		serve:        serve,
+		timeoutReply: timeoutReply,
+	}
+	for _, key := range keys {
+		k := key.Ptr.(string)
+		c.Db.blockingKeys[k] = append(c.Db.blockingKeys[k], c)
+	}
+}
+
+// unblockClient removes client from the clients blocked on its keys
+func unblockClient(c *Client) {
+	for _, key := range c.blocked.keys {
+		k := key.Ptr.(string)
+		clients := c.Db.blockingKeys[k]
+		for i, client := range clients {
+			if client == c {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(c.Db.blockingKeys, k)
+		} else {
+			c.Db.blockingKeys[k] = clients
+		}
+	}
+	c.blocked = nil
+}
+
+// signalKeyAsReady marks key as ready if there are clients blocked on it
+func (db *GodisDB) signalKeyAsReady(key *Object) {
+	k := key.Ptr.(string)
+	if _, ok := db.blockingKeys[k]; !ok {
+		return
+	}
+	for _, ready := range db.readyKeys {
+		if ready == k {
+			return
+		}
+	}
+	db.readyKeys = append(db.readyKeys, k)
+}
+
+// signalBlockingKeys marks all the keys clients blocked on as ready
+func (db *GodisDB) signalBlockingKeys() {
+	for k := range db.blockingKeys {
+		db.signalKeyAsReady(NewObject(OBJString, k))
+	}
+}
+
+// handleClientsBlockedOnKeys serves the clients blocked on the keys which are ready,
+// serving clients may make other keys ready so it loops until there is no ready key
+func (s *Server) handleClientsBlockedOnKeys() {
+	for {
+		handled := false
+		for _, db := range s.Db {
+			readyKeys := db.readyKeys
+			db.readyKeys = nil
+			for _, k := range readyKeys {
+				s.serveClientsBlockedOnKey(db, NewObject(OBJString, k))
+				handled = true
+			}
+		}
+		if !handled {
+			return
+		}
+	}
+}
+
+// serveClientsBlockedOnKey serves the clients blocked on key one by one until key is empty
+func (s *Server) serveClientsBlockedOnKey(db *GodisDB, key *Object) {
+	clients := append([]*Client(nil), db.blockingKeys[key.Ptr.(string)]...)
+	for _, c := range clients {
+		bs := c.blocked
+		if bs == nil {
+			continue
+		}
+		value := lookupKeyInDB(c, db, key)
+		if value == nil {
+			return
+		}
+		if !bs.keyReady(key, value) {
+			// the client waits for another type or newer entries
+			continue
+		}
+
+		unblockClient(c)
+		dirty := s.Dirty
+		c.propagate = nil
+		bs.serve(c, s, key)
+		if dirty < s.Dirty {
+			s.propagateCommand(db.ID, c)
+		}
+		// virtual clients are never waiting for the notification
+		select {
+		case c.unblocked <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// isBlocked return true if the latest command of client is blocked
+func (s *Server) isBlocked(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.blocked != nil
+}
+
+// waitUnblocked waits until the blocked client is served or timeout, and writes the reply,
+// an error is returned if the connection is closed
+func (s *Server) waitUnblocked(c *Client) error {
+	s.mu.Lock()
+	deadline := c.blocked.deadline
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// closing of connection is found by reading from it, the content read is kept in decoder
+	received := make(chan error, 1)
+	go func() {
+		_, err := c.decoder.ByteReader.GlanceByte()
+		received <- err
+	}()
+
+	for {
+		select {
+		case <-c.unblocked:
+		case <-timeout:
+			s.mu.Lock()
+			if bs := c.blocked; bs != nil {
+				unblockClient(c)
+				bs.timeoutReply(c)
+			} else {
+				// served just before timeout
+				<-c.unblocked
+			}
+			s.mu.Unlock()
+		case err := <-received:
+			if err == nil {
+				// the client sent commands while blocked, they're processed after it's unblocked
+				received = nil
+				continue
+			}
+			s.mu.Lock()
+			if c.blocked != nil {
+				unblockClient(c)
+			}
+			s.mu.Unlock()
+			return err
+		}
+		break
+	}
+
+	if err := c.WriteReply(); err != nil {
+		return err
+	}
+	if received != nil {
+		// wait for the next command before reading from decoder again
+		return <-received
+	}
+	return nil
+}
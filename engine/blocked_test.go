@@ -0,0 +1,128 @@
+package godis
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlockingPops(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	waiters := make([]*Client, 4)
+	for i := range waiters {
+		waiters[i] = s.CreateClient(nil)
+	}
+	for _, tc := range []struct {
+		c      *Client
+		cmd    string
+		expect string
+	}{
+		{c, "rpush l a", ":1\r\n"},
+		{c, "blpop nope l 0", "*2\r\n$1\r\nl\r\n$1\r\na\r\n"},
+		{c, "blpop l -1", "-ERR timeout is negative\r\n"},
+		{c, "set str v", "+OK\r\n"},
+		{c, "brpop str 0", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{waiters[0], "blpop l l2 0", ""},
+		{waiters[1], "brpop l 0", ""},
+		{waiters[2], "blmove l2 dst left right 0", ""},
+		{waiters[3], "bzpopmin z 0", ""},
+		{c, "rpush l x y z", ":3\r\n"},
+		{waiters[0], "", "*2\r\n$1\r\nl\r\n$1\r\nx\r\n"},
+		{waiters[1], "", "*2\r\n$1\r\nl\r\n$1\r\nz\r\n"},
+		{c, "lrange l 0 -1", "*1\r\n$1\r\ny\r\n"},
+		{c, "lpush l2 m", ":1\r\n"},
+		{waiters[2], "", "$1\r\nm\r\n"},
+		{c, "exists l2", ":0\r\n"},
+		{c, "zadd z 2 b 1 a", ":2\r\n"},
+		{waiters[3], "", "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{c, "bzpopmax z 0", "*3\r\n$1\r\nz\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+	} {
+		if tc.cmd == "" {
+			// check the reply of the client blocked before
+			if r := *tc.c.Buf.SdsGetString(); r != tc.expect {
+				t.Fatalf("blocked client receives %q, expect %q", r, tc.expect)
+			}
+			continue
+		}
+		r := execCommand(s, tc.c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+	for _, db := range s.Db {
+		if len(db.blockingKeys) != 0 {
+			t.Fatalf("clients are still blocked on %v", db.blockingKeys)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "lrange", "dst", "0", "-1"); r != "*1\r\n$1\r\nm\r\n" {
+		t.Fatalf("dst is %q after loading", r)
+	}
+	if r := execCommand(loaded, lc, "lrange", "l", "0", "-1"); r != "*1\r\n$1\r\ny\r\n" {
+		t.Fatalf("l is %q after loading", r)
+	}
+	if r := execCommand(loaded, lc, "exists", "z"); r != ":0\r\n" {
+		t.Fatalf("z is %q after loading", r)
+	}
+}
+
+func TestBlockingPopConnection(t *testing.T) {
+	s, addr, stop := newTestServer(t)
+	defer stop()
+
+	waiter := dialTestServer(t, addr)
+	defer waiter.conn.Close()
+	start := time.Now()
+	if r, err := waiter.do("blpop", "l", "0.1"); err != nil || r.Type != TypeMultiBulk || r.Array != nil {
+		t.Fatalf("blpop replies %+v after timeout, err:%v", r, err)
+	}
+	if time.Since(start) < 100*time.Millisecond {
+		t.Fatal("blpop returns before timeout")
+	}
+
+	// the client closed while blocked doesn't consume the value
+	closed := dialTestServer(t, addr)
+	if err := closed.send([]string{"blpop", "l", "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := waiter.send([]string{"brpop", "l", "0"}, []string{"exists", "l"}); err != nil {
+		t.Fatal(err)
+	}
+	waitBlockedClients(t, s, "l", 2)
+	closed.conn.Close()
+	waitBlockedClients(t, s, "l", 1)
+
+	pusher := dialTestServer(t, addr)
+	defer pusher.conn.Close()
+	if r, err := pusher.do("rpush", "l", "a", "b"); err != nil || string(r.Value) != "2" {
+		t.Fatalf("rpush replies %+v, err:%v", r, err)
+	}
+	if r, err := waiter.decoder.Decode(); err != nil || len(r.Array) != 2 || string(r.Array[1].Value) != "b" {
+		t.Fatalf("brpop replies %+v, err:%v", r, err)
+	}
+	if r, err := waiter.decoder.Decode(); err != nil || string(r.Value) != "1" {
+		t.Fatalf("command sent while blocked replies %+v, err:%v", r, err)
+	}
+}
+
+// waitBlockedClients waits until there are n clients blocked on key
+func waitBlockedClients(t *testing.T, s *Server, key string, n int) {
+	for i := 0; i < 100; i++ {
+		s.mu.Lock()
+		blocked := len(s.Db[0].blockingKeys[key])
+		s.mu.Unlock()
+		if blocked == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("clients blocked on %s aren't %d", key, n)
+}
@@ -12,6 +12,15 @@ type GodisConfig struct {
 	OutputToTerminal bool
 	LogDir           string
 	SCFFileName      string
+	SnapshotFileName string
+	Databases        int
+	// scf is rewritten when it grows by SCFRewritePercentage percent and is larger than SCFRewriteMinSize
+	SCFRewritePercentage int
+	SCFRewriteMinSize    int64
+	SCFFsync             string // always, everysec or no
+	SCFChecksum          bool
+	SCFLoadTruncated     bool
+	PubsubOutputLimit    int64 // bytes, subscribed client exceeding it in output buffer is disconnected
 	*viper.Viper
 }
 
@@ -39,6 +48,13 @@ func ParseConf() {
 	v.SetDefault("OutputToTerminal", true)
 	v.SetDefault("LogDir", "./log/")
 	v.SetDefault("SCFFile", "./SCF/")
+	v.SetDefault("Databases", DEFAULT_DB_NUM)
+	v.SetDefault("SCFRewritePercentage", SCF_REWRITE_PERC)
+	v.SetDefault("SCFRewriteMinSize", SCF_REWRITE_MIN_SIZE)
+	v.SetDefault("SCFFsync", "everysec")
+	v.SetDefault("SCFChecksum", false)
+	v.SetDefault("SCFLoadTruncated", true)
+	v.SetDefault("PubsubOutputLimit", PUBSUB_OUTPUT_LIMIT)
 
 	godisConf = &GodisConfig{}
 	if err := v.Unmarshal(godisConf); err != nil {
@@ -3,14 +3,19 @@ package godis
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"testing"
 )
 
-func Test_ParseConf() {
+func Test_ParseConf(t *testing.T) {
+	if _, err := os.Stat("./godis_conf.toml"); err != nil {
+		t.Skip("godis_conf.toml is not in the working directory")
+	}
 	ParseConf()
 	fmt.Println(godisConf)
 }
 
-func Test_Bufio2() {
+func Test_Bufio2(t *testing.T) {
 	buf := make([]byte, 20)
 	b := bytes.Buffer{}
 
@@ -0,0 +1,593 @@
+package godis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nk-akun/godis/engine/util"
+)
+
+const (
+	ACTIVE_EXPIRE_CYCLE_LOOKUPS = 20
+	ACTIVE_EXPIRE_CYCLE_TIME    = 25 * time.Millisecond
+	RANDOMKEY_MAX_TRIES         = 100
+)
+
+// mstime return the unix time in milliseconds
+func mstime() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// lookupKey return the value of key in the db selected by client,
+// the key is deleted first if it has been expired
+func lookupKey(c *Client, key *Object) *Object {
+	return lookupKeyInDB(c, c.Db, key)
+}
+
+// lookupKeyInDB return the value of key in db like lookupKey
+func lookupKeyInDB(c *Client, db *GodisDB, key *Object) *Object {
+	expireIfNeeded(c, db, key)
+	value := db.Dt.Get(key)
+	if value != nil {
+		// the value may be changed in place by the caller
+		db.preserveKey(key)
+	}
+	return value
+}
+
+// expireIfNeeded delete key of db if it's expired, return true if the key is logically expired.
+// The key is kept when the server is loading data so that the replay is the same as before
+func expireIfNeeded(c *Client, db *GodisDB, key *Object) bool {
+	if !db.isExpired(key) {
+		return false
+	}
+	if c.VirtualFlag {
+		return false
+	}
+	db.Delete(key)
+	return true
+}
+
+// Add add key into db, nothing will be done if key exists
+func (db *GodisDB) Add(key *Object, value *Object) {
+	db.preserveKey(key)
+	db.Dt.Add(key, value)
+	db.signalKeyAsReady(key)
+}
+
+// SetKey set value of key no matter whether key exists or not,
+// the timeout of key is removed unless keepTTL is true
+func (db *GodisDB) SetKey(key *Object, value *Object, keepTTL bool) {
+	db.preserveKey(key)
+	if node := db.Dt.Search(key); node != nil {
+		node.value = value
+	} else {
+		db.Dt.Add(key, value)
+	}
+	db.signalKeyAsReady(key)
+	if !keepTTL {
+		db.RemoveExpire(key)
+	}
+}
+
+// Delete delete key and its timeout from db, return true if key exists
+func (db *GodisDB) Delete(key *Object) bool {
+	db.preserveKey(key)
+	if db.Expires.Size() > 0 {
+		db.Expires.Delete(key)
+	}
+	return db.Dt.Delete(key) == DICT_OK
+}
+
+// SetExpire set the unix time in milliseconds when key will be expired
+func (db *GodisDB) SetExpire(key *Object, when int64) {
+	node := db.Dt.Search(key)
+	if node == nil {
+		return
+	}
+	db.preserveKey(key)
+	if e := db.Expires.Search(key); e != nil {
+		e.value = NewObject(OBJInt, when)
+		return
+	}
+	db.Expires.Add(node.key, NewObject(OBJInt, when))
+}
+
+// GetExpire return the unix time in milliseconds when key will be expired,
+// -1 if key has no timeout
+func (db *GodisDB) GetExpire(key *Object) int64 {
+	if db.Expires.Size() == 0 {
+		return -1
+	}
+	e := db.Expires.Get(key)
+	if e == nil {
+		return -1
+	}
+	return e.Ptr.(int64)
+}
+
+// RemoveExpire remove the timeout of key, return true if key had a timeout
+func (db *GodisDB) RemoveExpire(key *Object) bool {
+	if db.Expires.Size() == 0 {
+		return false
+	}
+	db.preserveKey(key)
+	return db.Expires.Delete(key) == DICT_OK
+}
+
+func (db *GodisDB) isExpired(key *Object) bool {
+	when := db.GetExpire(key)
+	return when >= 0 && when <= mstime()
+}
+
+// activeExpireCycle samples keys with timeout and deletes the expired ones,
+// it goes on while more than a quarter of the sampled keys are expired
+func (db *GodisDB) activeExpireCycle(deadline time.Time) {
+	for db.Expires.Size() > 0 {
+		num := db.Expires.Size()
+		if num > ACTIVE_EXPIRE_CYCLE_LOOKUPS {
+			num = ACTIVE_EXPIRE_CYCLE_LOOKUPS
+		}
+
+		now := mstime()
+		expired := 0
+		for i := 0; i < num; i++ {
+			node := db.Expires.GetRandomKey()
+			if node == nil {
+				break
+			}
+			if key := node.key; node.value.Ptr.(int64) <= now {
+				db.Delete(key)
+				expired++
+			}
+		}
+
+		if expired <= ACTIVE_EXPIRE_CYCLE_LOOKUPS/4 || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+// Flush removes all the keys of db, the old dicts are left to gc
+func (db *GodisDB) Flush() {
+	db.finishSnapshots()
+	empty := InitDB(db.ID)
+	db.Dt = empty.Dt
+	db.Expires = empty.Expires
+}
+
+// dbEntry is a copy of a key taken for the background persistence
+type dbEntry struct {
+	key    string
+	value  *Object
+	expire int64
+}
+
+// dbSnapshot is a copy of db at the time it's taken. The keys are copied batch by batch
+// while db keeps serving commands, and a key is copied before it's changed for the first
+// time so that its value in the copy is still the one at the time the snapshot is taken
+type dbSnapshot struct {
+	db      *GodisDB
+	now     int64               // keys expired at the time the snapshot is taken are skipped
+	cursor  uint64              // cursor of scanning db.Dt for the keys not copied yet
+	done    bool                // all the keys are copied
+	copied  map[string]struct{} // keys copied or not existing when the snapshot is taken
+	entries []*dbEntry
+}
+
+// startSnapshot starts taking the snapshot of db, the keys are copied by copyBatch later
+func (db *GodisDB) startSnapshot() *dbSnapshot {
+	snap := &dbSnapshot{db: db, now: mstime(), copied: make(map[string]struct{})}
+	db.snapshots = append(db.snapshots, snap)
+	return snap
+}
+
+// preserveKey copies key into the snapshots being taken before it's changed
+func (db *GodisDB) preserveKey(key *Object) {
+	for _, snap := range db.snapshots {
+		if _, ok := snap.copied[key.Ptr.(string)]; !ok {
+			snap.copyKey(key, db.Dt.Get(key))
+		}
+	}
+}
+
+// finishSnapshots copies all the keys into the snapshots being taken,
+// it's called before the dicts of db are replaced
+func (db *GodisDB) finishSnapshots() {
+	for len(db.snapshots) > 0 {
+		db.snapshots[0].copyBatch()
+	}
+}
+
+// copyKey records value of key in snap, value is nil if key doesn't exist
+func (snap *dbSnapshot) copyKey(key *Object, value *Object) {
+	name := key.Ptr.(string)
+	snap.copied[name] = struct{}{}
+	if value == nil {
+		return
+	}
+	expire := snap.db.GetExpire(key)
+	if expire != -1 && expire <= snap.now {
+		return
+	}
+	snap.entries = append(snap.entries, &dbEntry{key: name, value: dupObject(value), expire: expire})
+}
+
+// copyBatch copies about SNAPSHOT_COPY_BATCH keys not copied yet, it return true
+// when all the keys are copied and snap is detached from db
+func (snap *dbSnapshot) copyBatch() bool {
+	if snap.done {
+		return true
+	}
+	snap.cursor = scanDict(snap.db.Dt, snap.cursor, SNAPSHOT_COPY_BATCH, func(node *DictNode) {
+		if _, ok := snap.copied[node.key.Ptr.(string)]; !ok {
+			snap.copyKey(node.key, node.value)
+		}
+	})
+	if snap.cursor != 0 {
+		return false
+	}
+
+	snap.done = true
+	for i, s := range snap.db.snapshots {
+		if s == snap {
+			snap.db.snapshots = append(snap.db.snapshots[:i], snap.db.snapshots[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// DelCommand ...
+func DelCommand(c *Client, s *Server) {
+	delGenericCommand(c, s, "del")
+}
+
+// UnlinkCommand ...
+// the value is released by gc, so UNLINK is the same as DEL
+func UnlinkCommand(c *Client, s *Server) {
+	delGenericCommand(c, s, "unlink")
+}
+
+func delGenericCommand(c *Client, s *Server, name string) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	deleted := 0
+	for _, key := range c.Argv[1:] {
+		expireIfNeeded(c, c.Db, key)
+		if c.Db.Delete(key) {
+			deleted++
+		}
+	}
+	s.Dirty += int64(deleted)
+	addReplyInt(c, int64(deleted))
+}
+
+// ExistsCommand ...
+func ExistsCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'exists' command")
+		return
+	}
+
+	count := 0
+	for _, key := range c.Argv[1:] {
+		if lookupKey(c, key) != nil {
+			count++
+		}
+	}
+	addReplyInt(c, int64(count))
+}
+
+// TouchCommand ...
+func TouchCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'touch' command")
+		return
+	}
+
+	count := 0
+	for _, key := range c.Argv[1:] {
+		if lookupKey(c, key) != nil {
+			count++
+		}
+	}
+	addReplyInt(c, int64(count))
+}
+
+// TypeCommand ...
+func TypeCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'type' command")
+		return
+	}
+
+	value := lookupKey(c, c.Argv[1])
+	if value == nil {
+		addReplyStatus(c, "none")
+		return
+	}
+	addReplyStatus(c, objectTypeName(value))
+}
+
+// RenameCommand ...
+func RenameCommand(c *Client, s *Server) {
+	renameGenericCommand(c, s, "rename", false)
+}
+
+// RenameNXCommand ...
+func RenameNXCommand(c *Client, s *Server) {
+	renameGenericCommand(c, s, "renamenx", true)
+}
+
+// renameGenericCommand moves the value and timeout of argv[1] to argv[2],
+// nothing is done if nx is true and argv[2] exists
+func renameGenericCommand(c *Client, s *Server, name string, nx bool) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	src, dst := c.Argv[1], c.Argv[2]
+	value := lookupKey(c, src)
+	if value == nil {
+		addReplyError(c, "ERR no such key")
+		return
+	}
+	if src.Ptr.(string) == dst.Ptr.(string) {
+		if nx {
+			addReplyInt(c, 0)
+		} else {
+			addReplyStatus(c, "OK")
+		}
+		return
+	}
+	if nx && lookupKey(c, dst) != nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	expire := c.Db.GetExpire(src)
+	c.Db.Delete(src)
+	c.Db.SetKey(dst, value, false)
+	if expire != -1 {
+		c.Db.SetExpire(dst, expire)
+	}
+	s.Dirty++
+	if nx {
+		addReplyInt(c, 1)
+	} else {
+		addReplyStatus(c, "OK")
+	}
+}
+
+// CopyCommand ...
+// COPY source destination [DB destination-db] [REPLACE]
+func CopyCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'copy' command")
+		return
+	}
+
+	dstDb := c.Db
+	replace := false
+	for i := 3; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		if opt == "replace" {
+			replace = true
+		} else if opt == "db" && i+1 < c.Argc {
+			i++
+			var ok bool
+			if dstDb, ok = getDBOrReply(c, s, c.Argv[i]); !ok {
+				return
+			}
+		} else {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	src, dst := c.Argv[1], c.Argv[2]
+	if dstDb == c.Db && src.Ptr.(string) == dst.Ptr.(string) {
+		addReplyError(c, "ERR source and destination objects are the same")
+		return
+	}
+	value := lookupKey(c, src)
+	if value == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	if lookupKeyInDB(c, dstDb, dst) != nil && !replace {
+		addReplyInt(c, 0)
+		return
+	}
+
+	dstDb.SetKey(dst, dupObject(value), false)
+	if expire := c.Db.GetExpire(src); expire != -1 {
+		dstDb.SetExpire(dst, expire)
+	}
+	s.Dirty++
+	addReplyInt(c, 1)
+}
+
+// KeysCommand ...
+func KeysCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'keys' command")
+		return
+	}
+
+	pattern := c.Argv[1].Ptr.(string)
+	keys := make([]*EncodeData, 0)
+	iter := NewSafeDictIterator(c.Db.Dt)
+	defer ReleaseIterator(iter)
+	for node := iter.Next(); node != nil; node = iter.Next() {
+		key := node.key.Ptr.(string)
+		if (pattern == "*" || util.StringMatch(pattern, key, false)) && !c.Db.isExpired(node.key) {
+			keys = append(keys, NewBulk([]byte(key)))
+		}
+	}
+	addReplyArray(c, keys)
+}
+
+// RandomKeyCommand ...
+func RandomKeyCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'randomkey' command")
+		return
+	}
+
+	for tries := 0; ; tries++ {
+		node := c.Db.Dt.GetRandomKey()
+		if node == nil {
+			addReplyNull(c)
+			return
+		}
+		// give up deleting when there are too many expired keys
+		key := node.key
+		if tries < RANDOMKEY_MAX_TRIES && expireIfNeeded(c, c.Db, key) {
+			continue
+		}
+		addReplyBulk(c, key.Ptr.(string))
+		return
+	}
+}
+
+// DBSizeCommand ...
+func DBSizeCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'dbsize' command")
+		return
+	}
+	addReplyInt(c, int64(c.Db.Dt.Size()))
+}
+
+// getDBOrReply return the db whose index is o, reply error to client if the index is invalid
+func getDBOrReply(c *Client, s *Server, o *Object) (*GodisDB, bool) {
+	id, ok := getInt64FromObjectOrReply(c, o)
+	if !ok {
+		return nil, false
+	}
+	if id < 0 || id >= int64(len(s.Db)) {
+		addReplyError(c, "ERR DB index is out of range")
+		return nil, false
+	}
+	return s.Db[id], true
+}
+
+// SelectCommand ...
+func SelectCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'select' command")
+		return
+	}
+
+	db, ok := getDBOrReply(c, s, c.Argv[1])
+	if !ok {
+		return
+	}
+	c.Db = db
+	addReplyStatus(c, "OK")
+}
+
+// MoveCommand ...
+func MoveCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'move' command")
+		return
+	}
+
+	dstDb, ok := getDBOrReply(c, s, c.Argv[2])
+	if !ok {
+		return
+	}
+	if dstDb == c.Db {
+		addReplyError(c, "ERR source and destination objects are the same")
+		return
+	}
+	key := c.Argv[1]
+	value := lookupKey(c, key)
+	if value == nil || lookupKeyInDB(c, dstDb, key) != nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	expire := c.Db.GetExpire(key)
+	c.Db.Delete(key)
+	dstDb.SetKey(key, value, false)
+	if expire != -1 {
+		dstDb.SetExpire(key, expire)
+	}
+	s.Dirty++
+	addReplyInt(c, 1)
+}
+
+// SwapDBCommand ...
+func SwapDBCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'swapdb' command")
+		return
+	}
+
+	db1, ok := getDBOrReply(c, s, c.Argv[1])
+	if !ok {
+		return
+	}
+	db2, ok := getDBOrReply(c, s, c.Argv[2])
+	if !ok {
+		return
+	}
+	// clients keep pointing to the same GodisDB, so the contents are swapped
+	db1.finishSnapshots()
+	db2.finishSnapshots()
+	db1.Dt, db2.Dt = db2.Dt, db1.Dt
+	db1.Expires, db2.Expires = db2.Expires, db1.Expires
+	db1.signalBlockingKeys()
+	db2.signalBlockingKeys()
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// FlushDBCommand ...
+// FLUSHDB [ASYNC|SYNC]
+func FlushDBCommand(c *Client, s *Server) {
+	if !checkFlushOptionOrReply(c, "flushdb") {
+		return
+	}
+	c.Db.Flush()
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// FlushAllCommand ...
+// FLUSHALL [ASYNC|SYNC]
+func FlushAllCommand(c *Client, s *Server) {
+	if !checkFlushOptionOrReply(c, "flushall") {
+		return
+	}
+	for _, db := range s.Db {
+		db.Flush()
+	}
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// checkFlushOptionOrReply checks the option of flush commands. The removed keys are
+// released by gc in background, so ASYNC is accepted but it's the same as SYNC
+func checkFlushOptionOrReply(c *Client, name string) bool {
+	if c.Argc > 2 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return false
+	}
+	if c.Argc == 2 {
+		opt := strings.ToLower(c.Argv[1].Ptr.(string))
+		if opt != "async" && opt != "sync" {
+			addReplyError(c, "ERR syntax error")
+			return false
+		}
+	}
+	return true
+}
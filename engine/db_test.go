@@ -0,0 +1,152 @@
+package godis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestKeyspaceCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"set a 1", "+OK\r\n"},
+		{"hset h f v", ":1\r\n"},
+		{"type a", "+string\r\n"},
+		{"type h", "+hash\r\n"},
+		{"type nope", "+none\r\n"},
+		{"exists a a nope", ":2\r\n"},
+		{"rename a b", "+OK\r\n"},
+		{"rename a b", "-ERR no such key\r\n"},
+		{"renamenx b h", ":0\r\n"},
+		{"set t v px 100000", "+OK\r\n"},
+		{"expire t 9223372036854775", "-ERR invalid expire time in 'expire' command\r\n"},
+		{"expireat t -9223372036854776", "-ERR invalid expire time in 'expireat' command\r\n"},
+		{"exists t", ":1\r\n"},
+		{"copy t t2", ":1\r\n"},
+		{"copy t t2", ":0\r\n"},
+		{"copy h h2 replace", ":1\r\n"},
+		{"hset h2 f2 v2", ":1\r\n"},
+		{"hlen h", ":1\r\n"},
+		{"keys t*", "*2\r\n$1\r\nt\r\n$2\r\nt2\r\n"},
+		{"dbsize", ":5\r\n"},
+		{"del b t nope", ":2\r\n"},
+		{"unlink h", ":1\r\n"},
+		{"touch h2 t2", ":2\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "dbsize"); r != ":2\r\n" {
+		t.Fatalf("dbsize is %q after loading", r)
+	}
+	if r := execCommand(loaded, lc, "hget", "h2", "f2"); r != "$2\r\nv2\r\n" {
+		t.Fatalf("h2 is %q after loading", r)
+	}
+	if expire := loaded.Db[0].GetExpire(NewObject(OBJString, "t2")); expire != s.Db[0].GetExpire(NewObject(OBJString, "t2")) {
+		t.Fatalf("wrong timeout %d of the copy after loading", expire)
+	}
+}
+
+func TestSelectDatabases(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"select 1", "+OK\r\n"},
+		{"set k db1", "+OK\r\n"},
+		{"set moved v px 100000", "+OK\r\n"},
+		{"move moved 2", ":1\r\n"},
+		{"move moved 2", ":0\r\n"},
+		{"select 0", "+OK\r\n"},
+		{"get k", "$-1\r\n"},
+		{"set k db0", "+OK\r\n"},
+		{"select 8", "-ERR DB index is out of range\r\n"},
+		{"swapdb 0 1", "+OK\r\n"},
+		{"get k", "$3\r\ndb1\r\n"},
+		{"select 3", "+OK\r\n"},
+		{"set gone v", "+OK\r\n"},
+		{"flushdb async", "+OK\r\n"},
+		{"dbsize", ":0\r\n"},
+		{"set k db3", "+OK\r\n"},
+		{"select 2", "+OK\r\n"},
+		{"ttl moved", ":100\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	// every key is replayed into its own db
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, expect := range map[int]string{0: "$3\r\ndb1\r\n", 1: "$3\r\ndb0\r\n", 3: "$3\r\ndb3\r\n"} {
+		lc := loaded.CreateClient(nil)
+		execCommand(loaded, lc, "select", strconv.Itoa(id))
+		if r := execCommand(loaded, lc, "get", "k"); r != expect {
+			t.Fatalf("k of db %d is %q after loading, expect %q", id, r, expect)
+		}
+	}
+	if loaded.Db[2].GetExpire(NewObject(OBJString, "moved")) == -1 {
+		t.Fatal("timeout of the moved key is lost")
+	}
+
+	// the scf tail after a snapshot starts with a SELECT
+	execCommand(s, c, "save")
+	execCommand(s, c, "set", "tail", "v")
+	if loaded, err = reloadServer(s, false); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Db[2].Dt.Get(NewObject(OBJString, "tail")) == nil {
+		t.Fatal("tail isn't replayed into db 2")
+	}
+
+	execCommand(s, c, "flushall")
+	if loaded, err = reloadServer(s, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range loaded.Db {
+		if db.Dt.Size() != 0 {
+			t.Fatalf("db %d isn't empty after flushall", db.ID)
+		}
+	}
+
+}
+
+func TestLoadSCFFewerDatabases(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	// keys can't be replayed into a db which isn't configured any more
+	c := s.CreateClient(nil)
+	execCommand(s, c, "select", "3")
+	execCommand(s, c, "set", "k", "db3")
+	fewer := NewServer(&GodisConfig{
+		SCFFileName:      s.SCFFileName,
+		SnapshotFileName: s.SnapshotFileName,
+		Databases:        2,
+	})
+	if err := LoadData(fewer); err == nil || !strings.Contains(err.Error(), "selects db 3 but only 2 databases are configured") {
+		t.Fatalf("loading scf into fewer databases returns %v", err)
+	}
+}
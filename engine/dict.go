@@ -2,6 +2,8 @@ package godis
 
 import (
 	"fmt"
+	"math/bits"
+	"math/rand"
 	"strconv"
 
 	"github.com/nk-akun/godis/engine/util"
@@ -183,10 +185,54 @@ func (d *Dict) deleteNode(key *Object) int {
 			preNode = node
 			node = node.next
 		}
+		if !d.isRehashing() {
+			break
+		}
 	}
 	return DICT_ERROR
 }
 
+// Size return the number of nodes stored in dict
+func (d *Dict) Size() int {
+	return int(d.ht[0].used + d.ht[1].used)
+}
+
+// GetRandomKey return a random node of dict or nil if dict is empty
+func (d *Dict) GetRandomKey() *DictNode {
+	if d.Size() == 0 {
+		return nil
+	}
+	if d.isRehashing() {
+		d.rehashStep(DICT_STEP_HASH_SIZE)
+	}
+
+	var node *DictNode
+	for node == nil {
+		if d.isRehashing() {
+			// buckets before rehashIndex in ht[0] are empty
+			size := int64(d.ht[0].size) + int64(d.ht[1].size) - d.rehashIndex
+			i := d.rehashIndex + rand.Int63n(size)
+			if i >= int64(d.ht[0].size) {
+				node = d.ht[1].table[i-int64(d.ht[0].size)]
+			} else {
+				node = d.ht[0].table[i]
+			}
+		} else {
+			node = d.ht[0].table[rand.Int63n(int64(d.ht[0].size))]
+		}
+	}
+
+	// pick a random node of the chain
+	length := 0
+	for p := node; p != nil; p = p.next {
+		length++
+	}
+	for i := rand.Intn(length); i > 0; i-- {
+		node = node.next
+	}
+	return node
+}
+
 func (d *Dict) isRehashing() bool {
 	return d.rehashIndex != -1
 }
@@ -233,7 +279,7 @@ func (d *Dict) getIndexKey(key *Object, hashValue uint32) int64 {
 		index = hashValue & d.ht[i].sizeMask
 		node := d.ht[i].table[index]
 		for node != nil {
-			if node.key == key || d.funcs.keyCompare(key, d.ht[i].table[index].key) == 0 {
+			if node.key == key || d.funcs.keyCompare(key, node.key) == 0 {
 				return -1
 			}
 			node = node.next
@@ -299,6 +345,56 @@ func (d *Dict) Search(key *Object) *DictNode {
 	return nil
 }
 
+// Scan visits nodes of a bucket (two buckets when rehashing) pointed by cursor and
+// return the next cursor, the scan is finished when 0 is returned.
+// The cursor is increased from the highest bit so that every node exists during the
+// whole scan is visited at least once even though the dict is resized between calls.
+func (d *Dict) Scan(cursor uint64, fn func(node *DictNode)) uint64 {
+	if d.Size() == 0 {
+		return 0
+	}
+
+	scanBucket := func(ht *DictHT, index uint64) {
+		for node := ht.table[index]; node != nil; {
+			next := node.next
+			fn(node)
+			node = next
+		}
+	}
+
+	if !d.isRehashing() {
+		m0 := uint64(d.ht[0].sizeMask)
+		scanBucket(d.ht[0], cursor&m0)
+		return nextScanCursor(cursor, m0)
+	}
+
+	// t0 is the smaller table
+	t0, t1 := d.ht[0], d.ht[1]
+	if t0.size > t1.size {
+		t0, t1 = t1, t0
+	}
+	m0, m1 := uint64(t0.sizeMask), uint64(t1.sizeMask)
+	scanBucket(t0, cursor&m0)
+
+	// visit all buckets of the larger table that are expanded from the bucket of the smaller one
+	for {
+		scanBucket(t1, cursor&m1)
+		cursor = nextScanCursor(cursor, m1)
+		if cursor&(m0^m1) == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+// nextScanCursor increase the masked bits of cursor reversely
+func nextScanCursor(cursor uint64, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
 // NewDictIterator return a new iterator
 func NewDictIterator(d *Dict) *DictIterator {
 	return &DictIterator{
@@ -400,62 +496,3 @@ func (d *Dict) stateLable() uint32 {
 	}
 	return hash
 }
-
-// SetCommand ...
-func SetCommand(c *Client, s *Server) {
-	if c.Argc != 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'set' command")
-		return
-	}
-
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value != nil {
-		c.Db.Dt.Delete(key)
-	}
-	c.Db.Dt.Add(key, NewObject(OBJSDS, SdsNewString(c.Argv[2].Ptr.(string))))
-	s.Dirty++
-	addReplyStatus(c, "OK")
-}
-
-// GetCommand ...
-func GetCommand(c *Client, s *Server) {
-	if c.Argc != 2 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'get' command")
-		return
-	}
-
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		addReplyStatus(c, "(nil)")
-		return
-	}
-	addReplyStatus(c, *(value.Ptr.(*Sdshdr).SdsGetString()))
-}
-
-// IncrCommand ...
-func IncrCommand(c *Client, s *Server) {
-	if c.Argc != 2 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'incr' command")
-		return
-	}
-
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		value = NewObject(OBJSDS, SdsNewString("0"))
-	} else {
-		c.Db.Dt.Delete(key)
-	}
-	num, err := strconv.ParseInt(*(value.Ptr.(*Sdshdr).SdsGetString()), 10, 64)
-	if err != nil {
-		addReplyStatus(c, "(error) ERR value is not an integer or out of range")
-		return
-	}
-	num++
-	value = NewObject(OBJSDS, SdsNewString(strconv.FormatInt(num, 10)))
-	c.Db.Dt.Add(key, value)
-	s.Dirty++
-	addReplyInt(c, num)
-}
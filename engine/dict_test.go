@@ -3,11 +3,13 @@ package godis
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
+	"testing"
 	"time"
 )
 
 // TestDict ...
-func TestDict() {
+func TestDict(t *testing.T) {
 	dtf := &DictFunc{}
 	dtf.calHash = CalHashCommon
 	dtf.keyCompare = CompareValueCommon
@@ -18,7 +20,7 @@ func TestDict() {
 	for i := 0; i < 100; i++ {
 		s := ""
 		for j := 0; j < 7; j++ {
-			s += string('a' + rand.Intn(26))
+			s += string(rune('a' + rand.Intn(26)))
 		}
 		dt.Add(NewObject(OBJString, s), NewObject(OBJInt, rand.Intn(100007)))
 		keys = append(keys, s)
@@ -44,3 +46,98 @@ func outputDict(dt *Dict) {
 	}
 	fmt.Println("count:", sum)
 }
+
+func TestDictDeleteMissingKey(t *testing.T) {
+	dt := NewDict(&DictFunc{calHash: CalHashCommon, keyCompare: CompareValueCommon})
+	dt.Add(NewObject(OBJString, "k"), nil)
+	if dt.Delete(NewObject(OBJString, "nope")) != DICT_ERROR {
+		t.Fatal("delete a missing key")
+	}
+	if dt.Delete(NewObject(OBJString, "k")) != DICT_OK {
+		t.Fatal("fail to delete k")
+	}
+}
+
+func TestDictAddExistingKey(t *testing.T) {
+	// all keys are chained in the same bucket
+	dt := NewDict(&DictFunc{
+		calHash:    func(key *Object) uint32 { return 0 },
+		keyCompare: CompareValueCommon,
+	})
+	dt.Add(NewObject(OBJString, "a"), nil)
+	dt.Add(NewObject(OBJString, "b"), nil)
+	if dt.Add(NewObject(OBJString, "a"), nil) != DICT_ERROR {
+		t.Fatal("a is added twice")
+	}
+	if dt.ht[0].used != 2 {
+		t.Fatalf("dict has %d keys, expect 2", dt.ht[0].used)
+	}
+}
+
+func TestDictScanRehash(t *testing.T) {
+	dt := NewDict(&DictFunc{calHash: CalHashCommon, keyCompare: CompareValueCommon})
+	const origin = 1000
+	for i := 0; i < origin; i++ {
+		dt.Add(NewObject(OBJString, "origin-"+strconv.Itoa(i)), nil)
+	}
+
+	seen := make(map[string]int)
+	rehashed := false
+	cursor, added := uint64(0), 0
+	for calls := 0; ; calls++ {
+		if calls > 1<<20 {
+			t.Fatal("scan doesn't finish")
+		}
+		cursor = dt.Scan(cursor, func(node *DictNode) {
+			seen[node.key.Ptr.(string)]++
+		})
+		if cursor == 0 {
+			break
+		}
+
+		// keep the dict expanding and rehashing between the calls
+		for i := 0; i < 20; i++ {
+			dt.Add(NewObject(OBJString, "added-"+strconv.Itoa(added)), nil)
+			added++
+		}
+		dt.Search(NewObject(OBJString, "origin-0"))
+		rehashed = rehashed || dt.isRehashing()
+	}
+
+	if !rehashed {
+		t.Fatal("dict isn't rehashed during the scan")
+	}
+	for i := 0; i < origin; i++ {
+		if seen["origin-"+strconv.Itoa(i)] == 0 {
+			t.Fatalf("origin-%d isn't visited", i)
+		}
+	}
+}
+
+func TestDictScanWhileRehashing(t *testing.T) {
+	dt := NewDict(&DictFunc{calHash: CalHashCommon, keyCompare: CompareValueCommon})
+	for i := 0; i < 500; i++ {
+		dt.Add(NewObject(OBJString, strconv.Itoa(i)), nil)
+	}
+	dt.expand(dt.ht[0].size << 2)
+	if !dt.isRehashing() {
+		t.Fatal("dict should be rehashing")
+	}
+
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	for {
+		cursor = dt.Scan(cursor, func(node *DictNode) {
+			seen[node.key.Ptr.(string)]++
+		})
+		if cursor == 0 {
+			break
+		}
+		if dt.isRehashing() {
+			dt.rehashStep(1)
+		}
+	}
+	if len(seen) != 500 {
+		t.Fatalf("visited %d keys, expect 500", len(seen))
+	}
+}
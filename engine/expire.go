@@ -0,0 +1,156 @@
+package godis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ExpireCommand ...
+// EXPIRE key seconds [NX|XX|GT|LT]
+func ExpireCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, "expire", mstime(), 1000)
+}
+
+// PExpireCommand ...
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func PExpireCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, "pexpire", mstime(), 1)
+}
+
+// ExpireAtCommand ...
+// EXPIREAT key timestamp [NX|XX|GT|LT]
+func ExpireAtCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, "expireat", 0, 1000)
+}
+
+// PExpireAtCommand ...
+// PEXPIREAT key milliseconds-timestamp [NX|XX|GT|LT]
+func PExpireAtCommand(c *Client, s *Server) {
+	expireGenericCommand(c, s, "pexpireat", 0, 1)
+}
+
+// expireGenericCommand sets the timeout of key to basetime+argv[2]*unit in milliseconds
+func expireGenericCommand(c *Client, s *Server, name string, basetime int64, unit int64) {
+	if c.Argc < 3 || c.Argc > 4 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	flag := ""
+	if c.Argc == 4 {
+		flag = strings.ToLower(c.Argv[3].Ptr.(string))
+		if flag != "nx" && flag != "xx" && flag != "gt" && flag != "lt" {
+			addReplyError(c, "ERR Unsupported option "+c.Argv[3].Ptr.(string))
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	v, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	// the timeout overflowing int64 is invalid rather than a time in the past
+	if v > (math.MaxInt64-basetime)/unit || v < math.MinInt64/unit {
+		addReplyError(c, "ERR invalid expire time in '"+name+"' command")
+		return
+	}
+	when := basetime + v*unit
+
+	if lookupKey(c, key) == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	// a key without timeout is regarded as a key with infinite ttl when comparing
+	cur := c.Db.GetExpire(key)
+	switch flag {
+	case "nx":
+		if cur != -1 {
+			addReplyInt(c, 0)
+			return
+		}
+	case "xx":
+		if cur == -1 {
+			addReplyInt(c, 0)
+			return
+		}
+	case "gt":
+		if cur == -1 || when <= cur {
+			addReplyInt(c, 0)
+			return
+		}
+	case "lt":
+		if cur != -1 && when >= cur {
+			addReplyInt(c, 0)
+			return
+		}
+	}
+
+	if when <= mstime() && !c.VirtualFlag {
+		c.Db.Delete(key)
+	} else {
+		c.Db.SetExpire(key, when)
+	}
+	rewriteClientCommandArgv(c, "pexpireat", key.Ptr.(string), strconv.FormatInt(when, 10))
+	s.Dirty++
+	addReplyInt(c, 1)
+}
+
+// TTLCommand ...
+func TTLCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, "ttl", false)
+}
+
+// PTTLCommand ...
+func PTTLCommand(c *Client, s *Server) {
+	ttlGenericCommand(c, "pttl", true)
+}
+
+// ttlGenericCommand replies the remaining time to live of key,
+// -2 if key doesn't exist and -1 if key has no timeout
+func ttlGenericCommand(c *Client, name string, ms bool) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	key := c.Argv[1]
+	if lookupKey(c, key) == nil {
+		addReplyInt(c, -2)
+		return
+	}
+
+	when := c.Db.GetExpire(key)
+	if when == -1 {
+		addReplyInt(c, -1)
+		return
+	}
+
+	ttl := when - mstime()
+	if ttl < 0 {
+		ttl = 0
+	}
+	if ms {
+		addReplyInt(c, ttl)
+	} else {
+		addReplyInt(c, (ttl+500)/1000)
+	}
+}
+
+// PersistCommand ...
+func PersistCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'persist' command")
+		return
+	}
+
+	key := c.Argv[1]
+	if lookupKey(c, key) == nil || !c.Db.RemoveExpire(key) {
+		addReplyInt(c, 0)
+		return
+	}
+	s.Dirty++
+	addReplyInt(c, 1)
+}
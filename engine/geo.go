@@ -0,0 +1,521 @@
+package godis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// orders of GEOSEARCH results
+const (
+	GEO_SORT_NONE = iota
+	GEO_SORT_ASC
+	GEO_SORT_DESC
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geoShape is the shape searched by GEOSEARCH, the sizes are in meters
+type geoShape struct {
+	longitude  float64
+	latitude   float64
+	isBox      bool
+	radius     float64
+	width      float64
+	height     float64
+	conversion float64 // meters of the unit used by the command
+}
+
+// geoPoint is a member found by GEOSEARCH
+type geoPoint struct {
+	member    *Sdshdr
+	longitude float64
+	latitude  float64
+	dist      float64 // distance in meters from the center of shape
+	score     float64
+}
+
+// extractLongLatOrReply parses the longitude and latitude in args
+func extractLongLatOrReply(c *Client, args []*Object) (float64, float64, bool) {
+	longitude, ok := getFloat64FromObjectOrReply(c, args[0])
+	if !ok {
+		return 0, 0, false
+	}
+	latitude, ok := getFloat64FromObjectOrReply(c, args[1])
+	if !ok {
+		return 0, 0, false
+	}
+	if longitude < GEO_LONG_MIN || longitude > GEO_LONG_MAX || latitude < GEO_LAT_MIN || latitude > GEO_LAT_MAX {
+		addReplyError(c, fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+		return 0, 0, false
+	}
+	return longitude, latitude, true
+}
+
+// extractUnitOrReply return the meters of the unit
+func extractUnitOrReply(c *Client, o *Object) (float64, bool) {
+	switch strings.ToLower(o.Ptr.(string)) {
+	case "m":
+		return 1, true
+	case "km":
+		return 1000, true
+	case "ft":
+		return 0.3048, true
+	case "mi":
+		return 1609.34, true
+	}
+	addReplyError(c, "ERR unsupported unit provided. please use M, KM, FT, MI")
+	return 0, false
+}
+
+// geoDecodeScore return the longitude and latitude encoded in the score of a geo member
+func geoDecodeScore(score float64) (float64, float64) {
+	return geohashDecodeToLongLat(GeoHashBits{bits: uint64(score), step: GEO_STEP_MAX})
+}
+
+// formatCoord return the shortest representation of a longitude or latitude without exponent
+func formatCoord(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// geohashString return the standard geohash string of 11 characters of the coordinate
+func geohashString(longitude float64, latitude float64) string {
+	hash, _ := geohashEncode(GeoHashRange{-180, 180}, GeoHashRange{-90, 90}, longitude, latitude, GEO_STEP_MAX)
+	buf := make([]byte, 11)
+	for i := range buf {
+		// the last character has no bit left as the hash has only 52 bits
+		var index uint64
+		if i < 10 {
+			index = hash.bits >> uint(GEO_STEP_MAX*2-(i+1)*5) & 0x1f
+		}
+		buf[i] = geoAlphabet[index]
+	}
+	return string(buf)
+}
+
+// geoDistanceInShape return the distance from the center of shape to the coordinate,
+// and whether the coordinate is in shape
+func geoDistanceInShape(shape *geoShape, longitude float64, latitude float64) (float64, bool) {
+	if !shape.isBox {
+		dist := geohashGetDistance(shape.longitude, shape.latitude, longitude, latitude)
+		return dist, dist <= shape.radius
+	}
+
+	if geohashGetLatDistance(latitude, shape.latitude) > shape.height/2 {
+		return 0, false
+	}
+	if geohashGetDistance(longitude, latitude, shape.longitude, latitude) > shape.width/2 {
+		return 0, false
+	}
+	return geohashGetDistance(shape.longitude, shape.latitude, longitude, latitude), true
+}
+
+// geoMembersOfShape return the members of zset in shape, at most limit members are returned if limit isn't 0
+func geoMembersOfShape(zset *ZskipList, shape *geoShape, limit int) []*geoPoint {
+	halfWidth, halfHeight, radius := shape.radius, shape.radius, shape.radius
+	if shape.isBox {
+		halfWidth, halfHeight = shape.width/2, shape.height/2
+		radius = math.Sqrt(halfWidth*halfWidth + halfHeight*halfHeight)
+	}
+	areas := geohashCalculateAreas(shape.longitude, shape.latitude, halfWidth, halfHeight, radius)
+	n := areas.neighbors
+	boxes := []GeoHashBits{areas.hash, n.north, n.south, n.east, n.west, n.northEast, n.northWest, n.southEast, n.southWest}
+
+	points := make([]*geoPoint, 0)
+	searched := make(map[uint64]bool)
+	for _, box := range boxes {
+		// neighbors may be excluded, or be the same box near the poles
+		if box.step == 0 || searched[box.bits] {
+			continue
+		}
+		searched[box.bits] = true
+
+		next := box
+		next.bits++
+		rg := &zrangeTag{
+			minx:  float64(geohashAlign52Bits(box)),
+			maxx:  float64(geohashAlign52Bits(next)),
+			emaxx: true,
+		}
+		for node := zset.FirstInRange(rg); node != nil && zslCompareMax(node.score, rg); node = node.level[0].forward {
+			longitude, latitude := geoDecodeScore(node.score)
+			dist, ok := geoDistanceInShape(shape, longitude, latitude)
+			if !ok {
+				continue
+			}
+			points = append(points, &geoPoint{
+				member:    node.value,
+				longitude: longitude,
+				latitude:  latitude,
+				dist:      dist,
+				score:     node.score,
+			})
+			if limit != 0 && len(points) == limit {
+				return points
+			}
+		}
+	}
+	return points
+}
+
+// GeoAddCommand ...
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func GeoAddCommand(c *Client, s *Server) {
+	if c.Argc < 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'geoadd' command")
+		return
+	}
+
+	args := []string{"zadd", c.Argv[1].Ptr.(string)}
+	nx, xx := false, false
+	i := 2
+	for ; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		if opt == "nx" {
+			nx = true
+		} else if opt == "xx" {
+			xx = true
+		} else if opt != "ch" {
+			break
+		}
+		args = append(args, opt)
+	}
+	if nx && xx {
+		addReplyError(c, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if i == c.Argc || (c.Argc-i)%3 != 0 {
+		addReplyError(c, "ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+		return
+	}
+
+	for ; i < c.Argc; i += 3 {
+		longitude, latitude, ok := extractLongLatOrReply(c, c.Argv[i:i+2])
+		if !ok {
+			return
+		}
+		hash, _ := geohashEncodeWGS84(longitude, latitude)
+		args = append(args, strconv.FormatUint(geohashAlign52Bits(hash), 10), c.Argv[i+2].Ptr.(string))
+	}
+
+	// members are added by ZADD with their geohashes as scores, which is also what scf stores
+	rewriteClientCommandArgv(c, args...)
+	zaddGenericCommand(c, s, "zadd", ZADD_IN_NONE)
+}
+
+// GeoPosCommand ...
+// GEOPOS key [member [member ...]]
+func GeoPosCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'geopos' command")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		var score float64
+		exists := false
+		if zset != nil {
+			score, exists = zset.GetScore(SdsNewString(c.Argv[i].Ptr.(string)))
+		}
+		if !exists {
+			array = append(array, NewMultiBulk(nil))
+			continue
+		}
+		longitude, latitude := geoDecodeScore(score)
+		array = append(array, NewMultiBulk([]*EncodeData{
+			NewBulk([]byte(formatCoord(longitude))),
+			NewBulk([]byte(formatCoord(latitude))),
+		}))
+	}
+	addReplyArray(c, array)
+}
+
+// GeoDistCommand ...
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func GeoDistCommand(c *Client, s *Server) {
+	if c.Argc != 4 && c.Argc != 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'geodist' command")
+		return
+	}
+
+	conversion := 1.0
+	if c.Argc == 5 {
+		var ok bool
+		if conversion, ok = extractUnitOrReply(c, c.Argv[4]); !ok {
+			return
+		}
+	}
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyNull(c)
+		return
+	}
+	score1, exists1 := zset.GetScore(SdsNewString(c.Argv[2].Ptr.(string)))
+	score2, exists2 := zset.GetScore(SdsNewString(c.Argv[3].Ptr.(string)))
+	if !exists1 || !exists2 {
+		addReplyNull(c)
+		return
+	}
+	lon1, lat1 := geoDecodeScore(score1)
+	lon2, lat2 := geoDecodeScore(score2)
+	addReplyBulk(c, strconv.FormatFloat(geohashGetDistance(lon1, lat1, lon2, lat2)/conversion, 'f', 4, 64))
+}
+
+// GeoHashCommand ...
+// GEOHASH key [member [member ...]]
+func GeoHashCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'geohash' command")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		var score float64
+		exists := false
+		if zset != nil {
+			score, exists = zset.GetScore(SdsNewString(c.Argv[i].Ptr.(string)))
+		}
+		if !exists {
+			array = append(array, NewBulk(nil))
+			continue
+		}
+		array = append(array, NewBulk([]byte(geohashString(geoDecodeScore(score)))))
+	}
+	addReplyArray(c, array)
+}
+
+// GeoSearchCommand ...
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func GeoSearchCommand(c *Client, s *Server) {
+	geoSearchGenericCommand(c, s, "geosearch", nil)
+}
+
+// GeoSearchStoreCommand ...
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI
+// [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func GeoSearchStoreCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'geosearchstore' command")
+		return
+	}
+	geoSearchGenericCommand(c, s, "geosearchstore", c.Argv[1])
+}
+
+// geoSearchGenericCommand searches the members in the shape, the result is stored at dstKey if it isn't nil,
+// otherwise it's replied
+func geoSearchGenericCommand(c *Client, s *Server, name string, dstKey *Object) {
+	srcIndex := 1
+	if dstKey != nil {
+		srcIndex = 2
+	}
+	if c.Argc < srcIndex+6 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	shape := &geoShape{}
+	var fromMember *Object
+	fromLonLat, byRadius := false, false
+	withDist, withHash, withCoord, storeDist, anyMatch := false, false, false, false, false
+	var count int64
+	order := GEO_SORT_NONE
+	for i := srcIndex + 1; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		remaining := c.Argc - i - 1
+		var ok bool
+		switch {
+		case opt == "withdist":
+			withDist = true
+		case opt == "withhash":
+			withHash = true
+		case opt == "withcoord":
+			withCoord = true
+		case opt == "storedist" && dstKey != nil:
+			storeDist = true
+		case opt == "any":
+			anyMatch = true
+		case opt == "asc":
+			order = GEO_SORT_ASC
+		case opt == "desc":
+			order = GEO_SORT_DESC
+		case opt == "count" && remaining >= 1:
+			i++
+			if count, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			if count <= 0 {
+				addReplyError(c, "ERR COUNT must be > 0")
+				return
+			}
+		case opt == "frommember" && remaining >= 1 && fromMember == nil && !fromLonLat:
+			i++
+			fromMember = c.Argv[i]
+		case opt == "fromlonlat" && remaining >= 2 && fromMember == nil && !fromLonLat:
+			if shape.longitude, shape.latitude, ok = extractLongLatOrReply(c, c.Argv[i+1:i+3]); !ok {
+				return
+			}
+			i += 2
+			fromLonLat = true
+		case opt == "byradius" && remaining >= 2 && !byRadius && !shape.isBox:
+			if shape.radius, ok = getFloat64FromObjectOrReply(c, c.Argv[i+1]); !ok {
+				return
+			}
+			if shape.radius < 0 {
+				addReplyError(c, "ERR radius cannot be negative")
+				return
+			}
+			if shape.conversion, ok = extractUnitOrReply(c, c.Argv[i+2]); !ok {
+				return
+			}
+			i += 2
+			byRadius = true
+		case opt == "bybox" && remaining >= 3 && !byRadius && !shape.isBox:
+			if shape.width, ok = getFloat64FromObjectOrReply(c, c.Argv[i+1]); !ok {
+				return
+			}
+			if shape.height, ok = getFloat64FromObjectOrReply(c, c.Argv[i+2]); !ok {
+				return
+			}
+			if shape.width < 0 || shape.height < 0 {
+				addReplyError(c, "ERR height or width cannot be negative")
+				return
+			}
+			if shape.conversion, ok = extractUnitOrReply(c, c.Argv[i+3]); !ok {
+				return
+			}
+			i += 3
+			shape.isBox = true
+		case (opt == "frommember" || opt == "fromlonlat") && (fromMember != nil || fromLonLat):
+			addReplyError(c, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for "+name)
+			return
+		case (opt == "byradius" || opt == "bybox") && (byRadius || shape.isBox):
+			addReplyError(c, "ERR exactly one of BYRADIUS and BYBOX can be specified for "+name)
+			return
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	if fromMember == nil && !fromLonLat {
+		addReplyError(c, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for "+name)
+		return
+	}
+	if !byRadius && !shape.isBox {
+		addReplyError(c, "ERR exactly one of BYRADIUS and BYBOX can be specified for "+name)
+		return
+	}
+	if anyMatch && count == 0 {
+		addReplyError(c, "ERR the ANY argument requires COUNT argument")
+		return
+	}
+	if dstKey != nil && (withDist || withHash || withCoord) {
+		addReplyError(c, "ERR "+strings.ToUpper(name)+" is not compatible with WITHDIST, WITHHASH and WITHCOORD options")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[srcIndex])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		if dstKey == nil {
+			addReplyArray(c, []*EncodeData{})
+			return
+		}
+		if c.Db.Delete(dstKey) {
+			s.Dirty++
+		}
+		addReplyInt(c, 0)
+		return
+	}
+	if fromMember != nil {
+		score, exists := zset.GetScore(SdsNewString(fromMember.Ptr.(string)))
+		if !exists {
+			addReplyError(c, "ERR could not decode requested zset member")
+			return
+		}
+		shape.longitude, shape.latitude = geoDecodeScore(score)
+	}
+	shape.radius *= shape.conversion
+	shape.width *= shape.conversion
+	shape.height *= shape.conversion
+
+	limit := 0
+	if anyMatch {
+		limit = int(count)
+	}
+	points := geoMembersOfShape(zset, shape, limit)
+
+	// the nearest members are returned if COUNT is given without ANY
+	if count != 0 && !anyMatch && order == GEO_SORT_NONE {
+		order = GEO_SORT_ASC
+	}
+	switch order {
+	case GEO_SORT_ASC:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist < points[j].dist })
+	case GEO_SORT_DESC:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].dist > points[j].dist })
+	}
+	if count != 0 && int64(len(points)) > count {
+		points = points[:count]
+	}
+
+	if dstKey != nil {
+		if len(points) == 0 {
+			c.Db.Delete(dstKey)
+		} else {
+			result := NewZsl()
+			for _, p := range points {
+				score := p.score
+				if storeDist {
+					score = p.dist / shape.conversion
+				}
+				result.addMember(score, p.member.SdsDup())
+			}
+			c.Db.SetKey(dstKey, NewObject(OBJZset, result), false)
+		}
+		s.Dirty++
+		addReplyInt(c, int64(len(points)))
+		return
+	}
+
+	array := make([]*EncodeData, 0, len(points))
+	for _, p := range points {
+		if !withDist && !withHash && !withCoord {
+			array = append(array, NewBulk(p.member.SdsGetBuf()))
+			continue
+		}
+		item := []*EncodeData{NewBulk(p.member.SdsGetBuf())}
+		if withDist {
+			item = append(item, NewBulk([]byte(strconv.FormatFloat(p.dist/shape.conversion, 'f', 4, 64))))
+		}
+		if withHash {
+			item = append(item, NewInt([]byte(strconv.FormatInt(int64(p.score), 10))))
+		}
+		if withCoord {
+			item = append(item, NewMultiBulk([]*EncodeData{
+				NewBulk([]byte(formatCoord(p.longitude))),
+				NewBulk([]byte(formatCoord(p.latitude))),
+			}))
+		}
+		array = append(array, NewMultiBulk(item))
+	}
+	addReplyArray(c, array)
+}
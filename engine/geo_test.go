@@ -0,0 +1,129 @@
+package godis
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGeoCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"geoadd Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", ":2\r\n"},
+		{"geoadd Sicily nx 0 0 Palermo", ":0\r\n"},
+		{"geoadd Sicily xx ch 13.361389 38.115556 Palermo", ":0\r\n"},
+		{"geoadd Sicily 200 0 bad", "-ERR invalid longitude,latitude pair 200.000000,0.000000\r\n"},
+		{"geoadd Sicily 0 0", "-ERR wrong number of arguments for 'geoadd' command\r\n"},
+		{"geoadd Sicily 0 0 a 1", "-ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... \r\n"},
+		{"geoadd Sicily nx xx 0 0 a", "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{"zscore Sicily Palermo", "$21\r\n3.479099956230698e+15\r\n"},
+		{"geodist Sicily Palermo Catania", "$11\r\n166274.1516\r\n"},
+		{"geodist Sicily Palermo Catania km", "$8\r\n166.2742\r\n"},
+		{"geodist Sicily Palermo Catania mi", "$8\r\n103.3182\r\n"},
+		{"geodist Sicily Palermo nope", "$-1\r\n"},
+		{"geodist Sicily Palermo Catania yd", "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"},
+		{"geohash Sicily Palermo Catania nope", "*3\r\n$11\r\nsqc8b49rny0\r\n$11\r\nsqdtr74hyu0\r\n$-1\r\n"},
+		{"geopos Sicily Palermo nope", "*2\r\n*2\r\n$18\r\n13.361389338970184\r\n$16\r\n38.1155563954963\r\n*-1\r\n"},
+		{"geopos nope a", "*1\r\n*-1\r\n"},
+		{"geoadd tiny 0.00001 -0.00001 a", ":1\r\n"},
+		{"geopos tiny a", "*1\r\n*2\r\n$23\r\n0.000008046627044677734\r\n$24\r\n-0.000008871524052267432\r\n"},
+
+		{"geoadd Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", ":2\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 byradius 200 km asc", "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 byradius 200 km desc", "*2\r\n$7\r\nPalermo\r\n$7\r\nCatania\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 bybox 400 400 km asc withdist",
+			"*4\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n" +
+				"*2\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n"},
+		{"geosearch Sicily frommember Palermo byradius 50 km withhash withcoord",
+			"*1\r\n*3\r\n$7\r\nPalermo\r\n:3479099956230698\r\n*2\r\n$18\r\n13.361389338970184\r\n$16\r\n38.1155563954963\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 bybox 400 400 km count 1", "*1\r\n$7\r\nCatania\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 bybox 400 400 km count 3 any", "*3\r\n$7\r\nPalermo\r\n$5\r\nedge1\r\n$7\r\nCatania\r\n"},
+		{"geosearch Sicily fromlonlat 15 37 bybox 400 400 km count 2 desc", "*2\r\n$5\r\nedge1\r\n$5\r\nedge2\r\n"},
+		{"geosearch nope fromlonlat 15 37 byradius 200 km", "*0\r\n"},
+		{"geosearch Sicily frommember nope byradius 200 km", "-ERR could not decode requested zset member\r\n"},
+		{"geosearch Sicily frommember Palermo fromlonlat 15 37 byradius 200 km", "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n"},
+		{"geosearch Sicily byradius 200 km asc count 1", "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n"},
+		{"geosearch Sicily frommember Palermo byradius 200 km bybox 1 1 km", "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n"},
+		{"geosearch Sicily frommember Palermo asc count 1 any", "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n"},
+		{"geosearch Sicily frommember Palermo byradius -1 km", "-ERR radius cannot be negative\r\n"},
+		{"geosearch Sicily frommember Palermo byradius 1 km any", "-ERR the ANY argument requires COUNT argument\r\n"},
+		{"geosearch Sicily frommember Palermo byradius 1 km count 0", "-ERR COUNT must be > 0\r\n"},
+		{"geosearch Sicily frommember Palermo byradius 1 km storedist", "-ERR syntax error\r\n"},
+
+		{"geosearchstore dst Sicily fromlonlat 15 37 byradius 200 km", ":2\r\n"},
+		{"zscore dst Palermo", "$21\r\n3.479099956230698e+15\r\n"},
+		{"geosearchstore dist Sicily fromlonlat 15 37 byradius 200 km asc count 1 storedist", ":1\r\n"},
+		{"zrange dist 0 -1 withscores", "*2\r\n$7\r\nCatania\r\n$16\r\n56.4412578701582\r\n"},
+		{"geosearchstore dst Sicily fromlonlat 15 37 byradius 200 km withdist", "-ERR GEOSEARCHSTORE is not compatible with WITHDIST, WITHHASH and WITHCOORD options\r\n"},
+		{"geosearchstore dist Sicily fromlonlat 0 0 byradius 1 km", ":0\r\n"},
+		{"exists dist", ":0\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"geopos str a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, cmd := range []string{"geohash Sicily Palermo Catania edge1 edge2", "zrange dst 0 -1 withscores"} {
+		if r, expect := execCommand(loaded, lc, strings.Fields(cmd)...), execCommand(s, c, strings.Fields(cmd)...); r != expect {
+			t.Fatalf("%s replies %q after loading, expect %q", cmd, r, expect)
+		}
+	}
+}
+
+// TestGeoSearchCoverage compares the search by geohash areas with checking every member
+func TestGeoSearchCoverage(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, center := range [][2]float64{{13.36, 38.11}, {-179.9, 0}, {179.95, -45}, {0, 84.9}, {116.4, 39.9}} {
+		zset := NewZsl()
+		for i := 0; i < 2000; i++ {
+			longitude := center[0] + (r.Float64()-0.5)*4
+			latitude := center[1] + (r.Float64()-0.5)*2
+			if longitude < GEO_LONG_MIN || longitude > GEO_LONG_MAX || latitude < GEO_LAT_MIN || latitude > GEO_LAT_MAX {
+				continue
+			}
+			hash, _ := geohashEncodeWGS84(longitude, latitude)
+			zset.addMember(float64(geohashAlign52Bits(hash)), SdsNewString(strconv.Itoa(i)))
+		}
+
+		for _, shape := range []*geoShape{
+			{longitude: center[0], latitude: center[1], radius: 1000},
+			{longitude: center[0], latitude: center[1], radius: 50000},
+			{longitude: center[0] * 0.998, latitude: center[1] - 0.2, radius: 120000},
+			{longitude: center[0], latitude: center[1], isBox: true, width: 80000, height: 20000},
+			{longitude: center[0] * 0.997, latitude: center[1], isBox: true, width: 3000, height: 150000},
+		} {
+			expect := make(map[string]bool)
+			for node := zset.header.level[0].forward; node != nil; node = node.level[0].forward {
+				longitude, latitude := geoDecodeScore(node.score)
+				if _, ok := geoDistanceInShape(shape, longitude, latitude); ok {
+					expect[*node.value.SdsGetString()] = true
+				}
+			}
+
+			points := geoMembersOfShape(zset, shape, 0)
+			if len(points) != len(expect) {
+				t.Fatalf("%d members are found in %+v, expect %d", len(points), *shape, len(expect))
+			}
+			for _, p := range points {
+				if !expect[*p.member.SdsGetString()] {
+					t.Fatalf("%s isn't in %+v", *p.member.SdsGetString(), *shape)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package godis
+
+import "math"
+
+// limits of the coordinates which can be encoded, latitudes near the poles are excluded by EPSG:900913
+const (
+	GEO_LAT_MIN  = -85.05112878
+	GEO_LAT_MAX  = 85.05112878
+	GEO_LONG_MIN = -180.0
+	GEO_LONG_MAX = 180.0
+	GEO_STEP_MAX = 26 // 52 bits of hash fit in the mantissa of a float64 score
+
+	EARTH_RADIUS_IN_METERS = 6372797.560856
+	MERCATOR_MAX           = 20037726.37
+)
+
+// GeoHashBits is a geohash of step*2 bits, the bits of longitude and latitude are interleaved
+// with longitude in the odd positions
+type GeoHashBits struct {
+	bits uint64
+	step uint
+}
+
+// GeoHashRange ...
+type GeoHashRange struct {
+	min float64
+	max float64
+}
+
+// GeoHashArea is the area of a geohash
+type GeoHashArea struct {
+	hash      GeoHashBits
+	longitude GeoHashRange
+	latitude  GeoHashRange
+}
+
+// GeoHashNeighbors is the 8 geohashes around a geohash, the zero step means it's excluded
+type GeoHashNeighbors struct {
+	north     GeoHashBits
+	east      GeoHashBits
+	west      GeoHashBits
+	south     GeoHashBits
+	northEast GeoHashBits
+	southEast GeoHashBits
+	northWest GeoHashBits
+	southWest GeoHashBits
+}
+
+// GeoHashRadius is the geohash of the center of a search and the geohashes around it
+// which cover the whole shape
+type GeoHashRadius struct {
+	hash      GeoHashBits
+	area      GeoHashArea
+	neighbors GeoHashNeighbors
+}
+
+var geoLongRange = GeoHashRange{GEO_LONG_MIN, GEO_LONG_MAX}
+var geoLatRange = GeoHashRange{GEO_LAT_MIN, GEO_LAT_MAX}
+
+// interleave64 interleaves the lowest 32 bits of x and y, bits of x are in the even positions
+func interleave64(x uint32, y uint32) uint64 {
+	var bits uint64
+	for i := uint(0); i < 32; i++ {
+		bits |= uint64(x>>i&1)<<(2*i) | uint64(y>>i&1)<<(2*i+1)
+	}
+	return bits
+}
+
+// deinterleave64 is the inverse of interleave64
+func deinterleave64(bits uint64) (uint32, uint32) {
+	var x, y uint32
+	for i := uint(0); i < 32; i++ {
+		x |= uint32(bits>>(2*i)&1) << i
+		y |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+// geohashEncode return the geohash of step of the coordinate, false is returned if it's out of the ranges
+func geohashEncode(longRange GeoHashRange, latRange GeoHashRange, longitude float64, latitude float64, step uint) (GeoHashBits, bool) {
+	if longitude < longRange.min || longitude > longRange.max || latitude < latRange.min || latitude > latRange.max {
+		return GeoHashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	// the max of the ranges belongs to the last cell
+	scale := float64(uint64(1) << step)
+	latCell := math.Min(latOffset*scale, scale-1)
+	longCell := math.Min(longOffset*scale, scale-1)
+	return GeoHashBits{bits: interleave64(uint32(latCell), uint32(longCell)), step: step}, true
+}
+
+// geohashEncodeWGS84 return the geohash with the max step of the coordinate
+func geohashEncodeWGS84(longitude float64, latitude float64) (GeoHashBits, bool) {
+	return geohashEncode(geoLongRange, geoLatRange, longitude, latitude, GEO_STEP_MAX)
+}
+
+// geohashDecode return the area of hash
+func geohashDecode(longRange GeoHashRange, latRange GeoHashRange, hash GeoHashBits) GeoHashArea {
+	latCell, longCell := deinterleave64(hash.bits)
+	scale := float64(uint64(1) << hash.step)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	return GeoHashArea{
+		hash: hash,
+		latitude: GeoHashRange{
+			min: latRange.min + float64(latCell)/scale*latScale,
+			max: latRange.min + float64(latCell+1)/scale*latScale,
+		},
+		longitude: GeoHashRange{
+			min: longRange.min + float64(longCell)/scale*longScale,
+			max: longRange.min + float64(longCell+1)/scale*longScale,
+		},
+	}
+}
+
+// geohashDecodeToLongLat return the center of the area of hash
+func geohashDecodeToLongLat(hash GeoHashBits) (float64, float64) {
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+	longitude := math.Max(GEO_LONG_MIN, math.Min(GEO_LONG_MAX, (area.longitude.min+area.longitude.max)/2))
+	latitude := math.Max(GEO_LAT_MIN, math.Min(GEO_LAT_MAX, (area.latitude.min+area.latitude.max)/2))
+	return longitude, latitude
+}
+
+// geohashMoveX moves hash to the east if d is positive, otherwise to the west
+func geohashMoveX(hash *GeoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x += zz + 1
+	} else {
+		x |= zz
+		x -= zz + 1
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// geohashMoveY moves hash to the north if d is positive, otherwise to the south
+func geohashMoveY(hash *GeoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y += zz + 1
+	} else {
+		y |= zz
+		y -= zz + 1
+	}
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// geohashMove return hash moved by dx and dy cells
+func geohashMove(hash GeoHashBits, dx int, dy int) GeoHashBits {
+	if dx != 0 {
+		geohashMoveX(&hash, dx)
+	}
+	if dy != 0 {
+		geohashMoveY(&hash, dy)
+	}
+	return hash
+}
+
+// geohashNeighbors return the 8 geohashes around hash
+func geohashNeighbors(hash GeoHashBits) GeoHashNeighbors {
+	return GeoHashNeighbors{
+		north:     geohashMove(hash, 0, 1),
+		east:      geohashMove(hash, 1, 0),
+		west:      geohashMove(hash, -1, 0),
+		south:     geohashMove(hash, 0, -1),
+		northEast: geohashMove(hash, 1, 1),
+		southEast: geohashMove(hash, 1, -1),
+		northWest: geohashMove(hash, -1, 1),
+		southWest: geohashMove(hash, -1, -1),
+	}
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// geohashGetDistance return the distance in meters between two coordinates with the haversine formula
+func geohashGetDistance(lon1d float64, lat1d float64, lon2d float64, lat2d float64) float64 {
+	lat1r, lon1r := degRad(lat1d), degRad(lon1d)
+	lat2r, lon2r := degRad(lat2d), degRad(lon2d)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2 * EARTH_RADIUS_IN_METERS * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geohashGetLatDistance return the distance in meters between two latitudes
+func geohashGetLatDistance(lat1d float64, lat2d float64) float64 {
+	return EARTH_RADIUS_IN_METERS * math.Abs(degRad(lat2d)-degRad(lat1d))
+}
+
+// geohashEstimateStepsByRadius return the step of geohash whose cell is about the size of rangeMeters
+func geohashEstimateStepsByRadius(rangeMeters float64, latitude float64) uint {
+	if rangeMeters == 0 {
+		return GEO_STEP_MAX
+	}
+	step := 1
+	for rangeMeters < MERCATOR_MAX {
+		rangeMeters *= 2
+		step++
+	}
+	// make sure the neighbors cover the range
+	step -= 2
+
+	// cells are narrower near the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > GEO_STEP_MAX {
+		step = GEO_STEP_MAX
+	}
+	return uint(step)
+}
+
+// geohashBoundingBox return the min longitude, min latitude, max longitude and max latitude
+// of the rectangle around center whose half width and half height are in meters
+func geohashBoundingBox(longitude float64, latitude float64, halfWidth float64, halfHeight float64) [4]float64 {
+	latDelta := radDeg(halfHeight / EARTH_RADIUS_IN_METERS)
+	longDeltaTop := radDeg(halfWidth / EARTH_RADIUS_IN_METERS / math.Cos(degRad(latitude+latDelta)))
+	longDeltaBottom := radDeg(halfWidth / EARTH_RADIUS_IN_METERS / math.Cos(degRad(latitude-latDelta)))
+	// the edge nearer to the equator is wider
+	longDelta := longDeltaTop
+	if latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return [4]float64{longitude - longDelta, latitude - latDelta, longitude + longDelta, latitude + latDelta}
+}
+
+// geohashCalculateAreas return the geohashes which cover the rectangle around center,
+// the neighbors out of the rectangle are excluded
+func geohashCalculateAreas(longitude float64, latitude float64, halfWidth float64, halfHeight float64, radius float64) GeoHashRadius {
+	bounds := geohashBoundingBox(longitude, latitude, halfWidth, halfHeight)
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+
+	steps := geohashEstimateStepsByRadius(radius, latitude)
+	hash, _ := geohashEncode(geoLongRange, geoLatRange, longitude, latitude, steps)
+	neighbors := geohashNeighbors(hash)
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+
+	// the estimated step may be too large when the center is near the edge of the area,
+	// so that the neighbors can't cover the radius
+	north := geohashDecode(geoLongRange, geoLatRange, neighbors.north)
+	south := geohashDecode(geoLongRange, geoLatRange, neighbors.south)
+	east := geohashDecode(geoLongRange, geoLatRange, neighbors.east)
+	west := geohashDecode(geoLongRange, geoLatRange, neighbors.west)
+	if steps > 1 && (geohashGetLatDistance(latitude, north.latitude.max) < radius ||
+		geohashGetLatDistance(latitude, south.latitude.min) < radius ||
+		geohashGetDistance(longitude, latitude, east.longitude.max, latitude) < radius ||
+		geohashGetDistance(longitude, latitude, west.longitude.min, latitude) < radius) {
+		steps--
+		hash, _ = geohashEncode(geoLongRange, geoLatRange, longitude, latitude, steps)
+		neighbors = geohashNeighbors(hash)
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+
+	if steps >= 2 {
+		if area.latitude.min < minLat {
+			neighbors.south.step, neighbors.southWest.step, neighbors.southEast.step = 0, 0, 0
+		}
+		if area.latitude.max > maxLat {
+			neighbors.north.step, neighbors.northWest.step, neighbors.northEast.step = 0, 0, 0
+		}
+		if area.longitude.min < minLon {
+			neighbors.west.step, neighbors.southWest.step, neighbors.northWest.step = 0, 0, 0
+		}
+		if area.longitude.max > maxLon {
+			neighbors.east.step, neighbors.southEast.step, neighbors.northEast.step = 0, 0, 0
+		}
+	}
+	return GeoHashRadius{hash: hash, area: area, neighbors: neighbors}
+}
+
+// geohashAlign52Bits return the 52 bits score of the start of hash
+func geohashAlign52Bits(hash GeoHashBits) uint64 {
+	return hash.bits << (GEO_STEP_MAX*2 - hash.step*2)
+}
@@ -5,16 +5,26 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	DefaultSCFFile = "./SCF/01.scf"
+	DefaultSCFFile           = "./SCF/01.scf"
+	DefaultSnapshotFile      = "./SCF/dump.gdb"
+	DEFAULT_DB_NUM           = 8
+	SERVER_CRON_HZ           = 10
+	CLIENT_QUERY_BUF_SIZE    = 16 * 1024
+	CLIENT_REPLY_MAX_PENDING = 64 * 1024 // replies of pipelined commands are sent once they reach the size
 )
 
 type cmdFunc func(c *Client, s *Server)
 
 // Client stores client info
 type Client struct {
+	Conn        net.Conn
 	Query       *Sdshdr
 	Command     *GodisCommand
 	Argc        int
@@ -22,13 +32,25 @@ type Client struct {
 	Db          *GodisDB
 	Buf         *Sdshdr
 	VirtualFlag bool
+	decoder     *Decoder // decoder keeps the unparsed content from Conn
+	blocked     *blockingState
+	unblocked   chan struct{} // notified when the blocked client is served by others
+	propagate   [][]string    // commands stored in scf instead of Argv if it isn't nil
+
+	pubsubChannels map[string]struct{} // channels the client subscribes
+	pubsubPatterns map[string]struct{} // patterns the client subscribes
+	pushed         chan struct{}       // notified when messages are pushed to the subscribed client
+	closing        bool                // the connection is closed by server
 }
 
 // GodisDB ...
 type GodisDB struct {
-	Dt      *Dict // stores keys
-	Expires *Dict // for timeout keys
-	ID      int   // DB id
+	Dt           *Dict                // stores keys
+	Expires      *Dict                // for timeout keys
+	ID           int                  // DB id
+	blockingKeys map[string][]*Client // clients blocked on keys by blocking pops
+	readyKeys    []string             // keys with clients blocked on them received data
+	snapshots    []*dbSnapshot        // snapshots being taken for the background persistence
 }
 
 // Server stores server info
@@ -41,6 +63,32 @@ type Server struct {
 	Commands    *Dict
 	Dirty       int64
 	SCFFileName string
+	mu          sync.Mutex // commands and cron jobs run exclusively while holding mu
+
+	SCFRewritePerc     int   // rewrite scf automatically when it grows by the percentage, 0 disables it
+	SCFRewriteMinSize  int64 // scf smaller than it won't be rewritten automatically
+	scfCurrentSize     int64
+	scfRewriteBaseSize int64 // size of scf after the latest rewrite or startup
+	scfRewriting       bool
+	scfRewriteBuf      []byte // commands processed during the rewrite
+
+	SCFFsync         int  // policy of flushing scf to disk
+	SCFChecksum      bool // append the checksum to every record of scf
+	SCFLoadTruncated bool // load scf whose last record is truncated instead of refusing to start
+	scfFile          *os.File
+	scfSyncedSize    int64
+	scfLastFsync     time.Time
+	scfFsyncing      int32 // 1 while an fsync is running in background
+	scfCRC           uint64
+	scfSelectedDb    int // the db selected by the latest command in scf, -1 if unknown
+
+	SnapshotFileName string
+	lastSave         int64 // unix time of the latest successful save
+	bgsaving         bool
+
+	pubsubChannels    map[string][]*Client // clients subscribing each channel in the order they subscribe
+	pubsubPatterns    map[string][]*Client // clients subscribing each pattern
+	PubsubOutputLimit int64                // subscribed client exceeding it in output buffer is disconnected, 0 disables it
 }
 
 // GodisCommand ...
@@ -49,18 +97,45 @@ type GodisCommand struct {
 	Proc cmdFunc
 }
 
-// CreateClient create a client
-func (s *Server) CreateClient() *Client {
-	return &Client{
-		Db: s.Db[0],
+// CreateClient create a client, conn is nil if the client is virtual
+func (s *Server) CreateClient(conn net.Conn) *Client {
+	c := &Client{
+		Conn:      conn,
+		Db:        s.Db[0],
+		Buf:       SdsNewEmpty(),
+		unblocked: make(chan struct{}, 1),
+
+		pubsubChannels: make(map[string]struct{}),
+		pubsubPatterns: make(map[string]struct{}),
+		pushed:         make(chan struct{}, 1),
 	}
+	if conn != nil {
+		c.decoder = NewDecoderSize(conn, CLIENT_QUERY_BUF_SIZE)
+	}
+	return c
 }
 
 // InitServer ...
 func InitServer() *Server {
 	ParseConf()
+	s := NewServer(GetConf())
+
+	if err := LoadData(s); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load scf %s err:%v\n", s.SCFFileName, err)
+		os.Exit(-1)
+	}
+	go s.serverCron()
+
+	return s
+}
+
+// NewServer create a server with empty databases
+func NewServer(conf *GodisConfig) *Server {
 	s := new(Server)
-	s.DbNum = 8
+	s.DbNum = DEFAULT_DB_NUM
+	if conf.Databases > 0 {
+		s.DbNum = conf.Databases
+	}
 	s.Db = make([]*GodisDB, s.DbNum)
 	for i := 0; i < s.DbNum; i++ {
 		s.Db[i] = InitDB(i)
@@ -71,26 +146,89 @@ func InitServer() *Server {
 	}
 	s.Commands = NewDict(df)
 	s.SCFFileName = DefaultSCFFile
+	if conf.SCFFileName != "" {
+		s.SCFFileName = conf.SCFFileName
+	}
+	s.SnapshotFileName = DefaultSnapshotFile
+	if conf.SnapshotFileName != "" {
+		s.SnapshotFileName = conf.SnapshotFileName
+	}
+	s.lastSave = time.Now().Unix()
+	s.scfSelectedDb = -1
+	s.SCFRewritePerc = conf.SCFRewritePercentage
+	s.SCFRewriteMinSize = conf.SCFRewriteMinSize
+	s.SCFFsync = parseSCFFsync(conf.SCFFsync)
+	s.SCFChecksum = conf.SCFChecksum
+	s.SCFLoadTruncated = conf.SCFLoadTruncated
+	s.pubsubChannels = make(map[string][]*Client)
+	s.pubsubPatterns = make(map[string][]*Client)
+	s.PubsubOutputLimit = conf.PubsubOutputLimit
 	addCmdFuncs(s)
+	return s
+}
 
-	LoadData(s)
+// serverCron does the background jobs periodically
+func (s *Server) serverCron() {
+	ticker := time.NewTicker(time.Second / SERVER_CRON_HZ)
+	defer ticker.Stop()
+	for range ticker.C {
+		s.mu.Lock()
+		s.databasesCron()
+		s.rewriteSCFIfNeeded()
+		s.fsyncSCFIfNeeded()
+		s.mu.Unlock()
+	}
+}
 
-	return s
+// databasesCron removes the expired keys of each db
+func (s *Server) databasesCron() {
+	deadline := time.Now().Add(ACTIVE_EXPIRE_CYCLE_TIME)
+	for _, db := range s.Db {
+		db.activeExpireCycle(deadline)
+	}
 }
 
-// LoadData ...
-func LoadData(s *Server) {
-	c := s.CreateClient()
-	c.VirtualFlag = true
-	cmds := ReadSCF(s.SCFFileName)
-	for _, cmd := range cmds {
-		c.Query = SdsNewString(cmd)
-		err := c.TransClientContent()
-		if err != nil {
-			log.Errorf("process input buffer error:%+v", err)
+// LoadData loads the snapshot and then replays the commands stored in scf after it.
+// The whole scf is replayed if it isn't the one the snapshot was taken with
+func LoadData(s *Server) error {
+	info, err := loadSnapshot(s.SnapshotFileName, s.Db)
+	if err != nil {
+		return fmt.Errorf("load snapshot %s error:%v", s.SnapshotFileName, err)
+	}
+
+	var offset int64
+	rewrite := false
+	if info != nil {
+		crc, err := scfPrefixCRC(s.SCFFileName, info.scfSize)
+		if err == nil && crc == info.scfCRC {
+			offset = info.scfSize
+		} else if st, err := os.Stat(s.SCFFileName); err == nil && st.Size() > 0 {
+			log.Warnf("scf doesn't match the snapshot, the whole scf is loaded")
+			for _, db := range s.Db {
+				db.Flush()
+			}
+		} else {
+			// scf is rebuilt so that it contains all the keys again
+			log.Warnf("scf is lost, it's rewritten from the snapshot")
+			rewrite = true
+		}
+	}
+	if err := s.loadSCF(offset); err != nil {
+		return err
+	}
+
+	if info, err := os.Stat(s.SCFFileName); err == nil {
+		s.scfCurrentSize = info.Size()
+		s.scfRewriteBaseSize = info.Size()
+		s.scfSyncedSize = info.Size()
+		if s.scfCRC, err = scfPrefixCRC(s.SCFFileName, info.Size()); err != nil {
+			return err
 		}
-		s.ProcessCommand(c)
 	}
+	if rewrite {
+		s.startRewriteSCF()
+	}
+	return nil
 }
 
 // InitDB ...
@@ -103,19 +241,31 @@ func InitDB(id int) *GodisDB {
 	db.Dt = NewDict(df)
 	db.Expires = NewDict(df)
 	db.ID = id
+	db.blockingKeys = make(map[string][]*Client)
 	return db
 }
 
-// ProcessCommand ...
+// ProcessCommand execute the command of client,
+// commands are executed one by one no matter which goroutine calls it
 func (s *Server) ProcessCommand(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c.Argc == 0 {
+		addReplyError(c, "ERR empty command")
+		return
+	}
 	name, ok := c.Argv[0].Ptr.(string)
 	if !ok {
 		log.Errorf("cmd error:%v", ok)
 		os.Exit(1)
 	}
-	cmd := s.LookUpCommand(name)
+	cmd := s.LookUpCommand(strings.ToLower(name))
 	if cmd == nil {
-		addReplyError(c, fmt.Sprintf("error: Unknown command %s", name))
+		addReplyError(c, fmt.Sprintf("ERR unknown command '%s'", name))
+	} else if c.subscriptions() > 0 && !pubsubAllowedCommands[strings.ToLower(name)] {
+		addReplyError(c, fmt.Sprintf("ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE are allowed in this context",
+			strings.ToLower(name)))
 	} else {
 		c.Command = cmd
 		process(c, s)
@@ -124,10 +274,28 @@ func (s *Server) ProcessCommand(c *Client) {
 
 func process(c *Client, s *Server) {
 	dirty := s.Dirty
+	c.propagate = nil
 	c.Command.Proc(c, s)
 	if dirty < s.Dirty && !c.VirtualFlag {
-		AppendToSCF(s.SCFFileName, *(c.Query.SdsGetString()))
+		s.propagateCommand(c.Db.ID, c)
 	}
+	s.handleClientsBlockedOnKeys()
+}
+
+// rewriteClientCommandArgv replaces the argv of client,
+// so the command stored in scf could be different from the one client sent
+func rewriteClientCommandArgv(c *Client, args ...string) {
+	c.Argc = len(args)
+	c.Argv = make([]*Object, c.Argc)
+	for i, arg := range args {
+		c.Argv[i] = NewObject(OBJString, arg)
+	}
+}
+
+// alsoPropagate adds a command stored in scf instead of the argv of client,
+// it's used by commands whose effects depend on the time they're processed
+func alsoPropagate(c *Client, args ...string) {
+	c.propagate = append(c.propagate, args)
 }
 
 // LookUpCommand return the cmd if name is a cmd
@@ -142,20 +310,34 @@ func (s *Server) LookUpCommand(name string) *GodisCommand {
 	return nil
 }
 
-// ReadClientContent read command from client
-func (c *Client) ReadClientContent(conn net.Conn) error {
-
-	buf := make([]byte, 10240)
-	_, err := conn.Read(buf)
+// ReadClientContent read the next command from the query buffer of client,
+// it blocks until a complete command is received
+func (c *Client) ReadClientContent() error {
+	bulks, err := c.decoder.DecodeMultiBulks()
 	if err != nil {
-		log.Errorf("read client %+v cmd err:%+v", conn, err)
-		conn.Close()
 		return err
 	}
-	c.Query = SdsNewBuf(buf)
+	c.setArgv(bulks)
 	return nil
 }
 
+// HasPendingQuery return true if a complete command has been received but not parsed,
+// which means the client sent several commands at once. Part of a command doesn't count,
+// since the rest of it may not arrive until the replies before are received
+func (c *Client) HasPendingQuery() bool {
+	return isCompleteMultiBulk(c.decoder.ByteReader.Peek())
+}
+
+// WriteReply write the content of output buffer to connection
+func (c *Client) WriteReply() error {
+	if c.Buf.SdsLen() == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(c.Buf.SdsGetBuf())
+	c.Buf.SdsClear()
+	return err
+}
+
 // TransClientContent convert the content from client into parameters
 func (c *Client) TransClientContent() error {
 	decoder := NewDecoder(bytes.NewReader(c.Query.SdsGetBuf()))
@@ -165,13 +347,17 @@ func (c *Client) TransClientContent() error {
 		return err
 	}
 
+	c.setArgv(bulks)
+	return nil
+}
+
+func (c *Client) setArgv(bulks []*EncodeData) {
 	c.Argc = len(bulks)
 	c.Argv = make([]*Object, c.Argc)
 
 	for i, bulk := range bulks {
 		c.Argv[i] = NewObject(OBJString, string(bulk.Value))
 	}
-	return nil
 }
 
 func addReplyStatus(c *Client, v string) {
@@ -180,8 +366,7 @@ func addReplyStatus(c *Client, v string) {
 }
 
 func addReplyInt(c *Client, v int64) {
-	s := fmt.Sprintf("(integer) %d", v)
-	e := NewInt([]byte(s))
+	e := NewInt([]byte(strconv.FormatInt(v, 10)))
 	addReply(c, e)
 }
 
@@ -190,10 +375,56 @@ func addReplyError(c *Client, v string) {
 	addReply(c, e)
 }
 
+// addReplyWrongType replies the error when the key holds a value of other type
+func addReplyWrongType(c *Client) {
+	addReplyError(c, "WRONGTYPE Operation against a key holding the wrong kind of value")
+}
+
+// addReplyBulk replies a binary safe string
+func addReplyBulk(c *Client, v string) {
+	e := NewBulk([]byte(v))
+	addReply(c, e)
+}
+
+// addReplyBulkSds replies the content of sds as a binary safe string
+func addReplyBulkSds(c *Client, v *Sdshdr) {
+	e := NewBulk(v.SdsGetBuf())
+	addReply(c, e)
+}
+
+// addReplyFloat replies a float as a bulk string
+func addReplyFloat(c *Client, v float64) {
+	addReplyBulk(c, formatFloat(v))
+}
+
+// addReplyNull replies the null bulk string
+func addReplyNull(c *Client) {
+	addReply(c, NewBulk(nil))
+}
+
+// addReplyNullArray replies the null array
+func addReplyNullArray(c *Client) {
+	addReply(c, NewMultiBulk(nil))
+}
+
+// addReplyArray replies an array, array should not be nil
+func addReplyArray(c *Client, array []*EncodeData) {
+	addReply(c, NewMultiBulk(array))
+}
+
+// addReply appends the encoded reply to the output buffer of client
 func addReply(c *Client, e *EncodeData) {
-	if s, err := EncodeMultiBulk(e); err == nil {
+	s, err := EncodeMultiBulk(e)
+	if err != nil {
+		log.Errorf("encode reply error:%v", err)
+		return
+	}
+	if c.Buf == nil {
 		c.Buf = SdsNewBuf(s)
+		return
 	}
+	str := string(s)
+	c.Buf.SdsCat(&str)
 }
 
 func addCmdFuncs(s *Server) {
@@ -246,6 +477,606 @@ func addCmdFuncs(s *Server) {
 			Name: SdsNewString("zrem"),
 			Proc: ZremCommand,
 		},
+		GodisCommand{
+			Name: SdsNewString("expire"),
+			Proc: ExpireCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pexpire"),
+			Proc: PExpireCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("expireat"),
+			Proc: ExpireAtCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pexpireat"),
+			Proc: PExpireAtCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("ttl"),
+			Proc: TTLCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pttl"),
+			Proc: PTTLCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("persist"),
+			Proc: PersistCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hset"),
+			Proc: HSetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hmset"),
+			Proc: HMSetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hsetnx"),
+			Proc: HSetNXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hget"),
+			Proc: HGetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hmget"),
+			Proc: HMGetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hdel"),
+			Proc: HDelCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hexists"),
+			Proc: HExistsCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hlen"),
+			Proc: HLenCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hstrlen"),
+			Proc: HStrLenCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hkeys"),
+			Proc: HKeysCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hvals"),
+			Proc: HValsCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hgetall"),
+			Proc: HGetAllCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hincrby"),
+			Proc: HIncrByCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hincrbyfloat"),
+			Proc: HIncrByFloatCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("hscan"),
+			Proc: HScanCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sadd"),
+			Proc: SAddCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("srem"),
+			Proc: SRemCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sismember"),
+			Proc: SIsMemberCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("smismember"),
+			Proc: SMIsMemberCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("scard"),
+			Proc: SCardCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("smembers"),
+			Proc: SMembersCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("spop"),
+			Proc: SPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("srandmember"),
+			Proc: SRandMemberCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("smove"),
+			Proc: SMoveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sinter"),
+			Proc: SInterCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sunion"),
+			Proc: SUnionCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sdiff"),
+			Proc: SDiffCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sinterstore"),
+			Proc: SInterStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sunionstore"),
+			Proc: SUnionStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sdiffstore"),
+			Proc: SDiffStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("sscan"),
+			Proc: SScanCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bgrewritescf"),
+			Proc: BgRewriteSCFCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("save"),
+			Proc: SaveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bgsave"),
+			Proc: BgSaveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lastsave"),
+			Proc: LastSaveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("del"),
+			Proc: DelCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("unlink"),
+			Proc: UnlinkCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("exists"),
+			Proc: ExistsCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("type"),
+			Proc: TypeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("rename"),
+			Proc: RenameCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("renamenx"),
+			Proc: RenameNXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("keys"),
+			Proc: KeysCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("randomkey"),
+			Proc: RandomKeyCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("dbsize"),
+			Proc: DBSizeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("touch"),
+			Proc: TouchCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("copy"),
+			Proc: CopyCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("scan"),
+			Proc: ScanCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zscan"),
+			Proc: ZScanCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("select"),
+			Proc: SelectCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("move"),
+			Proc: MoveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("swapdb"),
+			Proc: SwapDBCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("flushdb"),
+			Proc: FlushDBCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("flushall"),
+			Proc: FlushAllCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lpushx"),
+			Proc: LPushXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("rpushx"),
+			Proc: RPushXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lpop"),
+			Proc: LPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("rpop"),
+			Proc: RPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lindex"),
+			Proc: LIndexCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lset"),
+			Proc: LSetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("linsert"),
+			Proc: LInsertCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lrem"),
+			Proc: LRemCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("ltrim"),
+			Proc: LTrimCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lpos"),
+			Proc: LPosCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lmove"),
+			Proc: LMoveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("rpoplpush"),
+			Proc: RPopLPushCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("lmpop"),
+			Proc: LMPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("blpop"),
+			Proc: BLPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("brpop"),
+			Proc: BRPopCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("blmove"),
+			Proc: BLMoveCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bzpopmin"),
+			Proc: BZPopMinCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bzpopmax"),
+			Proc: BZPopMaxCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrevrange"),
+			Proc: ZRevRangeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrangebyscore"),
+			Proc: ZRangeByScoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrevrangebyscore"),
+			Proc: ZRevRangeByScoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrevrank"),
+			Proc: ZRevRankCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zcount"),
+			Proc: ZCountCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zremrangebyscore"),
+			Proc: ZRemRangeByScoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zremrangebyrank"),
+			Proc: ZRemRangeByRankCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zincrby"),
+			Proc: ZIncrByCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zpopmin"),
+			Proc: ZPopMinCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zpopmax"),
+			Proc: ZPopMaxCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrandmember"),
+			Proc: ZRandMemberCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zmscore"),
+			Proc: ZMScoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zcard"),
+			Proc: ZCardCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zunion"),
+			Proc: ZUnionCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zinter"),
+			Proc: ZInterCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zdiff"),
+			Proc: ZDiffCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zunionstore"),
+			Proc: ZUnionStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zinterstore"),
+			Proc: ZInterStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zdiffstore"),
+			Proc: ZDiffStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrangebylex"),
+			Proc: ZRangeByLexCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zrevrangebylex"),
+			Proc: ZRevRangeByLexCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zlexcount"),
+			Proc: ZLexCountCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("zremrangebylex"),
+			Proc: ZRemRangeByLexCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("setnx"),
+			Proc: SetNXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("getset"),
+			Proc: GetSetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("getdel"),
+			Proc: GetDelCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("getex"),
+			Proc: GetExCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("mset"),
+			Proc: MSetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("msetnx"),
+			Proc: MSetNXCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("mget"),
+			Proc: MGetCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("append"),
+			Proc: AppendCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("strlen"),
+			Proc: StrLenCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("getrange"),
+			Proc: GetRangeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("setrange"),
+			Proc: SetRangeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("decr"),
+			Proc: DecrCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("incrby"),
+			Proc: IncrByCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("decrby"),
+			Proc: DecrByCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("incrbyfloat"),
+			Proc: IncrByFloatCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("setbit"),
+			Proc: SetBitCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("getbit"),
+			Proc: GetBitCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bitcount"),
+			Proc: BitCountCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bitpos"),
+			Proc: BitPosCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bitop"),
+			Proc: BitOpCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("bitfield"),
+			Proc: BitFieldCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pfadd"),
+			Proc: PFAddCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pfcount"),
+			Proc: PFCountCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pfmerge"),
+			Proc: PFMergeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geoadd"),
+			Proc: GeoAddCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geopos"),
+			Proc: GeoPosCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geodist"),
+			Proc: GeoDistCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geohash"),
+			Proc: GeoHashCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geosearch"),
+			Proc: GeoSearchCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("geosearchstore"),
+			Proc: GeoSearchStoreCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xadd"),
+			Proc: XAddCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xlen"),
+			Proc: XLenCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xrange"),
+			Proc: XRangeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xrevrange"),
+			Proc: XRevRangeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xdel"),
+			Proc: XDelCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xtrim"),
+			Proc: XTrimCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xread"),
+			Proc: XReadCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xreadgroup"),
+			Proc: XReadGroupCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xgroup"),
+			Proc: XGroupCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xack"),
+			Proc: XAckCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xpending"),
+			Proc: XPendingCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xclaim"),
+			Proc: XClaimCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("xautoclaim"),
+			Proc: XAutoClaimCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("subscribe"),
+			Proc: SubscribeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("unsubscribe"),
+			Proc: UnsubscribeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("psubscribe"),
+			Proc: PSubscribeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("punsubscribe"),
+			Proc: PUnsubscribeCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("publish"),
+			Proc: PublishCommand,
+		},
+		GodisCommand{
+			Name: SdsNewString("pubsub"),
+			Proc: PubsubCommand,
+		},
 	}
 	for i := range cmds {
 		s.Commands.Add(NewObject(OBJSDS, cmds[i].Name), NewObject(OBJCommand, &cmds[i]))
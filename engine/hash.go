@@ -45,3 +45,8 @@ func CompareValueCommon(v1 *Object, v2 *Object) int {
 	}
 	return -1
 }
+
+// GetHash64String return the 64 bits hash value of given string
+func GetHash64String(str string) uint64 {
+	return murmur3.StringSum64(str)
+}
@@ -0,0 +1,516 @@
+package godis
+
+import (
+	"math"
+	"strconv"
+)
+
+const (
+	HASH_MAX_COMPACT_ENTRIES = 128
+	HASH_MAX_COMPACT_VALUE   = 64
+)
+
+// HashMap stores field-value pairs. A small hash map keeps its pairs in a slice
+// to save memory and is converted into dict once it grows beyond the limits
+type HashMap struct {
+	entries []hashEntry // compact encoding, used when dt is nil
+	dt      *Dict
+}
+
+type hashEntry struct {
+	field *Sdshdr
+	value *Sdshdr
+}
+
+// NewHashMap return an empty hash map in compact encoding
+func NewHashMap() *HashMap {
+	return &HashMap{
+		entries: make([]hashEntry, 0),
+	}
+}
+
+// IsCompact return true if hash map is in compact encoding
+func (h *HashMap) IsCompact() bool {
+	return h.dt == nil
+}
+
+// Len return the number of fields in hash map
+func (h *HashMap) Len() int {
+	if h.IsCompact() {
+		return len(h.entries)
+	}
+	return h.dt.Size()
+}
+
+// Get return the value of field or nil if field doesn't exist
+func (h *HashMap) Get(field *Sdshdr) *Sdshdr {
+	if h.IsCompact() {
+		if i := h.search(field); i >= 0 {
+			return h.entries[i].value
+		}
+		return nil
+	}
+	if v := h.dt.Get(NewObject(OBJSDS, field)); v != nil {
+		return v.Ptr.(*Sdshdr)
+	}
+	return nil
+}
+
+// Set set field to value, return true if field is a new field
+func (h *HashMap) Set(field *Sdshdr, value *Sdshdr) bool {
+	if h.IsCompact() {
+		if i := h.search(field); i >= 0 {
+			h.entries[i].value = value
+			h.convertIfNeeded(value)
+			return false
+		}
+		h.entries = append(h.entries, hashEntry{field: field, value: value})
+		h.convertIfNeeded(field, value)
+		return true
+	}
+
+	key := NewObject(OBJSDS, field)
+	if node := h.dt.Search(key); node != nil {
+		node.value = NewObject(OBJSDS, value)
+		return false
+	}
+	h.dt.Add(key, NewObject(OBJSDS, value))
+	return true
+}
+
+// Delete delete field, return true if field exists
+func (h *HashMap) Delete(field *Sdshdr) bool {
+	if h.IsCompact() {
+		i := h.search(field)
+		if i < 0 {
+			return false
+		}
+		h.entries = append(h.entries[:i], h.entries[i+1:]...)
+		return true
+	}
+	return h.dt.Delete(NewObject(OBJSDS, field)) == DICT_OK
+}
+
+// ForEach call fn with each field-value pair until fn return false
+func (h *HashMap) ForEach(fn func(field *Sdshdr, value *Sdshdr) bool) {
+	if h.IsCompact() {
+		for _, e := range h.entries {
+			if !fn(e.field, e.value) {
+				return
+			}
+		}
+		return
+	}
+
+	iter := NewSafeDictIterator(h.dt)
+	defer ReleaseIterator(iter)
+	for node := iter.Next(); node != nil; node = iter.Next() {
+		if !fn(node.key.Ptr.(*Sdshdr), node.value.Ptr.(*Sdshdr)) {
+			return
+		}
+	}
+}
+
+func (h *HashMap) search(field *Sdshdr) int {
+	for i, e := range h.entries {
+		if SdsCmp(e.field, field) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// convertIfNeeded convert hash map into dict encoding if there are too many entries
+// or the new added string is too long
+func (h *HashMap) convertIfNeeded(strs ...*Sdshdr) {
+	convert := len(h.entries) > HASH_MAX_COMPACT_ENTRIES
+	for _, str := range strs {
+		if str.SdsLen() > HASH_MAX_COMPACT_VALUE {
+			convert = true
+		}
+	}
+	if !convert {
+		return
+	}
+
+	df := &DictFunc{
+		calHash:    CalHashCommon,
+		keyCompare: CompareValueCommon,
+	}
+	h.dt = NewDict(df)
+	for _, e := range h.entries {
+		h.dt.Add(NewObject(OBJSDS, e.field), NewObject(OBJSDS, e.value))
+	}
+	h.entries = nil
+}
+
+// hashLookupWrite return the hash map stored at key, create it if key doesn't exist,
+// nil is returned if the key holds a value of other type
+func hashLookupWrite(c *Client, key *Object) *HashMap {
+	value := lookupKey(c, key)
+	if value == nil {
+		value = NewObject(OBJHash, NewHashMap())
+		c.Db.Add(key, value)
+	} else if !checkType(c, value, OBJHash) {
+		return nil
+	}
+	return value.Ptr.(*HashMap)
+}
+
+// hashLookupRead return the hash map stored at key and whether the type of key is right,
+// the hash map is nil if key doesn't exist
+func hashLookupRead(c *Client, key *Object) (*HashMap, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
+	}
+	if !checkType(c, value, OBJHash) {
+		return nil, false
+	}
+	return value.Ptr.(*HashMap), true
+}
+
+// HSetCommand ...
+// HSET key field value [field value ...]
+func HSetCommand(c *Client, s *Server) {
+	if c.Argc < 4 || c.Argc%2 == 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'hset' command")
+		return
+	}
+
+	h := hashLookupWrite(c, c.Argv[1])
+	if h == nil {
+		return
+	}
+	created := 0
+	for i := 2; i < c.Argc; i += 2 {
+		if h.Set(SdsNewString(c.Argv[i].Ptr.(string)), SdsNewString(c.Argv[i+1].Ptr.(string))) {
+			created++
+		}
+	}
+	s.Dirty++
+	addReplyInt(c, int64(created))
+}
+
+// HMSetCommand ...
+// HMSET key field value [field value ...]
+func HMSetCommand(c *Client, s *Server) {
+	if c.Argc < 4 || c.Argc%2 == 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'hmset' command")
+		return
+	}
+
+	h := hashLookupWrite(c, c.Argv[1])
+	if h == nil {
+		return
+	}
+	for i := 2; i < c.Argc; i += 2 {
+		h.Set(SdsNewString(c.Argv[i].Ptr.(string)), SdsNewString(c.Argv[i+1].Ptr.(string)))
+	}
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// HSetNXCommand ...
+func HSetNXCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'hsetnx' command")
+		return
+	}
+
+	h := hashLookupWrite(c, c.Argv[1])
+	if h == nil {
+		return
+	}
+	field := SdsNewString(c.Argv[2].Ptr.(string))
+	if h.Get(field) != nil {
+		addReplyInt(c, 0)
+		return
+	}
+	h.Set(field, SdsNewString(c.Argv[3].Ptr.(string)))
+	s.Dirty++
+	addReplyInt(c, 1)
+}
+
+// HGetCommand ...
+func HGetCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hget' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if h == nil {
+		addReplyNull(c)
+		return
+	}
+	value := h.Get(SdsNewString(c.Argv[2].Ptr.(string)))
+	if value == nil {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulkSds(c, value)
+}
+
+// HMGetCommand ...
+func HMGetCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hmget' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		var value *Sdshdr
+		if h != nil {
+			value = h.Get(SdsNewString(c.Argv[i].Ptr.(string)))
+		}
+		if value == nil {
+			array = append(array, NewBulk(nil))
+		} else {
+			array = append(array, NewBulk(value.SdsGetBuf()))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// HDelCommand ...
+func HDelCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hdel' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if h == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	deleted := 0
+	for i := 2; i < c.Argc; i++ {
+		if h.Delete(SdsNewString(c.Argv[i].Ptr.(string))) {
+			deleted++
+		}
+	}
+	if h.Len() == 0 {
+		c.Db.Delete(c.Argv[1])
+	}
+	if deleted > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, int64(deleted))
+}
+
+// HExistsCommand ...
+func HExistsCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hexists' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if h == nil || h.Get(SdsNewString(c.Argv[2].Ptr.(string))) == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, 1)
+}
+
+// HLenCommand ...
+func HLenCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'hlen' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if h == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(h.Len()))
+}
+
+// HStrLenCommand ...
+func HStrLenCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hstrlen' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	var value *Sdshdr
+	if h != nil {
+		value = h.Get(SdsNewString(c.Argv[2].Ptr.(string)))
+	}
+	if value == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(value.SdsLen()))
+}
+
+// HKeysCommand ...
+func HKeysCommand(c *Client, s *Server) {
+	hashGetAllGenericCommand(c, "hkeys", true, false)
+}
+
+// HValsCommand ...
+func HValsCommand(c *Client, s *Server) {
+	hashGetAllGenericCommand(c, "hvals", false, true)
+}
+
+// HGetAllCommand ...
+func HGetAllCommand(c *Client, s *Server) {
+	hashGetAllGenericCommand(c, "hgetall", true, true)
+}
+
+func hashGetAllGenericCommand(c *Client, name string, fields bool, values bool) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0)
+	if h != nil {
+		h.ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+			if fields {
+				array = append(array, NewBulk(field.SdsGetBuf()))
+			}
+			if values {
+				array = append(array, NewBulk(value.SdsGetBuf()))
+			}
+			return true
+		})
+	}
+	addReplyArray(c, array)
+}
+
+// HIncrByCommand ...
+func HIncrByCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'hincrby' command")
+		return
+	}
+
+	incr, ok := getInt64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+
+	field := SdsNewString(c.Argv[2].Ptr.(string))
+	var num int64
+	if h == nil {
+		h = hashLookupWrite(c, c.Argv[1])
+	} else if value := h.Get(field); value != nil {
+		v, err := strconv.ParseInt(*value.SdsGetString(), 10, 64)
+		if err != nil {
+			addReplyError(c, "ERR hash value is not an integer")
+			return
+		}
+		num = v
+	}
+	if (incr < 0 && num < 0 && incr < math.MinInt64-num) || (incr > 0 && num > 0 && incr > math.MaxInt64-num) {
+		addReplyError(c, "ERR increment or decrement would overflow")
+		return
+	}
+	num += incr
+	h.Set(field, SdsNewString(strconv.FormatInt(num, 10)))
+	s.Dirty++
+	addReplyInt(c, num)
+}
+
+// HIncrByFloatCommand ...
+func HIncrByFloatCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'hincrbyfloat' command")
+		return
+	}
+
+	incr, ok := getFloat64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+
+	field := SdsNewString(c.Argv[2].Ptr.(string))
+	var num float64
+	if h != nil {
+		if value := h.Get(field); value != nil {
+			v, err := strconv.ParseFloat(*value.SdsGetString(), 64)
+			if err != nil {
+				addReplyError(c, "ERR hash value is not a float")
+				return
+			}
+			num = v
+		}
+	}
+	num += incr
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		addReplyError(c, "ERR increment would produce NaN or Infinity")
+		return
+	}
+	if h == nil {
+		h = hashLookupWrite(c, c.Argv[1])
+	}
+	str := formatFloat(num)
+	h.Set(field, SdsNewString(str))
+
+	// store the result in scf so that replay won't be affected by the precision of float
+	rewriteClientCommandArgv(c, "hset", c.Argv[1].Ptr.(string), c.Argv[2].Ptr.(string), str)
+	s.Dirty++
+	addReplyBulk(c, str)
+}
+
+// HScanCommand ...
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func HScanCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'hscan' command")
+		return
+	}
+
+	cursor, ok := parseScanCursorOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	h, ok := hashLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if h == nil {
+		addReplyScan(c, 0, []*EncodeData{})
+		return
+	}
+	scanGenericCommand(c, NewObject(OBJHash, h), cursor, 3)
+}
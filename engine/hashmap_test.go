@@ -0,0 +1,198 @@
+package godis
+
+import (
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashMapConvert(t *testing.T) {
+	h := NewHashMap()
+	for i := 0; i < HASH_MAX_COMPACT_ENTRIES; i++ {
+		h.Set(SdsNewString("f"+strconv.Itoa(i)), SdsNewString(strconv.Itoa(i)))
+	}
+	if !h.IsCompact() {
+		t.Fatalf("hash map with %d entries should be compact", h.Len())
+	}
+
+	h.Set(SdsNewString("one-more"), SdsNewString("v"))
+	if h.IsCompact() {
+		t.Fatalf("hash map with %d entries should be converted", h.Len())
+	}
+	for i := 0; i < HASH_MAX_COMPACT_ENTRIES; i++ {
+		v := h.Get(SdsNewString("f" + strconv.Itoa(i)))
+		if v == nil || *v.SdsGetString() != strconv.Itoa(i) {
+			t.Fatalf("wrong value of f%d after converting", i)
+		}
+	}
+
+	long := NewHashMap()
+	long.Set(SdsNewString("f"), SdsNewString(strings.Repeat("v", HASH_MAX_COMPACT_VALUE+1)))
+	if long.IsCompact() {
+		t.Fatal("hash map with long value should be converted")
+	}
+}
+
+func TestHashMapDelete(t *testing.T) {
+	for _, n := range []int{10, 1000} {
+		h := NewHashMap()
+		for i := 0; i < n; i++ {
+			h.Set(SdsNewString(strconv.Itoa(i)), SdsNewString("v"))
+		}
+		for i := 0; i < n; i += 2 {
+			if !h.Delete(SdsNewString(strconv.Itoa(i))) {
+				t.Fatalf("delete %d failed", i)
+			}
+		}
+		if h.Delete(SdsNewString("0")) {
+			t.Fatal("delete a deleted field should fail")
+		}
+		if h.Len() != n/2 {
+			t.Fatalf("length is %d, expect %d", h.Len(), n/2)
+		}
+		count := 0
+		h.ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+			count++
+			return true
+		})
+		if count != n/2 {
+			t.Fatalf("visited %d fields, expect %d", count, n/2)
+		}
+	}
+}
+
+func TestHashCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"hset h a 1 b 2", ":2\r\n"},
+		{"hset h a 3 c 4", ":1\r\n"},
+		{"hset h a", "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{"hget h a", "$1\r\n3\r\n"},
+		{"hget h nope", "$-1\r\n"},
+		{"hget nope a", "$-1\r\n"},
+		{"hmget h a nope c", "*3\r\n$1\r\n3\r\n$-1\r\n$1\r\n4\r\n"},
+		{"hmget nope a", "*1\r\n$-1\r\n"},
+		{"hincrby h b 10", ":12\r\n"},
+		{"hset h max 9223372036854775807", ":1\r\n"},
+		{"hincrby h max 1", "-ERR increment or decrement would overflow\r\n"},
+		{"hincrby h b -9223372036854775808", ":-9223372036854775796\r\n"},
+		{"hincrby h b -13", "-ERR increment or decrement would overflow\r\n"},
+		{"hincrbyfloat h f 10.5", "$4\r\n10.5\r\n"},
+		{"hincrbyfloat h f 0.1", "$4\r\n10.6\r\n"},
+		{"hdel h a b c max f", ":5\r\n"},
+		{"hlen h", ":0\r\n"},
+		{"hdel h a", ":0\r\n"},
+	} {
+		if r := execCommand(s, c, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+	if c.Db.Dt.Get(NewObject(OBJString, "h")) != nil {
+		t.Fatal("h isn't deleted after all the fields are deleted")
+	}
+
+	// the result of HINCRBYFLOAT is stored in scf as HSET
+	content, err := ioutil.ReadFile(s.SCFFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "hincrbyfloat") {
+		t.Fatal("hincrbyfloat is stored in scf")
+	}
+	if !strings.Contains(string(content), "$4\r\nhset\r\n$1\r\nh\r\n$1\r\nf\r\n$4\r\n10.6\r\n") {
+		t.Fatalf("result of hincrbyfloat isn't stored in scf as hset: %q", content)
+	}
+}
+
+func TestHashEncodingConvert(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	isCompact := func(key string) bool {
+		return c.Db.Dt.Get(NewObject(OBJString, key)).Ptr.(*HashMap).IsCompact()
+	}
+	for i := 0; i < HASH_MAX_COMPACT_ENTRIES; i++ {
+		execCommand(s, c, "hset", "many", "f"+strconv.Itoa(i), "v")
+	}
+	if !isCompact("many") {
+		t.Fatalf("hash with %d fields should be compact", HASH_MAX_COMPACT_ENTRIES)
+	}
+	execCommand(s, c, "hset", "many", "one-more", "v")
+	if isCompact("many") {
+		t.Fatalf("hash with %d fields should be converted", HASH_MAX_COMPACT_ENTRIES+1)
+	}
+	if r := execCommand(s, c, "hget", "many", "f0"); r != "$1\r\nv\r\n" {
+		t.Fatalf("hget many f0 replies %q after converting", r)
+	}
+
+	execCommand(s, c, "hset", "long", "f", strings.Repeat("v", HASH_MAX_COMPACT_VALUE))
+	if !isCompact("long") {
+		t.Fatalf("hash with a value of %d bytes should be compact", HASH_MAX_COMPACT_VALUE)
+	}
+	execCommand(s, c, "hset", "long", "f", strings.Repeat("v", HASH_MAX_COMPACT_VALUE+1))
+	if isCompact("long") {
+		t.Fatalf("hash with a value of %d bytes should be converted", HASH_MAX_COMPACT_VALUE+1)
+	}
+}
+
+func TestHScan(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"hset small a 1 b 2 ab 3", ":3\r\n"},
+		{"hscan small 0", "*2\r\n$1\r\n0\r\n*6\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n$2\r\nab\r\n$1\r\n3\r\n"},
+		{"hscan small 0 match a*", "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$2\r\nab\r\n$1\r\n3\r\n"},
+		{"hscan small 0 count 0", "-ERR syntax error\r\n"},
+		{"hscan small x", "-ERR invalid cursor\r\n"},
+		{"hscan nope 0", "*2\r\n$1\r\n0\r\n*0\r\n"},
+	} {
+		if r := execCommand(s, c, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	// a hash in dict encoding is scanned until the cursor returns to 0
+	expect := make([]string, 0)
+	for i := 0; i < 300; i++ {
+		field := "f" + strconv.Itoa(i)
+		execCommand(s, c, "hset", "big", field, "v")
+		expect = append(expect, field)
+	}
+	fields := make([]string, 0)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		if calls > 1000 {
+			t.Fatal("hscan doesn't finish")
+		}
+		r, err := DecodeFromBytes([]byte(execCommand(s, c, "hscan", "big", cursor, "count", "20")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		elements := r.Array[1].Array
+		for i := 0; i < len(elements); i += 2 {
+			fields = append(fields, string(elements[i].Value))
+		}
+		if cursor = string(r.Array[0].Value); cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(expect)
+	sort.Strings(fields)
+	if strings.Join(fields, " ") != strings.Join(expect, " ") {
+		t.Fatalf("hscan replies %d fields, expect %d", len(fields), len(expect))
+	}
+}
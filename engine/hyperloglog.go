@@ -0,0 +1,467 @@
+package godis
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog is stored in a string value which starts with a header:
+//
+//	+------+-----+-----+-----------------+
+//	| HYLL | E   | N/U | Cardin.         |
+//	+------+-----+-----+-----------------+
+//
+// E is the encoding of registers after the header, the 8 bytes cardinality is
+// cached in little endian, its most significant bit is set if the cache is invalid.
+//
+// Dense registers are 6 bits each, packed from the least significant bit of every byte.
+// Sparse registers are run length encoded with three opcodes:
+//
+//	ZERO:  00xxxxxx           xxxxxx+1 registers are 0
+//	XZERO: 01xxxxxx yyyyyyyy  xxxxxxyyyyyyyy+1 registers are 0
+//	VAL:   1vvvvvxx           xx+1 registers are vvvvv+1
+const (
+	HLL_P                    = 14
+	HLL_Q                    = 64 - HLL_P
+	HLL_REGISTERS            = 1 << HLL_P
+	HLL_P_MASK               = HLL_REGISTERS - 1
+	HLL_BITS                 = 6
+	HLL_REGISTER_MAX         = 1<<HLL_BITS - 1
+	HLL_HDR_SIZE             = 16
+	HLL_DENSE_SIZE           = HLL_HDR_SIZE + (HLL_REGISTERS*HLL_BITS+7)/8
+	HLL_DENSE                = 0
+	HLL_SPARSE               = 1
+	HLL_SPARSE_MAX_BYTES     = 3000
+	HLL_SPARSE_VAL_MAX_VALUE = 32
+	HLL_SPARSE_VAL_MAX_LEN   = 4
+	HLL_SPARSE_ZERO_MAX_LEN  = 64
+	HLL_SPARSE_XZERO_MAX_LEN = 16384
+	HLL_ALPHA_INF            = 0.721347520444481703680
+)
+
+const hllMagic = "HYLL"
+
+// hllNew return an empty HyperLogLog in sparse encoding
+func hllNew() *Sdshdr {
+	buf := make([]byte, HLL_HDR_SIZE, HLL_HDR_SIZE+2)
+	copy(buf, hllMagic)
+	buf[4] = HLL_SPARSE
+	buf = append(buf, hllSparseEncode(make([]uint8, HLL_REGISTERS))...)
+	return SdsNewBuf(buf)
+}
+
+// isHLLObjectOrReply reply error to client if str isn't a HyperLogLog
+func isHLLObjectOrReply(c *Client, str *Sdshdr) bool {
+	buf := str.SdsBuf()
+	if len(buf) < HLL_HDR_SIZE || string(buf[:4]) != hllMagic || buf[4] > HLL_SPARSE ||
+		buf[4] == HLL_DENSE && len(buf) != HLL_DENSE_SIZE {
+		addReplyError(c, "WRONGTYPE Key is not a valid HyperLogLog string value.")
+		return false
+	}
+	return true
+}
+
+// addReplyInvalidHLL replies the error when the sparse registers are corrupted
+func addReplyInvalidHLL(c *Client) {
+	addReplyError(c, "INVALIDOBJ Corrupted HLL object detected")
+}
+
+// hllLookupRead return the HyperLogLog stored at key and whether it's valid, the HyperLogLog is nil if key doesn't exist
+func hllLookupRead(c *Client, key *Object) (*Sdshdr, bool) {
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return nil, false
+	}
+	if str != nil && !isHLLObjectOrReply(c, str) {
+		return nil, false
+	}
+	return str, true
+}
+
+// hllPatLen return the register that element belongs to, and the position of the first 1 bit
+// in the left bits of hash, which is at least 1
+func hllPatLen(element string) (int, uint8) {
+	hash := GetHash64String(element)
+	index := int(hash & HLL_P_MASK)
+	hash >>= HLL_P
+	// make sure the loop terminates
+	hash |= 1 << HLL_Q
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// hllDenseGet return the register at index of dense registers
+func hllDenseGet(registers []byte, index int) uint8 {
+	b := index * HLL_BITS / 8
+	fb := uint(index * HLL_BITS & 7)
+	v := registers[b] >> fb
+	if fb > 8-HLL_BITS {
+		v |= registers[b+1] << (8 - fb)
+	}
+	return v & HLL_REGISTER_MAX
+}
+
+// hllDenseSet sets the register at index of dense registers
+func hllDenseSet(registers []byte, index int, value uint8) {
+	b := index * HLL_BITS / 8
+	fb := uint(index * HLL_BITS & 7)
+	registers[b] &^= HLL_REGISTER_MAX << fb
+	registers[b] |= value << fb
+	if fb > 8-HLL_BITS {
+		registers[b+1] &^= HLL_REGISTER_MAX >> (8 - fb)
+		registers[b+1] |= value >> (8 - fb)
+	}
+}
+
+// hllSparseDecode sets every register in sparse into registers, false is returned if sparse is corrupted
+func hllSparseDecode(sparse []byte, registers []uint8) bool {
+	index := 0
+	for p := 0; p < len(sparse); {
+		op := sparse[p]
+		switch op & 0xc0 {
+		case 0x00:
+			index += int(op&0x3f) + 1
+			p++
+		case 0x40:
+			if p+1 == len(sparse) {
+				return false
+			}
+			index += int(op&0x3f)<<8 | int(sparse[p+1]) + 1
+			p += 2
+		default:
+			value, run := op>>2&0x1f+1, int(op&0x03)+1
+			if index+run > HLL_REGISTERS {
+				return false
+			}
+			for i := index; i < index+run; i++ {
+				registers[i] = value
+			}
+			index += run
+			p++
+		}
+		if index > HLL_REGISTERS {
+			return false
+		}
+	}
+	return index == HLL_REGISTERS
+}
+
+// hllSparseEncode return the sparse representation of registers, nil is returned
+// if any register is too large for the sparse encoding or the result is too long
+func hllSparseEncode(registers []uint8) []byte {
+	sparse := make([]byte, 0)
+	for i := 0; i < HLL_REGISTERS; {
+		value, run := registers[i], 1
+		for i+run < HLL_REGISTERS && registers[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value > HLL_SPARSE_VAL_MAX_VALUE {
+			return nil
+		}
+		for run > 0 {
+			switch {
+			case value != 0:
+				n := run
+				if n > HLL_SPARSE_VAL_MAX_LEN {
+					n = HLL_SPARSE_VAL_MAX_LEN
+				}
+				sparse = append(sparse, 0x80|(value-1)<<2|byte(n-1))
+				run -= n
+			case run <= HLL_SPARSE_ZERO_MAX_LEN:
+				sparse = append(sparse, byte(run-1))
+				run = 0
+			default:
+				n := run
+				if n > HLL_SPARSE_XZERO_MAX_LEN {
+					n = HLL_SPARSE_XZERO_MAX_LEN
+				}
+				sparse = append(sparse, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			}
+		}
+		if len(sparse) > HLL_SPARSE_MAX_BYTES {
+			return nil
+		}
+	}
+	return sparse
+}
+
+// hllRegisters merges the registers of the HyperLogLog into max which keeps the max value of every register,
+// false is returned if the HyperLogLog is corrupted
+func hllRegisters(str *Sdshdr, max []uint8) bool {
+	buf := str.SdsBuf()
+	if buf[4] == HLL_DENSE {
+		for i := 0; i < HLL_REGISTERS; i++ {
+			if v := hllDenseGet(buf[HLL_HDR_SIZE:], i); v > max[i] {
+				max[i] = v
+			}
+		}
+		return true
+	}
+
+	registers := make([]uint8, HLL_REGISTERS)
+	if !hllSparseDecode(buf[HLL_HDR_SIZE:], registers) {
+		return false
+	}
+	for i, v := range registers {
+		if v > max[i] {
+			max[i] = v
+		}
+	}
+	return true
+}
+
+// hllStore replaces the registers of the HyperLogLog, the dense encoding is used
+// if dense is true or the registers can't be sparse
+func hllStore(str *Sdshdr, registers []uint8, dense bool) {
+	var sparse []byte
+	if !dense {
+		sparse = hllSparseEncode(registers)
+	}
+
+	var buf []byte
+	if sparse != nil {
+		buf = make([]byte, HLL_HDR_SIZE+len(sparse))
+		buf[4] = HLL_SPARSE
+		copy(buf[HLL_HDR_SIZE:], sparse)
+	} else {
+		buf = make([]byte, HLL_DENSE_SIZE)
+		buf[4] = HLL_DENSE
+		for i, v := range registers {
+			hllDenseSet(buf[HLL_HDR_SIZE:], i, v)
+		}
+	}
+	copy(buf, hllMagic)
+	hllInvalidateCache(buf)
+	content := string(buf)
+	str.SdsCopy(&content)
+}
+
+// hllAdd adds elements to the HyperLogLog, return whether any register is changed and whether it's valid
+func hllAdd(str *Sdshdr, elements []string) (bool, bool) {
+	buf := str.SdsBuf()
+	updated := false
+	if buf[4] == HLL_DENSE {
+		for _, element := range elements {
+			index, count := hllPatLen(element)
+			if hllDenseGet(buf[HLL_HDR_SIZE:], index) < count {
+				hllDenseSet(buf[HLL_HDR_SIZE:], index, count)
+				updated = true
+			}
+		}
+		if updated {
+			hllInvalidateCache(buf)
+		}
+		return updated, true
+	}
+
+	// sparse registers are updated in a decoded copy, and promoted to dense
+	// when they can't be encoded as sparse any more
+	registers := make([]uint8, HLL_REGISTERS)
+	if !hllSparseDecode(buf[HLL_HDR_SIZE:], registers) {
+		return false, false
+	}
+	for _, element := range elements {
+		index, count := hllPatLen(element)
+		if registers[index] < count {
+			registers[index] = count
+			updated = true
+		}
+	}
+	if updated {
+		hllStore(str, registers, false)
+	}
+	return updated, true
+}
+
+// hllInvalidateCache marks the cached cardinality in the header buf as invalid
+func hllInvalidateCache(buf []byte) {
+	buf[15] |= 1 << 7
+}
+
+// hllSigma is the sigma function of the estimator by Otmar Ertl
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+// hllTau is the tau function of the estimator by Otmar Ertl
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCount estimates the cardinality from registers
+func hllCount(registers []uint8) int64 {
+	var histogram [HLL_REGISTER_MAX + 1]int
+	for _, v := range registers {
+		histogram[v]++
+	}
+
+	m := float64(HLL_REGISTERS)
+	z := m * hllTau((m-float64(histogram[HLL_Q+1]))/m)
+	for j := HLL_Q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return int64(math.Round(HLL_ALPHA_INF * m * m / z))
+}
+
+// hllCard return the cardinality of the HyperLogLog, the result is cached in its header
+func hllCard(str *Sdshdr) (int64, bool) {
+	buf := str.SdsBuf()
+	if buf[15]&(1<<7) == 0 {
+		return int64(binary.LittleEndian.Uint64(buf[8:HLL_HDR_SIZE])), true
+	}
+
+	registers := make([]uint8, HLL_REGISTERS)
+	if !hllRegisters(str, registers) {
+		return 0, false
+	}
+	card := hllCount(registers)
+	binary.LittleEndian.PutUint64(buf[8:HLL_HDR_SIZE], uint64(card))
+	return card, true
+}
+
+// PFAddCommand ...
+// PFADD key [element [element ...]]
+func PFAddCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'pfadd' command")
+		return
+	}
+
+	key := c.Argv[1]
+	str, ok := hllLookupRead(c, key)
+	if !ok {
+		return
+	}
+	created := false
+	if str == nil {
+		str = hllNew()
+		c.Db.Add(key, NewObject(OBJSDS, str))
+		created = true
+	}
+
+	elements := make([]string, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		elements = append(elements, c.Argv[i].Ptr.(string))
+	}
+	updated, valid := hllAdd(str, elements)
+	if !valid {
+		addReplyInvalidHLL(c)
+		return
+	}
+	if created || updated {
+		s.Dirty++
+		addReplyInt(c, 1)
+	} else {
+		addReplyInt(c, 0)
+	}
+}
+
+// PFCountCommand ...
+// PFCOUNT key [key ...]
+func PFCountCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'pfcount' command")
+		return
+	}
+
+	// the cardinality of a single key may be cached
+	if c.Argc == 2 {
+		str, ok := hllLookupRead(c, c.Argv[1])
+		if !ok {
+			return
+		}
+		if str == nil {
+			addReplyInt(c, 0)
+			return
+		}
+		card, valid := hllCard(str)
+		if !valid {
+			addReplyInvalidHLL(c)
+			return
+		}
+		addReplyInt(c, card)
+		return
+	}
+
+	// the cardinality of the union of all keys
+	max := make([]uint8, HLL_REGISTERS)
+	for i := 1; i < c.Argc; i++ {
+		str, ok := hllLookupRead(c, c.Argv[i])
+		if !ok {
+			return
+		}
+		if str != nil && !hllRegisters(str, max) {
+			addReplyInvalidHLL(c)
+			return
+		}
+	}
+	addReplyInt(c, hllCount(max))
+}
+
+// PFMergeCommand ...
+// PFMERGE destkey [sourcekey [sourcekey ...]]
+func PFMergeCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'pfmerge' command")
+		return
+	}
+
+	// destkey is merged too if it exists
+	max := make([]uint8, HLL_REGISTERS)
+	dense := false
+	for i := 1; i < c.Argc; i++ {
+		str, ok := hllLookupRead(c, c.Argv[i])
+		if !ok {
+			return
+		}
+		if str == nil {
+			continue
+		}
+		if !hllRegisters(str, max) {
+			addReplyInvalidHLL(c)
+			return
+		}
+		if str.SdsBuf()[4] == HLL_DENSE {
+			dense = true
+		}
+	}
+
+	key := c.Argv[1]
+	str, _ := stringLookupRead(c, key)
+	if str == nil {
+		str = hllNew()
+		c.Db.Add(key, NewObject(OBJSDS, str))
+	}
+	hllStore(str, max, dense)
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
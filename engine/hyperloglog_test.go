@@ -0,0 +1,163 @@
+package godis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHyperLogLogCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"pfadd h1 a b c d", ":1\r\n"},
+		{"pfadd h1 a b", ":0\r\n"},
+		{"pfadd h1", ":0\r\n"},
+		{"pfadd empty", ":1\r\n"},
+		{"pfcount empty", ":0\r\n"},
+		{"pfcount h1", ":4\r\n"},
+		{"pfadd h2 c d e", ":1\r\n"},
+		{"pfcount h1 h2 nope", ":5\r\n"},
+		{"pfmerge h3 h1 h2", "+OK\r\n"},
+		{"pfcount h3", ":5\r\n"},
+		{"pfmerge h3 empty", "+OK\r\n"},
+		{"pfcount h3", ":5\r\n"},
+		{"pfmerge nope", "+OK\r\n"},
+		{"pfcount nope", ":0\r\n"},
+		{"set str foo", "+OK\r\n"},
+		{"pfadd str a", "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{"pfcount h1 str", "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{"pfmerge h1 str", "-WRONGTYPE Key is not a valid HyperLogLog string value.\r\n"},
+		{"sadd set a", ":1\r\n"},
+		{"pfcount set", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"pfadd", "-ERR wrong number of arguments for 'pfadd' command\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	// corrupted sparse registers, the XZERO covers only 2 registers
+	execCommand(s, c, "set", "bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x40\x01")
+	if r := execCommand(s, c, "pfcount", "bad"); r != "-INVALIDOBJ Corrupted HLL object detected\r\n" {
+		t.Fatalf("pfcount of corrupted HyperLogLog replies %q", r)
+	}
+	execCommand(s, c, "del", "bad")
+
+	// sparse is promoted to dense as the cardinality grows
+	args := []string{"pfadd", "big"}
+	for i := 0; i < 5000; i++ {
+		args = append(args, "e"+strconv.Itoa(i))
+	}
+	execCommand(s, c, args...)
+	big := s.Db[0].Dt.Get(NewObject(OBJString, "big")).Ptr.(*Sdshdr)
+	if big.SdsBuf()[4] != HLL_DENSE || big.SdsLen() != HLL_DENSE_SIZE {
+		t.Fatalf("HyperLogLog of 5000 elements isn't dense, encoding %d", big.SdsBuf()[4])
+	}
+	execCommand(s, c, "pfmerge", "h3", "big")
+	h3 := s.Db[0].Dt.Get(NewObject(OBJString, "h3")).Ptr.(*Sdshdr)
+	if h3.SdsBuf()[4] != HLL_DENSE {
+		t.Fatal("merging a dense HyperLogLog doesn't produce a dense one")
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, key := range []string{"h1", "h2", "h3", "big", "empty"} {
+		if r, expect := execCommand(loaded, lc, "pfcount", key), execCommand(s, c, "pfcount", key); r != expect {
+			t.Fatalf("pfcount %s is %q after loading, expect %q", key, r, expect)
+		}
+	}
+}
+
+func TestHLLSparseEncoding(t *testing.T) {
+	registers := make([]uint8, HLL_REGISTERS)
+	registers[0] = 1
+	registers[100], registers[101], registers[102], registers[103], registers[104] = 32, 32, 32, 32, 32
+	registers[HLL_REGISTERS-1] = 7
+
+	sparse := hllSparseEncode(registers)
+	// VAL, XZERO, VAL of 4 registers, VAL, XZERO, VAL
+	if len(sparse) != 8 {
+		t.Fatalf("sparse representation takes %d bytes: %x", len(sparse), sparse)
+	}
+	decoded := make([]uint8, HLL_REGISTERS)
+	if !hllSparseDecode(sparse, decoded) {
+		t.Fatal("failed to decode the sparse representation")
+	}
+	for i := range registers {
+		if registers[i] != decoded[i] {
+			t.Fatalf("register %d is decoded as %d, expect %d", i, decoded[i], registers[i])
+		}
+	}
+
+	registers[5] = HLL_SPARSE_VAL_MAX_VALUE + 1
+	if hllSparseEncode(registers) != nil {
+		t.Fatal("register larger than the max value of VAL is encoded as sparse")
+	}
+}
+
+func TestHLLDenseRegisters(t *testing.T) {
+	str := hllNew()
+	registers := make([]uint8, HLL_REGISTERS)
+	for i := range registers {
+		registers[i] = uint8(i % (HLL_REGISTER_MAX + 1))
+	}
+	hllStore(str, registers, true)
+	if str.SdsLen() != HLL_DENSE_SIZE {
+		t.Fatalf("dense HyperLogLog takes %d bytes", str.SdsLen())
+	}
+
+	max := make([]uint8, HLL_REGISTERS)
+	if !hllRegisters(str, max) {
+		t.Fatal("failed to read dense registers")
+	}
+	for i := range registers {
+		if registers[i] != max[i] {
+			t.Fatalf("register %d is %d, expect %d", i, max[i], registers[i])
+		}
+	}
+}
+
+// TestHLLAccuracy checks that the relative error is about the standard error 1.04/sqrt(m)
+func TestHLLAccuracy(t *testing.T) {
+	stdError := 1.04 / math.Sqrt(HLL_REGISTERS)
+	for _, card := range []int{10, 100, 1000, 10000, 100000} {
+		var sumSquares float64
+		runs := 20
+		for run := 0; run < runs; run++ {
+			str := hllNew()
+			batch := make([]string, 0, 1000)
+			for i := 0; i < card; i++ {
+				batch = append(batch, strconv.Itoa(run)+":"+strconv.Itoa(i))
+				if len(batch) == cap(batch) || i == card-1 {
+					hllAdd(str, batch)
+					batch = batch[:0]
+				}
+			}
+
+			estimate, _ := hllCard(str)
+			relErr := float64(estimate-int64(card)) / float64(card)
+			// it's about 6 standard errors
+			if math.Abs(relErr) > 0.05 {
+				t.Fatalf("cardinality %d is estimated as %d", card, estimate)
+			}
+			sumSquares += relErr * relErr
+		}
+
+		rmse := math.Sqrt(sumSquares / float64(runs))
+		t.Logf("cardinality %d: relative error %.4f%%, standard error %.4f%%", card, rmse*100, stdError*100)
+		if rmse > stdError*1.5 {
+			t.Fatalf("relative error %.4f%% of cardinality %d is much larger than the standard error", rmse*100, card)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package godis
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+)
+
+const (
+	INTSET_ENC_INT16 = 2
+	INTSET_ENC_INT32 = 4
+	INTSET_ENC_INT64 = 8
+)
+
+// IntSet stores sorted distinct integers in a byte slice, every integer takes
+// encoding bytes which is just large enough for the largest absolute value in it
+type IntSet struct {
+	encoding int
+	length   int
+	contents []byte
+}
+
+// NewIntSet return an empty intset
+func NewIntSet() *IntSet {
+	return &IntSet{
+		encoding: INTSET_ENC_INT16,
+		length:   0,
+		contents: make([]byte, 0),
+	}
+}
+
+func intsetValueEncoding(v int64) int {
+	if v < math.MinInt32 || v > math.MaxInt32 {
+		return INTSET_ENC_INT64
+	} else if v < math.MinInt16 || v > math.MaxInt16 {
+		return INTSET_ENC_INT32
+	}
+	return INTSET_ENC_INT16
+}
+
+// Len return the number of integers in intset
+func (is *IntSet) Len() int {
+	return is.length
+}
+
+// Get return the integer at pos
+func (is *IntSet) Get(pos int) int64 {
+	return is.getEncoded(pos, is.encoding)
+}
+
+func (is *IntSet) getEncoded(pos int, encoding int) int64 {
+	b := is.contents[pos*encoding:]
+	switch encoding {
+	case INTSET_ENC_INT64:
+		return int64(binary.LittleEndian.Uint64(b))
+	case INTSET_ENC_INT32:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	}
+	return int64(int16(binary.LittleEndian.Uint16(b)))
+}
+
+func (is *IntSet) set(pos int, v int64) {
+	b := is.contents[pos*is.encoding:]
+	switch is.encoding {
+	case INTSET_ENC_INT64:
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	case INTSET_ENC_INT32:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	default:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	}
+}
+
+// search return the position of v and true if v is found,
+// otherwise return the position where v should be inserted
+func (is *IntSet) search(v int64) (int, bool) {
+	low, high := 0, is.length-1
+	for low <= high {
+		mid := (low + high) >> 1
+		cur := is.Get(mid)
+		if cur == v {
+			return mid, true
+		} else if cur < v {
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+	return low, false
+}
+
+// Find return true if v is in intset
+func (is *IntSet) Find(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		return false
+	}
+	_, ok := is.search(v)
+	return ok
+}
+
+// Add add v into intset, return false if v already exists
+func (is *IntSet) Add(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		is.upgradeAndAdd(v)
+		return true
+	}
+
+	pos, ok := is.search(v)
+	if ok {
+		return false
+	}
+	is.resize(is.length + 1)
+	copy(is.contents[(pos+1)*is.encoding:], is.contents[pos*is.encoding:(is.length-1)*is.encoding])
+	is.set(pos, v)
+	return true
+}
+
+// upgradeAndAdd upgrade the encoding to fit v, v must be smaller or larger than all the integers
+func (is *IntSet) upgradeAndAdd(v int64) {
+	oldEncoding := is.encoding
+	is.encoding = intsetValueEncoding(v)
+	old := is.contents
+	length := is.length
+
+	is.contents = make([]byte, (length+1)*is.encoding)
+	is.length = length + 1
+
+	offset := 0
+	if v >= 0 {
+		is.set(length, v)
+	} else {
+		is.set(0, v)
+		offset = 1
+	}
+	oldSet := &IntSet{encoding: oldEncoding, length: length, contents: old}
+	for i := 0; i < length; i++ {
+		is.set(i+offset, oldSet.getEncoded(i, oldEncoding))
+	}
+}
+
+// Remove remove v from intset, return false if v doesn't exist
+func (is *IntSet) Remove(v int64) bool {
+	if intsetValueEncoding(v) > is.encoding {
+		return false
+	}
+	pos, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	copy(is.contents[pos*is.encoding:], is.contents[(pos+1)*is.encoding:])
+	is.resize(is.length - 1)
+	return true
+}
+
+// Random return a random integer of intset, intset should not be empty
+func (is *IntSet) Random() int64 {
+	return is.Get(rand.Intn(is.length))
+}
+
+func (is *IntSet) resize(length int) {
+	size := length * is.encoding
+	if size > cap(is.contents) {
+		contents := make([]byte, size, size*2)
+		copy(contents, is.contents)
+		is.contents = contents
+	} else {
+		is.contents = is.contents[:size]
+	}
+	is.length = length
+}
@@ -0,0 +1,91 @@
+package godis
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func checkIntSet(t *testing.T, is *IntSet, expect []int64) {
+	if is.Len() != len(expect) {
+		t.Fatalf("length is %d, expect %d", is.Len(), len(expect))
+	}
+	for i, v := range expect {
+		if is.Get(i) != v {
+			t.Fatalf("value at %d is %d, expect %d", i, is.Get(i), v)
+		}
+	}
+}
+
+func TestIntSetAddRemove(t *testing.T) {
+	is := NewIntSet()
+	values := make(map[int64]bool)
+	for i := 0; i < 1000; i++ {
+		v := rand.Int63n(2000) - 1000
+		if is.Add(v) == values[v] {
+			t.Fatalf("add %d returns wrong result", v)
+		}
+		values[v] = true
+	}
+
+	expect := make([]int64, 0, len(values))
+	for v := range values {
+		expect = append(expect, v)
+	}
+	sort.Slice(expect, func(i, j int) bool { return expect[i] < expect[j] })
+	checkIntSet(t, is, expect)
+
+	for _, v := range expect[:len(expect)/2] {
+		if !is.Remove(v) {
+			t.Fatalf("remove %d failed", v)
+		}
+		if is.Find(v) {
+			t.Fatalf("%d is found after removed", v)
+		}
+	}
+	checkIntSet(t, is, expect[len(expect)/2:])
+}
+
+func TestIntSetUpgrade(t *testing.T) {
+	is := NewIntSet()
+	is.Add(1)
+	is.Add(-1)
+	if is.encoding != INTSET_ENC_INT16 {
+		t.Fatalf("encoding is %d, expect %d", is.encoding, INTSET_ENC_INT16)
+	}
+
+	is.Add(math.MaxInt16 + 1)
+	if is.encoding != INTSET_ENC_INT32 {
+		t.Fatalf("encoding is %d, expect %d", is.encoding, INTSET_ENC_INT32)
+	}
+	checkIntSet(t, is, []int64{-1, 1, math.MaxInt16 + 1})
+
+	is.Add(math.MinInt64)
+	if is.encoding != INTSET_ENC_INT64 {
+		t.Fatalf("encoding is %d, expect %d", is.encoding, INTSET_ENC_INT64)
+	}
+	checkIntSet(t, is, []int64{math.MinInt64, -1, 1, math.MaxInt16 + 1})
+
+	if is.Find(math.MaxInt64) || is.Remove(math.MaxInt64) {
+		t.Fatal("find a value that doesn't exist")
+	}
+}
+
+func TestSetConvert(t *testing.T) {
+	set := NewSet(SdsNewString("1"))
+	for i := 0; i < SET_MAX_INTSET_ENTRIES; i++ {
+		set.Add(SdsNewString(strconv.Itoa(i)))
+	}
+	if !set.IsIntSet() {
+		t.Fatal("set of integers should be an intset")
+	}
+	set.Add(SdsNewString("not an integer"))
+	if set.IsIntSet() || set.Len() != SET_MAX_INTSET_ENTRIES+1 {
+		t.Fatalf("set should be converted, length %d", set.Len())
+	}
+	if !set.IsMember(SdsNewString("42")) {
+		t.Fatal("42 is lost after converting")
+	}
+}
@@ -1,9 +1,8 @@
 package godis
 
 import (
-	"bytes"
-	"fmt"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -241,113 +240,723 @@ func (iter *ListIter) NextNode() *ListNode {
 	return node
 }
 
-// LLenCommand ...
-func LLenCommand(c *Client, s *Server) {
-	if c.Argc < 2 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'llen' command")
+// listLookupRead return the list stored at key and whether the type of key is right,
+// the list is nil if key doesn't exist
+func listLookupRead(c *Client, key *Object) (*List, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
 	}
+	if !checkType(c, value, OBJList) {
+		return nil, false
+	}
+	return value.Ptr.(*List), true
+}
 
-	var length int64
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-
-	if value == nil {
-		length = 0
+// listPush add value at the head of l if where is LIST_START_HEAD, otherwise at the tail
+func listPush(l *List, value *Object, where int) {
+	if where == LIST_START_HEAD {
+		l.AddNodeHead(value)
 	} else {
-		l := value.Ptr.(*List)
-		length = l.Length()
+		l.AddNodeTail(value)
+	}
+}
+
+// listPop remove and return the value at the head of l if where is LIST_START_HEAD, otherwise at the tail
+func listPop(l *List, where int) *Object {
+	node := l.First()
+	if where == LIST_START_TAIL {
+		node = l.Last()
+	}
+	if node == nil {
+		return nil
+	}
+	l.DelNode(node)
+	return node.Value()
+}
+
+// parseListWhere parse LEFT or RIGHT, return false if o is neither of them
+func parseListWhere(o *Object) (int, bool) {
+	switch strings.ToLower(o.Ptr.(string)) {
+	case "left":
+		return LIST_START_HEAD, true
+	case "right":
+		return LIST_START_TAIL, true
+	}
+	return 0, false
+}
+
+// deleteIfEmptyList delete key if the list stored at it has no element
+func deleteIfEmptyList(c *Client, key *Object, l *List) {
+	if l.Length() == 0 {
+		c.Db.Delete(key)
 	}
+}
 
-	addReplyInt(c, length)
+// listRange converts start and end which may be negative into the indexes counted from head,
+// false is returned if the range is empty
+func listRange(start int64, end int64, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if start > end || start >= length {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// LLenCommand ...
+func LLenCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'llen' command")
+		return
+	}
+
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if l == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, l.Length())
 }
 
 // LPushCommand ...
 func LPushCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, "lpush", LIST_START_HEAD, false)
+}
+
+// RPushCommand ...
+func RPushCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, "rpush", LIST_START_TAIL, false)
+}
+
+// LPushXCommand ...
+func LPushXCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, "lpushx", LIST_START_HEAD, true)
+}
+
+// RPushXCommand ...
+func RPushXCommand(c *Client, s *Server) {
+	pushGenericCommand(c, s, "rpushx", LIST_START_TAIL, true)
+}
+
+// pushGenericCommand pushes argv[2:] into list one by one and reply the length of list,
+// nothing is done if xx is true and the list doesn't exist
+func pushGenericCommand(c *Client, s *Server, name string, where int, xx bool) {
 	if c.Argc < 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'lpush' command")
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
 	}
+
 	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		value = NewObject(OBJList, NewList())
-		c.Db.Dt.Add(key, value)
+	l, ok := listLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if l == nil {
+		if xx {
+			addReplyInt(c, 0)
+			return
+		}
+		l = NewList()
+		c.Db.Add(key, NewObject(OBJList, l))
 	}
 
-	l := value.Ptr.(*List)
 	for i := 2; i < c.Argc; i++ {
-		l.AddNodeHead(c.Argv[i])
+		listPush(l, c.Argv[i], where)
 	}
 	s.Dirty++
-	addReplyInt(c, int64(c.Argc-2))
+	addReplyInt(c, l.Length())
 }
 
-// RPushCommand ...
-func RPushCommand(c *Client, s *Server) {
-	if c.Argc < 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'rpush' command")
+// LPopCommand ...
+// LPOP key [count]
+func LPopCommand(c *Client, s *Server) {
+	popGenericCommand(c, s, "lpop", LIST_START_HEAD)
+}
+
+// RPopCommand ...
+// RPOP key [count]
+func RPopCommand(c *Client, s *Server) {
+	popGenericCommand(c, s, "rpop", LIST_START_TAIL)
+}
+
+func popGenericCommand(c *Client, s *Server, name string, where int) {
+	if c.Argc != 2 && c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
 		return
 	}
+
+	count := int64(1)
+	if c.Argc == 3 {
+		var ok bool
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		if count < 0 {
+			addReplyError(c, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
 	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		value = NewObject(OBJList, NewList())
-		c.Db.Dt.Add(key, value)
+	l, ok := listLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if l == nil {
+		if c.Argc == 3 {
+			addReplyNullArray(c)
+		} else {
+			addReplyNull(c)
+		}
+		return
 	}
 
-	l := value.Ptr.(*List)
-	for i := c.Argc - 1; i >= 2; i-- {
-		l.AddNodeTail(c.Argv[i])
+	if c.Argc == 2 {
+		addReplyBulk(c, listPop(l, where).Ptr.(string))
+	} else {
+		addReplyArray(c, listPopElements(l, where, count))
 	}
+	deleteIfEmptyList(c, key, l)
 	s.Dirty++
-	addReplyInt(c, int64(c.Argc-2))
+}
+
+// listPopElements pops at most count elements from list
+func listPopElements(l *List, where int, count int64) []*EncodeData {
+	elements := make([]*EncodeData, 0)
+	for ; count > 0 && l.Length() > 0; count-- {
+		elements = append(elements, NewBulk([]byte(listPop(l, where).Ptr.(string))))
+	}
+	return elements
 }
 
 // LRangeCommand ...
 func LRangeCommand(c *Client, s *Server) {
 	if c.Argc != 4 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'lrange' command")
+		addReplyError(c, "ERR wrong number of arguments for 'lrange' command")
 		return
 	}
 
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		addReplyStatus(c, "the list don't exists")
+	start, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := getInt64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0)
+	if l == nil {
+		addReplyArray(c, array)
+		return
+	}
+	start, end, ok = listRange(start, end, l.Length())
+	if !ok {
+		addReplyArray(c, array)
+		return
+	}
+
+	node := l.Index(start)
+	for i := start; i <= end; i++ {
+		array = append(array, NewBulk([]byte(node.Value().Ptr.(string))))
+		node = node.NextNode()
+	}
+	addReplyArray(c, array)
+}
+
+// LIndexCommand ...
+func LIndexCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'lindex' command")
+		return
+	}
+
+	index, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if l == nil {
+		addReplyNull(c)
+		return
+	}
+	node := l.Index(index)
+	if node == nil {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulk(c, node.Value().Ptr.(string))
+}
+
+// LSetCommand ...
+func LSetCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'lset' command")
 		return
 	}
-	l := value.Ptr.(*List)
 
-	left, err := strconv.ParseInt(c.Argv[2].Ptr.(string), 10, 64)
-	if err != nil {
-		addReplyStatus(c, "(error) ERR value is not an integer or out of range")
+	index, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
 		return
 	}
-	right, err := strconv.ParseInt(c.Argv[3].Ptr.(string), 10, 64)
-	if err != nil {
-		addReplyStatus(c, "(error) ERR value is not an integer or out of range")
+	if l == nil {
+		addReplyError(c, "ERR no such key")
 		return
 	}
+	node := l.Index(index)
+	if node == nil {
+		addReplyError(c, "ERR index out of range")
+		return
+	}
+	node.value = c.Argv[3]
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
 
+// LInsertCommand ...
+// LINSERT key BEFORE|AFTER pivot element
+func LInsertCommand(c *Client, s *Server) {
+	if c.Argc != 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'linsert' command")
+		return
+	}
+
+	var after bool
+	switch strings.ToLower(c.Argv[2].Ptr.(string)) {
+	case "before":
+		after = false
+	case "after":
+		after = true
+	default:
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if l == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	pivot := c.Argv[3].Ptr.(string)
 	iter := l.RewindHead()
-	var i int64
-	var node *ListNode
-	var num int64 = 0
-	for i = 0; i < left; i++ {
-		node = iter.NextNode()
+	for node := iter.NextNode(); node != nil; node = iter.NextNode() {
+		if node.Value().Ptr.(string) == pivot {
+			l.InsertNode(node, c.Argv[4], after)
+			s.Dirty++
+			addReplyInt(c, l.Length())
+			return
+		}
 	}
+	addReplyInt(c, -1)
+}
 
-	b := bytes.Buffer{}
-	for i = left; i <= right; i++ {
-		node = iter.NextNode()
-		if node == nil {
+// LRemCommand ...
+// LREM key count element
+func LRemCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'lrem' command")
+		return
+	}
+
+	count, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	l, ok := listLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if l == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	// remove elements from tail if count is negative
+	iter := l.RewindHead()
+	if count < 0 {
+		iter = l.RewindTail()
+		count = -count
+	}
+	element := c.Argv[3].Ptr.(string)
+	removed := int64(0)
+	for node := iter.NextNode(); node != nil; node = iter.NextNode() {
+		if node.Value().Ptr.(string) != element {
+			continue
+		}
+		l.DelNode(node)
+		removed++
+		if removed == count {
 			break
 		}
-		num++
-		b.WriteString(fmt.Sprintf("%d) %s\n", num, node.Value().Ptr.(string)))
 	}
-	if num > 0 {
-		addReplyStatus(c, b.String())
-	} else {
-		addReplyStatus(c, "the list don't exists or the list is empty")
+	if removed > 0 {
+		deleteIfEmptyList(c, key, l)
+		s.Dirty++
+	}
+	addReplyInt(c, removed)
+}
+
+// LTrimCommand ...
+// LTRIM key start stop
+func LTrimCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'ltrim' command")
+		return
+	}
+
+	start, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := getInt64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	l, ok := listLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if l == nil {
+		addReplyStatus(c, "OK")
+		return
+	}
+
+	length := l.Length()
+	start, end, ok = listRange(start, end, length)
+	if !ok {
+		// remove all the elements
+		start, end = length, length
+	}
+	for i := int64(0); i < start; i++ {
+		listPop(l, LIST_START_HEAD)
+	}
+	for i := end + 1; i < length; i++ {
+		listPop(l, LIST_START_TAIL)
+	}
+	deleteIfEmptyList(c, key, l)
+	s.Dirty++
+	addReplyStatus(c, "OK")
+}
+
+// LPosCommand ...
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func LPosCommand(c *Client, s *Server) {
+	if c.Argc < 3 || c.Argc%2 == 0 {
+		addReplyError(c, "ERR wrong number of arguments for 'lpos' command")
+		return
+	}
+
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 3; i < c.Argc; i += 2 {
+		v, ok := getInt64FromObjectOrReply(c, c.Argv[i+1])
+		if !ok {
+			return
+		}
+		switch strings.ToLower(c.Argv[i].Ptr.(string)) {
+		case "rank":
+			if v == 0 {
+				addReplyError(c, "ERR RANK can't be zero: use 1 to start from the first match, "+
+					"2 from the second ... or use negative to start from the last match")
+				return
+			}
+			rank = v
+		case "count":
+			if v < 0 {
+				addReplyError(c, "ERR COUNT can't be negative")
+				return
+			}
+			count = v
+		case "maxlen":
+			if v < 0 {
+				addReplyError(c, "ERR MAXLEN can't be negative")
+				return
+			}
+			maxLen = v
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	l, ok := listLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	positions := make([]*EncodeData, 0)
+	if l != nil {
+		// a negative rank searches from tail, but the positions are always counted from head
+		iter, index, step := l.RewindHead(), int64(0), int64(1)
+		if rank < 0 {
+			iter, index, step = l.RewindTail(), l.Length()-1, -1
+			rank = -rank
+		}
+		element := c.Argv[2].Ptr.(string)
+		compared := int64(0)
+		for node := iter.NextNode(); node != nil; node = iter.NextNode() {
+			if maxLen != 0 && compared >= maxLen {
+				break
+			}
+			compared++
+			if node.Value().Ptr.(string) == element {
+				if rank > 1 {
+					rank--
+				} else {
+					positions = append(positions, NewInt([]byte(strconv.FormatInt(index, 10))))
+					if count == -1 || int64(len(positions)) == count {
+						break
+					}
+				}
+			}
+			index += step
+		}
+	}
+
+	if count == -1 {
+		if len(positions) == 0 {
+			addReplyNull(c)
+		} else {
+			addReply(c, positions[0])
+		}
+		return
+	}
+	addReplyArray(c, positions)
+}
+
+// LMoveCommand ...
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMoveCommand(c *Client, s *Server) {
+	if c.Argc != 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'lmove' command")
+		return
+	}
+
+	from, ok := parseListWhere(c.Argv[3])
+	if !ok {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	to, ok := parseListWhere(c.Argv[4])
+	if !ok {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	lmoveGenericCommand(c, s, c.Argv[1], c.Argv[2], from, to)
+}
+
+// RPopLPushCommand ...
+func RPopLPushCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'rpoplpush' command")
+		return
+	}
+	lmoveGenericCommand(c, s, c.Argv[1], c.Argv[2], LIST_START_TAIL, LIST_START_HEAD)
+}
+
+// lmoveGenericCommand pops an element from src and pushes it into dst, the element is replied
+func lmoveGenericCommand(c *Client, s *Server, src *Object, dst *Object, from int, to int) {
+	srcList, ok := listLookupRead(c, src)
+	if !ok {
+		return
+	}
+	if srcList == nil {
+		addReplyNull(c)
+		return
+	}
+	dstList, ok := listLookupRead(c, dst)
+	if !ok {
+		return
+	}
+
+	value := listPop(srcList, from)
+	if dstList == nil {
+		dstList = NewList()
+		c.Db.Add(dst, NewObject(OBJList, dstList))
+	}
+	listPush(dstList, value, to)
+	deleteIfEmptyList(c, src, srcList)
+	s.Dirty++
+	addReplyBulk(c, value.Ptr.(string))
+}
+
+// LMPopCommand ...
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func LMPopCommand(c *Client, s *Server) {
+	if c.Argc < 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'lmpop' command")
+		return
+	}
+
+	numKeys, ok := getInt64FromObjectOrReply(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if numKeys <= 0 || numKeys > int64(c.Argc-3) {
+		addReplyError(c, "ERR numkeys should be greater than 0 and not more than the number of keys")
+		return
+	}
+	whereIndex := 2 + int(numKeys)
+	where, ok := parseListWhere(c.Argv[whereIndex])
+	if !ok {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	count := int64(1)
+	if c.Argc > whereIndex+1 {
+		if c.Argc != whereIndex+3 || strings.ToLower(c.Argv[whereIndex+1].Ptr.(string)) != "count" {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[whereIndex+2]); !ok {
+			return
+		}
+		if count <= 0 {
+			addReplyError(c, "ERR count should be greater than 0")
+			return
+		}
+	}
+
+	for _, key := range c.Argv[2:whereIndex] {
+		l, ok := listLookupRead(c, key)
+		if !ok {
+			return
+		}
+		if l == nil {
+			continue
+		}
+		keyName := key.Ptr.(string)
+		elements := listPopElements(l, where, count)
+		deleteIfEmptyList(c, key, l)
+		s.Dirty++
+		addReplyArray(c, []*EncodeData{NewBulk([]byte(keyName)), NewMultiBulk(elements)})
+
+		// the list popped is stored in scf
+		name := "lpop"
+		if where == LIST_START_TAIL {
+			name = "rpop"
+		}
+		rewriteClientCommandArgv(c, name, keyName, strconv.FormatInt(int64(len(elements)), 10))
+		return
+	}
+	addReplyNullArray(c)
+}
+
+// BLPopCommand ...
+// BLPOP key [key ...] timeout
+func BLPopCommand(c *Client, s *Server) {
+	blockingPopGenericCommand(c, s, "blpop", LIST_START_HEAD)
+}
+
+// BRPopCommand ...
+// BRPOP key [key ...] timeout
+func BRPopCommand(c *Client, s *Server) {
+	blockingPopGenericCommand(c, s, "brpop", LIST_START_TAIL)
+}
+
+// blockingPopGenericCommand pops from the first non-empty list of keys,
+// the client is blocked if all of them are empty
+func blockingPopGenericCommand(c *Client, s *Server, name string, where int) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	deadline, ok := getBlockingTimeoutOrReply(c, c.Argv[c.Argc-1])
+	if !ok {
+		return
+	}
+	serve := func(c *Client, s *Server, key *Object) {
+		l := lookupKey(c, key).Ptr.(*List)
+		value := listPop(l, where)
+		deleteIfEmptyList(c, key, l)
+		s.Dirty++
+		addReplyArray(c, []*EncodeData{NewBulk([]byte(key.Ptr.(string))), NewBulk([]byte(value.Ptr.(string)))})
+
+		popName := "lpop"
+		if where == LIST_START_TAIL {
+			popName = "rpop"
+		}
+		rewriteClientCommandArgv(c, popName, key.Ptr.(string))
+	}
+
+	keys := c.Argv[1 : c.Argc-1]
+	for _, key := range keys {
+		l, ok := listLookupRead(c, key)
+		if !ok {
+			return
+		}
+		if l != nil {
+			serve(c, s, key)
+			return
+		}
+	}
+	blockForKeys(c, keys, OBJList, deadline, serve, addReplyNullArray)
+}
+
+// BLMoveCommand ...
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func BLMoveCommand(c *Client, s *Server) {
+	if c.Argc != 6 {
+		addReplyError(c, "ERR wrong number of arguments for 'blmove' command")
+		return
+	}
+
+	from, ok := parseListWhere(c.Argv[3])
+	if !ok {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	to, ok := parseListWhere(c.Argv[4])
+	if !ok {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	deadline, ok := getBlockingTimeoutOrReply(c, c.Argv[5])
+	if !ok {
+		return
+	}
+	src, dst := c.Argv[1], c.Argv[2]
+	fromName, toName := c.Argv[3].Ptr.(string), c.Argv[4].Ptr.(string)
+	serve := func(c *Client, s *Server, key *Object) {
+		lmoveGenericCommand(c, s, src, dst, from, to)
+		rewriteClientCommandArgv(c, "lmove", src.Ptr.(string), dst.Ptr.(string), fromName, toName)
+	}
+
+	l, ok := listLookupRead(c, src)
+	if !ok {
+		return
+	}
+	if l != nil {
+		serve(c, s, src)
+		return
 	}
+	blockForKeys(c, []*Object{src}, OBJList, deadline, serve, addReplyNull)
 }
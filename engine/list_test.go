@@ -1,9 +1,13 @@
 package godis
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
 
 // TestList ...
-func TestList() {
+func TestList(t *testing.T) {
 	matchFunc := func(v1 *Object, v2 *Object) bool {
 		if v1.ObjectType != v2.ObjectType {
 			return false
@@ -104,3 +108,69 @@ func testListOutputList(l *List) {
 	}
 	fmt.Println()
 }
+
+func TestListCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"rpush l a b c", ":3\r\n"},
+		{"lpush l z", ":4\r\n"},
+		{"lpushx nope a", ":0\r\n"},
+		{"rpushx l d", ":5\r\n"},
+		{"lrange l -3 -1", "*3\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{"lrange l 3 1", "*0\r\n"},
+		{"lindex l -1", "$1\r\nd\r\n"},
+		{"lindex l 5", "$-1\r\n"},
+		{"lset l -2 C", "+OK\r\n"},
+		{"lset l 9 x", "-ERR index out of range\r\n"},
+		{"lset nope 0 x", "-ERR no such key\r\n"},
+		{"linsert l before C b", ":6\r\n"},
+		{"linsert l after nope x", ":-1\r\n"},
+		{"lrange l 0 -1", "*6\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nb\r\n$1\r\nC\r\n$1\r\nd\r\n"},
+		{"lpos l b", ":2\r\n"},
+		{"lpos l b rank -1", ":3\r\n"},
+		{"lpos l b count 0", "*2\r\n:2\r\n:3\r\n"},
+		{"lpos l b maxlen 2", "$-1\r\n"},
+		{"lpos l b rank 0", "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match\r\n"},
+		{"lrem l -1 b", ":1\r\n"},
+		{"ltrim l 1 -2", "+OK\r\n"},
+		{"lrange l 0 -1", "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nC\r\n"},
+		{"lmove l dst right left", "$1\r\nC\r\n"},
+		{"rpoplpush l dst", "$1\r\nb\r\n"},
+		{"lpop l 0", "*0\r\n"},
+		{"lpop l 2", "*1\r\n$1\r\na\r\n"},
+		{"exists l", ":0\r\n"},
+		{"lpop l", "$-1\r\n"},
+		{"lpop l 1", "*-1\r\n"},
+		{"lpop l -1", "-ERR value is out of range, must be positive\r\n"},
+		{"rpush l2 x y", ":2\r\n"},
+		{"lmpop 3 nope l2 dst right count 5", "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\ny\r\n$1\r\nx\r\n"},
+		{"lmpop 1 nope left", "*-1\r\n"},
+		{"lmpop 0 l left", "-ERR numkeys should be greater than 0 and not more than the number of keys\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"lpush str v", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"rpop dst", "$1\r\nC\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "lrange", "dst", "0", "-1"); r != "*1\r\n$1\r\nb\r\n" {
+		t.Fatalf("dst is %q after loading", r)
+	}
+	if r := execCommand(loaded, lc, "exists", "l", "l2"); r != ":0\r\n" {
+		t.Fatalf("popped lists exist after loading: %q", r)
+	}
+}
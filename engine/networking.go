@@ -0,0 +1,89 @@
+package godis
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// HandleConn serves the commands sent by conn until it's closed
+func (s *Server) HandleConn(conn net.Conn) {
+	atomic.AddInt32(&s.Clients, 1)
+	defer atomic.AddInt32(&s.Clients, -1)
+	defer conn.Close()
+
+	c := s.CreateClient(conn)
+	defer s.freeClient(c)
+	for {
+		// messages are pushed to the subscribed client while it's waiting for commands
+		if s.isSubscribed(c) {
+			if err := s.waitPushed(c); err != nil {
+				// the error is expected if the connection is closed for its output buffer exceeds the limit
+				if err != io.EOF && !s.isClosing(c) {
+					log.Errorf("write pushed messages error:%+v", err)
+				}
+				return
+			}
+		}
+
+		err := c.ReadClientContent()
+		if err != nil {
+			if err != io.EOF && !s.isClosing(c) {
+				log.Errorf("read query content error:%+v", err)
+			}
+			return
+		}
+		s.ProcessCommand(c)
+		if s.isBlocked(c) {
+			if err := s.waitUnblocked(c); err != nil {
+				if err != io.EOF {
+					log.Errorf("read query content error:%+v", err)
+				}
+				return
+			}
+			continue
+		}
+
+		// replies of pipelined commands are sent together after all of them are processed,
+		// unless they grow too large
+		if c.HasPendingQuery() && s.pendingReplyLen(c) < CLIENT_REPLY_MAX_PENDING {
+			continue
+		}
+		if err := s.writeReply(c); err != nil {
+			if !s.isClosing(c) {
+				log.Errorf("write reply error:%+v", err)
+			}
+			return
+		}
+	}
+}
+
+// writeReply writes the output buffer of client to connection, the buffer is taken while holding s.mu
+// because messages may be pushed into it by other clients
+func (s *Server) writeReply(c *Client) error {
+	s.mu.Lock()
+	b := c.Buf.SdsGetBuf()
+	c.Buf.SdsClear()
+	s.mu.Unlock()
+
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := c.Conn.Write(b)
+	return err
+}
+
+// pendingReplyLen return the size of the output buffer of client
+func (s *Server) pendingReplyLen(c *Client) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.Buf.SdsLen()
+}
+
+// freeClient releases what the client holds after its connection is closed
+func (s *Server) freeClient(c *Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pubsubUnsubscribeAllChannels(c, s, false)
+	pubsubUnsubscribeAllPatterns(c, s, false)
+}
@@ -1,5 +1,10 @@
 package godis
 
+import (
+	"math"
+	"strconv"
+)
+
 // Object stores data whose type is Object.Type
 type Object struct {
 	ObjectType int
@@ -14,6 +19,8 @@ const OBJZset = 4
 const OBJHash = 5
 const OBJList = 6
 const OBJCommand = 7
+const OBJZslNode = 8 // value of the member dict of zset
+const OBJStream = 9
 
 // NewObject return a new Object
 func NewObject(tp int, ptr interface{}) *Object {
@@ -22,3 +29,107 @@ func NewObject(tp int, ptr interface{}) *Object {
 	o.Ptr = ptr
 	return o
 }
+
+// objectTypeName return the name of the type of o replied by TYPE
+func objectTypeName(o *Object) string {
+	switch o.ObjectType {
+	case OBJSDS:
+		return "string"
+	case OBJList:
+		return "list"
+	case OBJSet:
+		return "set"
+	case OBJZset:
+		return "zset"
+	case OBJHash:
+		return "hash"
+	case OBJStream:
+		return "stream"
+	}
+	return "none"
+}
+
+// getInt64FromObjectOrReply parse o as int64, reply error to client if o isn't an integer
+func getInt64FromObjectOrReply(c *Client, o *Object) (int64, bool) {
+	v, err := strconv.ParseInt(o.Ptr.(string), 10, 64)
+	if err != nil {
+		addReplyError(c, "ERR value is not an integer or out of range")
+		return 0, false
+	}
+	return v, true
+}
+
+// getFloat64FromObjectOrReply parse o as float64, reply error to client if o isn't a valid float
+func getFloat64FromObjectOrReply(c *Client, o *Object) (float64, bool) {
+	v, err := strconv.ParseFloat(o.Ptr.(string), 64)
+	if err != nil || math.IsNaN(v) {
+		addReplyError(c, "ERR value is not a valid float")
+		return 0, false
+	}
+	return v, true
+}
+
+// checkType reply error to client and return false if the type of o isn't tp
+func checkType(c *Client, o *Object, tp int) bool {
+	if o.ObjectType != tp {
+		addReplyWrongType(c)
+		return false
+	}
+	return true
+}
+
+// formatFloat return the shortest representation of f, inf and -inf for infinity
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// dupObject return a deep copy of o, so it won't be changed by the later commands
+func dupObject(o *Object) *Object {
+	switch o.ObjectType {
+	case OBJSDS:
+		return NewObject(OBJSDS, o.Ptr.(*Sdshdr).SdsDup())
+	case OBJList:
+		l := NewList()
+		for iter := o.Ptr.(*List).RewindHead(); ; {
+			node := iter.NextNode()
+			if node == nil {
+				break
+			}
+			l.AddNodeTail(dupObject(node.Value()))
+		}
+		return NewObject(OBJList, l)
+	case OBJZset:
+		src := o.Ptr.(*ZskipList)
+		zsl := NewZsl()
+		for node := src.header.level[0].forward; node != nil; node = node.level[0].forward {
+			zsl.addMember(node.score, node.value.SdsDup())
+		}
+		return NewObject(OBJZset, zsl)
+	case OBJHash:
+		h := NewHashMap()
+		o.Ptr.(*HashMap).ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+			h.Set(field.SdsDup(), value.SdsDup())
+			return true
+		})
+		return NewObject(OBJHash, h)
+	case OBJSet:
+		var set *Set
+		o.Ptr.(*Set).ForEach(func(member *Sdshdr) bool {
+			if set == nil {
+				set = NewSet(member)
+			}
+			set.Add(member.SdsDup())
+			return true
+		})
+		return NewObject(OBJSet, set)
+	case OBJStream:
+		return NewObject(OBJStream, o.Ptr.(*Stream).dup())
+	}
+	// strings are immutable
+	return NewObject(o.ObjectType, o.Ptr)
+}
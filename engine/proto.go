@@ -17,6 +17,11 @@ const (
 	TypeMultiBulk = '*'
 )
 
+const (
+	MaxBulkLen      = 512 * 1024 * 1024
+	MaxMultiBulkLen = 1024 * 1024
+)
+
 // EncodeData is the struct to store encoding data of cmd
 type EncodeData struct {
 	Err   error
@@ -173,6 +178,9 @@ func (e *Encoder) encodeInt(v int64) error {
 }
 
 func (e *Encoder) encodeBulkBytes(value []byte) error {
+	if value == nil { // null bulk string
+		return e.encodeInt(-1)
+	}
 	if err := e.encodeInt(int64(len(value))); err != nil {
 		return err
 	}
@@ -181,7 +189,7 @@ func (e *Encoder) encodeBulkBytes(value []byte) error {
 }
 
 func (e *Encoder) encodeMultiBulkArray(bulks []*EncodeData) error {
-	if len(bulks) == 0 {
+	if bulks == nil { // null array
 		return e.encodeInt(-1)
 	}
 	if err := e.encodeInt(int64(len(bulks))); err != nil {
@@ -206,12 +214,27 @@ func NewDecoder(reader io.Reader) *Decoder {
 	return &Decoder{ByteReader: bufio2.NewReaderSize(reader, 2048)}
 }
 
+// NewDecoderSize generate a decoder whose buffer size is size
+func NewDecoderSize(reader io.Reader, size int) *Decoder {
+	return &Decoder{ByteReader: bufio2.NewReaderSize(reader, size)}
+}
+
 // DecodeFromBytes decode bytes
 func DecodeFromBytes(b []byte) (*EncodeData, error) {
 	d := NewDecoder(bytes.NewReader(b))
 	return d.decodeData()
 }
 
+// Decode decode one reply of any type
+func (d *Decoder) Decode() (*EncodeData, error) {
+	result, err := d.decodeData()
+	if err != nil {
+		d.Err = err
+		return nil, err
+	}
+	return result, nil
+}
+
 // DecodeMultiBulks decode multibulks into several parts
 // for example,*3\r\n$3\r\nset\r\n$3\r\nnum\r\n$1\r\n5\r\n ---> set num 5
 func (d *Decoder) DecodeMultiBulks() ([]*EncodeData, error) {
@@ -241,6 +264,9 @@ func (d *Decoder) decodeMultiBulks() ([]*EncodeData, error) {
 		log.Errorf("decode int err:%v", err)
 		return nil, err
 	}
+	if n < 0 || n > MaxMultiBulkLen {
+		return nil, errorNew("invalid multibulk length")
+	}
 
 	bulks := make([]*EncodeData, n)
 	for i := range bulks {
@@ -257,6 +283,40 @@ func (d *Decoder) decodeMultiBulks() ([]*EncodeData, error) {
 	return bulks, nil
 }
 
+// parseLenLine parses the line of type tp with a length at the start of b, such as "*3\r\n",
+// the content after the line is returned
+func parseLenLine(b []byte, tp byte) (int64, []byte, bool) {
+	if len(b) == 0 || b[0] != tp {
+		return 0, nil, false
+	}
+	end := bytes.Index(b, []byte("\r\n"))
+	if end == -1 {
+		return 0, nil, false
+	}
+	n, err := strconv.ParseInt(string(b[1:end]), 10, 64)
+	if err != nil {
+		return 0, nil, false
+	}
+	return n, b[end+2:], true
+}
+
+// isCompleteMultiBulk return true if b starts with a complete multibulk command
+func isCompleteMultiBulk(b []byte) bool {
+	n, b, ok := parseLenLine(b, TypeMultiBulk)
+	if !ok || n < 0 || n > MaxMultiBulkLen {
+		return false
+	}
+	for i := int64(0); i < n; i++ {
+		var l int64
+		l, b, ok = parseLenLine(b, TypeBulk)
+		if !ok || l < 0 || int64(len(b)) < l+2 {
+			return false
+		}
+		b = b[l+2:]
+	}
+	return true
+}
+
 func (d *Decoder) decodeData() (*EncodeData, error) {
 	t, err := d.ByteReader.ReadByte()
 	if err != nil {
@@ -272,6 +332,8 @@ func (d *Decoder) decodeData() (*EncodeData, error) {
 		e.Value, err = d.decodeBulkBytes()
 	case TypeMultiBulk:
 		e.Array, err = d.decodeMultiBulkArray()
+	default:
+		err = errorNew("bad reply type " + strconv.Quote(string(t)))
 	}
 	return e, err
 }
@@ -290,6 +352,11 @@ func (d *Decoder) decodeBulkBytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if n < -1 || n > MaxBulkLen {
+		return nil, errorNew("bad bulk length")
+	} else if n == -1 { // null bulk string
+		return nil, nil
+	}
 	b, err := d.ByteReader.ReadBytesLen(int(n) + 2)
 	if err != nil {
 		return nil, err
@@ -302,6 +369,11 @@ func (d *Decoder) decodeMultiBulkArray() ([]*EncodeData, error) {
 	if err != nil {
 		return nil, err
 	}
+	if n < -1 || n > MaxMultiBulkLen {
+		return nil, errorNew("bad array length")
+	} else if n == -1 { // null array
+		return nil, nil
+	}
 	bulks := make([]*EncodeData, n)
 	for i := range bulks {
 		bulk, err := d.decodeData()
@@ -0,0 +1,315 @@
+package godis
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nk-akun/godis/engine/util"
+)
+
+const (
+	PUBSUB_OUTPUT_LIMIT = 32 * 1024 * 1024 // default limit of the output buffer of subscribed client
+)
+
+// commands which can be sent by the client in subscribe mode
+var pubsubAllowedCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+}
+
+// subscriptions return the number of channels and patterns client subscribes
+func (c *Client) subscriptions() int {
+	return len(c.pubsubChannels) + len(c.pubsubPatterns)
+}
+
+// pushReply appends the message to the output buffer of a subscribed client and notifies its connection,
+// the buffer is written out by the goroutine serving the connection. The client is disconnected once the
+// buffer exceeds the limit as it doesn't read the messages fast enough
+func pushReply(c *Client, s *Server, e *EncodeData) {
+	if c.closing {
+		return
+	}
+	addReply(c, e)
+	if s.PubsubOutputLimit > 0 && int64(c.Buf.SdsLen()) > s.PubsubOutputLimit {
+		log.Warnf("subscribed client is closed for its output buffer of %d bytes exceeds the limit", c.Buf.SdsLen())
+		c.closing = true
+		c.Buf.SdsClear()
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+		return
+	}
+	select {
+	case c.pushed <- struct{}{}:
+	default:
+	}
+}
+
+// pubsubReply return the reply of (p)subscribe and (p)unsubscribe, name is nil if there was no subscription
+func pubsubReply(kind string, name *string, count int) *EncodeData {
+	var bulk []byte
+	if name != nil {
+		bulk = []byte(*name)
+	}
+	return NewMultiBulk([]*EncodeData{
+		NewBulk([]byte(kind)),
+		NewBulk(bulk),
+		NewInt([]byte(strconv.Itoa(count))),
+	})
+}
+
+// removeClient return clients without c
+func removeClient(clients []*Client, c *Client) []*Client {
+	for i, client := range clients {
+		if client == c {
+			return append(clients[:i], clients[i+1:]...)
+		}
+	}
+	return clients
+}
+
+// pubsubSubscribeChannel subscribes client to channel, and replies the number of subscriptions
+func pubsubSubscribeChannel(c *Client, s *Server, channel string) {
+	if _, ok := c.pubsubChannels[channel]; !ok {
+		c.pubsubChannels[channel] = struct{}{}
+		s.pubsubChannels[channel] = append(s.pubsubChannels[channel], c)
+	}
+	addReply(c, pubsubReply("subscribe", &channel, c.subscriptions()))
+}
+
+// pubsubUnsubscribeChannel unsubscribes client from channel, the reply is omitted if notify is false
+func pubsubUnsubscribeChannel(c *Client, s *Server, channel string, notify bool) {
+	if _, ok := c.pubsubChannels[channel]; ok {
+		delete(c.pubsubChannels, channel)
+		clients := removeClient(s.pubsubChannels[channel], c)
+		if len(clients) == 0 {
+			delete(s.pubsubChannels, channel)
+		} else {
+			s.pubsubChannels[channel] = clients
+		}
+	}
+	if notify {
+		addReply(c, pubsubReply("unsubscribe", &channel, c.subscriptions()))
+	}
+}
+
+// pubsubUnsubscribeAllChannels unsubscribes client from all the channels in order
+func pubsubUnsubscribeAllChannels(c *Client, s *Server, notify bool) {
+	channels := make([]string, 0, len(c.pubsubChannels))
+	for channel := range c.pubsubChannels {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		pubsubUnsubscribeChannel(c, s, channel, notify)
+	}
+	if notify && len(channels) == 0 {
+		addReply(c, pubsubReply("unsubscribe", nil, c.subscriptions()))
+	}
+}
+
+// pubsubSubscribePattern subscribes client to the channels matching pattern
+func pubsubSubscribePattern(c *Client, s *Server, pattern string) {
+	if _, ok := c.pubsubPatterns[pattern]; !ok {
+		c.pubsubPatterns[pattern] = struct{}{}
+		s.pubsubPatterns[pattern] = append(s.pubsubPatterns[pattern], c)
+	}
+	addReply(c, pubsubReply("psubscribe", &pattern, c.subscriptions()))
+}
+
+// pubsubUnsubscribePattern unsubscribes client from pattern, the reply is omitted if notify is false
+func pubsubUnsubscribePattern(c *Client, s *Server, pattern string, notify bool) {
+	if _, ok := c.pubsubPatterns[pattern]; ok {
+		delete(c.pubsubPatterns, pattern)
+		clients := removeClient(s.pubsubPatterns[pattern], c)
+		if len(clients) == 0 {
+			delete(s.pubsubPatterns, pattern)
+		} else {
+			s.pubsubPatterns[pattern] = clients
+		}
+	}
+	if notify {
+		addReply(c, pubsubReply("punsubscribe", &pattern, c.subscriptions()))
+	}
+}
+
+// pubsubUnsubscribeAllPatterns unsubscribes client from all the patterns in order
+func pubsubUnsubscribeAllPatterns(c *Client, s *Server, notify bool) {
+	patterns := make([]string, 0, len(c.pubsubPatterns))
+	for pattern := range c.pubsubPatterns {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		pubsubUnsubscribePattern(c, s, pattern, notify)
+	}
+	if notify && len(patterns) == 0 {
+		addReply(c, pubsubReply("punsubscribe", nil, c.subscriptions()))
+	}
+}
+
+// pubsubPublishMessage pushes message to the clients subscribing channel or the patterns matching it,
+// return the number of clients receiving it
+func pubsubPublishMessage(s *Server, channel string, message string) int64 {
+	var receivers int64
+	for _, c := range s.pubsubChannels[channel] {
+		pushReply(c, s, NewMultiBulk([]*EncodeData{
+			NewBulk([]byte("message")),
+			NewBulk([]byte(channel)),
+			NewBulk([]byte(message)),
+		}))
+		receivers++
+	}
+	for pattern, clients := range s.pubsubPatterns {
+		if !util.StringMatch(pattern, channel, false) {
+			continue
+		}
+		for _, c := range clients {
+			pushReply(c, s, NewMultiBulk([]*EncodeData{
+				NewBulk([]byte("pmessage")),
+				NewBulk([]byte(pattern)),
+				NewBulk([]byte(channel)),
+				NewBulk([]byte(message)),
+			}))
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// SubscribeCommand ...
+// SUBSCRIBE channel [channel ...]
+func SubscribeCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'subscribe' command")
+		return
+	}
+	for i := 1; i < c.Argc; i++ {
+		pubsubSubscribeChannel(c, s, c.Argv[i].Ptr.(string))
+	}
+}
+
+// UnsubscribeCommand ...
+// UNSUBSCRIBE [channel [channel ...]]
+func UnsubscribeCommand(c *Client, s *Server) {
+	if c.Argc == 1 {
+		pubsubUnsubscribeAllChannels(c, s, true)
+		return
+	}
+	for i := 1; i < c.Argc; i++ {
+		pubsubUnsubscribeChannel(c, s, c.Argv[i].Ptr.(string), true)
+	}
+}
+
+// PSubscribeCommand ...
+// PSUBSCRIBE pattern [pattern ...]
+func PSubscribeCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'psubscribe' command")
+		return
+	}
+	for i := 1; i < c.Argc; i++ {
+		pubsubSubscribePattern(c, s, c.Argv[i].Ptr.(string))
+	}
+}
+
+// PUnsubscribeCommand ...
+// PUNSUBSCRIBE [pattern [pattern ...]]
+func PUnsubscribeCommand(c *Client, s *Server) {
+	if c.Argc == 1 {
+		pubsubUnsubscribeAllPatterns(c, s, true)
+		return
+	}
+	for i := 1; i < c.Argc; i++ {
+		pubsubUnsubscribePattern(c, s, c.Argv[i].Ptr.(string), true)
+	}
+}
+
+// PublishCommand ...
+// PUBLISH channel message
+func PublishCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'publish' command")
+		return
+	}
+	addReplyInt(c, pubsubPublishMessage(s, c.Argv[1].Ptr.(string), c.Argv[2].Ptr.(string)))
+}
+
+// PubsubCommand ...
+// PUBSUB CHANNELS [pattern]
+// PUBSUB NUMSUB [channel [channel ...]]
+// PUBSUB NUMPAT
+func PubsubCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'pubsub' command")
+		return
+	}
+
+	sub := strings.ToLower(c.Argv[1].Ptr.(string))
+	switch {
+	case sub == "channels" && c.Argc <= 3:
+		channels := make([]string, 0)
+		for channel := range s.pubsubChannels {
+			if c.Argc == 2 || util.StringMatch(c.Argv[2].Ptr.(string), channel, false) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+		array := make([]*EncodeData, 0, len(channels))
+		for _, channel := range channels {
+			array = append(array, NewBulk([]byte(channel)))
+		}
+		addReplyArray(c, array)
+	case sub == "numsub":
+		array := make([]*EncodeData, 0, (c.Argc-2)*2)
+		for i := 2; i < c.Argc; i++ {
+			channel := c.Argv[i].Ptr.(string)
+			array = append(array, NewBulk([]byte(channel)), NewInt([]byte(strconv.Itoa(len(s.pubsubChannels[channel])))))
+		}
+		addReplyArray(c, array)
+	case sub == "numpat" && c.Argc == 2:
+		addReplyInt(c, int64(len(s.pubsubPatterns)))
+	default:
+		addReplyError(c, "ERR unknown subcommand or wrong number of arguments for '"+c.Argv[1].Ptr.(string)+
+			"'. Try PUBSUB HELP.")
+	}
+}
+
+// isClosing return true if client is closed by server
+func (s *Server) isClosing(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.closing
+}
+
+// isSubscribed return true if client is in subscribe mode
+func (s *Server) isSubscribed(c *Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.subscriptions() > 0
+}
+
+// waitPushed writes the messages pushed to the subscribed client until it sends the next command,
+// an error is returned if the connection is closed
+func (s *Server) waitPushed(c *Client) error {
+	// the content read is kept in decoder for the next command
+	received := make(chan error, 1)
+	go func() {
+		_, err := c.decoder.ByteReader.GlanceByte()
+		received <- err
+	}()
+
+	for {
+		select {
+		case <-c.pushed:
+			if err := s.writeReply(c); err != nil {
+				return err
+			}
+		case err := <-received:
+			return err
+		}
+	}
+}
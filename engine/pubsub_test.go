@@ -0,0 +1,197 @@
+package godis
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPubsubCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	sub := s.CreateClient(nil)
+	psub := s.CreateClient(nil)
+	for _, tc := range []struct {
+		c      *Client
+		cmd    string
+		expect string
+	}{
+		{sub, "subscribe news sport news", "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n" +
+			"*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:2\r\n"},
+		{sub, "get k", "-ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE are allowed in this context\r\n"},
+		{psub, "psubscribe n*", "*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:1\r\n"},
+		{c, "publish news hello", ":2\r\n"},
+		{sub, "", "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"},
+		{psub, "", "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n"},
+		{c, "publish sport goal", ":1\r\n"},
+		{sub, "", "*3\r\n$7\r\nmessage\r\n$5\r\nsport\r\n$4\r\ngoal\r\n"},
+		{psub, "", ""},
+		{c, "publish other x", ":0\r\n"},
+		{c, "publish news", "-ERR wrong number of arguments for 'publish' command\r\n"},
+		{c, "subscribe", "-ERR wrong number of arguments for 'subscribe' command\r\n"},
+
+		{c, "pubsub channels", "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n"},
+		{c, "pubsub channels s*", "*1\r\n$5\r\nsport\r\n"},
+		{c, "pubsub numsub news nope", "*4\r\n$4\r\nnews\r\n:1\r\n$4\r\nnope\r\n:0\r\n"},
+		{c, "pubsub numpat", ":1\r\n"},
+		{c, "pubsub help me", "-ERR unknown subcommand or wrong number of arguments for 'help'. Try PUBSUB HELP.\r\n"},
+
+		{sub, "unsubscribe sport nope", "*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:1\r\n*3\r\n$11\r\nunsubscribe\r\n$4\r\nnope\r\n:1\r\n"},
+		{sub, "unsubscribe", "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:0\r\n"},
+		{sub, "unsubscribe", "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n"},
+		{sub, "get k", "$-1\r\n"},
+		{psub, "punsubscribe", "*3\r\n$12\r\npunsubscribe\r\n$2\r\nn*\r\n:0\r\n"},
+		{c, "pubsub channels", "*0\r\n"},
+		{c, "pubsub numpat", ":0\r\n"},
+	} {
+		if tc.cmd == "" {
+			// check the messages pushed to the client
+			if r := *tc.c.Buf.SdsGetString(); r != tc.expect {
+				t.Fatalf("subscribed client receives %q, expect %q", r, tc.expect)
+			}
+			tc.c.Buf.SdsClear()
+			continue
+		}
+		if r := execCommand(s, tc.c, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+		if tc.c != c {
+			tc.c.Buf.SdsClear()
+		}
+	}
+}
+
+func TestPubsubConnection(t *testing.T) {
+	s, addr, stop := newTestServer(t)
+	defer stop()
+
+	subscriber := dialTestServer(t, addr)
+	defer subscriber.conn.Close()
+	if err := subscriber.send([]string{"subscribe", "ch"}, []string{"psubscribe", "c?"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if r, err := subscriber.decoder.Decode(); err != nil || len(r.Array) != 3 || string(r.Array[2].Value) != strconv.Itoa(i+1) {
+			t.Fatalf("subscribing replies %+v, err:%v", r, err)
+		}
+	}
+
+	// messages are pushed while the subscriber is waiting for its next command
+	publisher := dialTestServer(t, addr)
+	defer publisher.conn.Close()
+	if r, err := publisher.do("publish", "ch", "hi"); err != nil || string(r.Value) != "2" {
+		t.Fatalf("publish replies %+v, err:%v", r, err)
+	}
+	for _, expect := range []string{"message ch hi", "pmessage c? ch hi"} {
+		r, err := subscriber.decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := make([]string, 0, len(r.Array))
+		for _, e := range r.Array {
+			fields = append(fields, string(e.Value))
+		}
+		if strings.Join(fields, " ") != expect {
+			t.Fatalf("subscriber receives %v, expect %s", fields, expect)
+		}
+	}
+
+	if r, err := subscriber.do("set", "k", "v"); err != nil || r.Type != TypeError {
+		t.Fatalf("set replies %+v in subscribe mode, err:%v", r, err)
+	}
+
+	// the subscriptions of the closed connection are removed
+	subscriber.conn.Close()
+	for i := 0; ; i++ {
+		r, err := publisher.do("publish", "ch", "bye")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Value) == "0" {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("publish replies %+v after the subscriber is closed", r)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r, err := publisher.do("pubsub", "numpat"); err != nil || string(r.Value) != "0" {
+		t.Fatalf("pubsub numpat replies %+v, err:%v", r, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pubsubChannels) != 0 {
+		t.Fatalf("channels %v are still subscribed", s.pubsubChannels)
+	}
+}
+
+func TestPubsubOutputLimit(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+	s.PubsubOutputLimit = 100
+
+	c := s.CreateClient(nil)
+	sub := s.CreateClient(nil)
+	execCommand(s, sub, "subscribe", "ch")
+	sub.Buf.SdsClear()
+
+	message := strings.Repeat("x", 40)
+	if r := execCommand(s, c, "publish", "ch", message); r != ":1\r\n" || sub.closing {
+		t.Fatalf("publish replies %q, subscriber closing:%v", r, sub.closing)
+	}
+	// the second message makes the buffer exceed the limit
+	execCommand(s, c, "publish", "ch", message)
+	if !sub.closing || sub.Buf.SdsLen() != 0 {
+		t.Fatalf("subscriber closing:%v with %d bytes in buffer", sub.closing, sub.Buf.SdsLen())
+	}
+	execCommand(s, c, "publish", "ch", message)
+	if sub.Buf.SdsLen() != 0 {
+		t.Fatalf("message is pushed to the closing subscriber: %q", *sub.Buf.SdsGetString())
+	}
+}
+
+func TestPubsubSlowSubscriber(t *testing.T) {
+	s, addr, stop := newTestServer(t)
+	defer stop()
+	s.mu.Lock()
+	s.PubsubOutputLimit = 64 * 1024
+	s.mu.Unlock()
+
+	// the subscriber never reads the messages
+	subscriber := dialTestServer(t, addr)
+	defer subscriber.conn.Close()
+	if r, err := subscriber.do("subscribe", "ch"); err != nil || len(r.Array) != 3 {
+		t.Fatalf("subscribe replies %+v, err:%v", r, err)
+	}
+
+	publisher := dialTestServer(t, addr)
+	defer publisher.conn.Close()
+	message := strings.Repeat("x", 16*1024)
+	for i := 0; ; i++ {
+		r, err := publisher.do("publish", "ch", message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Value) == "0" {
+			break
+		}
+		if i == 100000 {
+			t.Fatal("slow subscriber isn't disconnected")
+		}
+	}
+
+	// the messages written before are received and then the connection is closed
+	subscriber.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := subscriber.decoder.Decode(); err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				t.Fatal("connection of the slow subscriber isn't closed")
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package godis
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nk-akun/godis/engine/util"
+)
+
+const (
+	SCAN_DEFAULT_COUNT = 10
+)
+
+// parseScanCursorOrReply parse o as the cursor of scan, reply error to client if it's invalid
+func parseScanCursorOrReply(c *Client, o *Object) (uint64, bool) {
+	cursor, err := strconv.ParseUint(o.Ptr.(string), 10, 64)
+	if err != nil {
+		addReplyError(c, "ERR invalid cursor")
+		return 0, false
+	}
+	return cursor, true
+}
+
+// addReplyScan replies the next cursor and elements
+func addReplyScan(c *Client, cursor uint64, elements []*EncodeData) {
+	addReplyArray(c, []*EncodeData{
+		NewBulk([]byte(strconv.FormatUint(cursor, 10))),
+		NewMultiBulk(elements),
+	})
+}
+
+// scanGenericCommand implements SCAN if o is nil, otherwise the scan commands of hash and so on,
+// options of the command start from argv[optIndex]
+func scanGenericCommand(c *Client, o *Object, cursor uint64, optIndex int) {
+	count := int64(SCAN_DEFAULT_COUNT)
+	pattern := ""
+	typeName := ""
+	for i := optIndex; i < c.Argc; i += 2 {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		if i+1 >= c.Argc {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		switch opt {
+		case "count":
+			var ok bool
+			if count, ok = getInt64FromObjectOrReply(c, c.Argv[i+1]); !ok {
+				return
+			}
+			if count < 1 {
+				addReplyError(c, "ERR syntax error")
+				return
+			}
+		case "match":
+			pattern = c.Argv[i+1].Ptr.(string)
+		case "type":
+			if o != nil {
+				addReplyError(c, "ERR syntax error")
+				return
+			}
+			typeName = strings.ToLower(c.Argv[i+1].Ptr.(string))
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	// keys[i] is the element to match and values[i] is the value replied after it if needed
+	keys := make([]*Sdshdr, 0)
+	values := make([]*Sdshdr, 0)
+
+	switch {
+	case o == nil:
+		cursor = scanDict(c.Db.Dt, cursor, count, func(node *DictNode) {
+			if typeName != "" && objectTypeName(node.value) != typeName {
+				return
+			}
+			if c.Db.isExpired(node.key) {
+				return
+			}
+			keys = append(keys, SdsNewString(node.key.Ptr.(string)))
+		})
+	case o.ObjectType == OBJHash:
+		h := o.Ptr.(*HashMap)
+		if h.IsCompact() {
+			// a compact hash map is small enough to be replied at once
+			h.ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+				keys = append(keys, field)
+				values = append(values, value)
+				return true
+			})
+			cursor = 0
+		} else {
+			cursor = scanDict(h.dt, cursor, count, func(node *DictNode) {
+				keys = append(keys, node.key.Ptr.(*Sdshdr))
+				values = append(values, node.value.Ptr.(*Sdshdr))
+			})
+		}
+	case o.ObjectType == OBJSet:
+		set := o.Ptr.(*Set)
+		if set.IsIntSet() {
+			set.ForEach(func(member *Sdshdr) bool {
+				keys = append(keys, member)
+				return true
+			})
+			cursor = 0
+		} else {
+			cursor = scanDict(set.dt, cursor, count, func(node *DictNode) {
+				keys = append(keys, node.key.Ptr.(*Sdshdr))
+			})
+		}
+	case o.ObjectType == OBJZset:
+		cursor = scanDict(o.Ptr.(*ZskipList).dt, cursor, count, func(node *DictNode) {
+			keys = append(keys, node.key.Ptr.(*Sdshdr))
+			values = append(values, SdsNewString(formatFloat(node.value.Ptr.(*ZskipListNode).score)))
+		})
+	}
+
+	elements := make([]*EncodeData, 0, len(keys)+len(values))
+	for i, key := range keys {
+		if pattern != "" && !util.StringMatch(pattern, *key.SdsGetString(), false) {
+			continue
+		}
+		elements = append(elements, NewBulk(key.SdsGetBuf()))
+		if len(values) > 0 {
+			elements = append(elements, NewBulk(values[i].SdsGetBuf()))
+		}
+	}
+	addReplyScan(c, cursor, elements)
+}
+
+// scanDict scans dt from cursor until about count nodes are visited or the scan is finished,
+// it return the next cursor
+func scanDict(dt *Dict, cursor uint64, count int64, fn func(node *DictNode)) uint64 {
+	visited := int64(0)
+	// the limit avoids blocking too long when there are many empty buckets
+	maxIterations := count * 10
+	for {
+		cursor = dt.Scan(cursor, func(node *DictNode) {
+			visited++
+			fn(node)
+		})
+		maxIterations--
+		if cursor == 0 || visited >= count || maxIterations <= 0 {
+			return cursor
+		}
+	}
+}
+
+// ScanCommand ...
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func ScanCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'scan' command")
+		return
+	}
+	cursor, ok := parseScanCursorOrReply(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	scanGenericCommand(c, nil, cursor, 2)
+}
@@ -0,0 +1,89 @@
+package godis
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	// the expired key is skipped although it isn't deleted yet
+	execCommand(s, c, "set", "gone", "v", "px", "1")
+	time.Sleep(2 * time.Millisecond)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"set a 1", "+OK\r\n"},
+		{"set ab 2", "+OK\r\n"},
+		{"hset h f v", ":1\r\n"},
+		{"zadd z 1.5 m 2 n", ":2\r\n"},
+		{"scan 0 count 100", "*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\nz\r\n$1\r\nh\r\n$2\r\nab\r\n"},
+		{"scan 0 count 100 match a*", "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$2\r\nab\r\n"},
+		{"scan 0 count 100 type zset", "*2\r\n$1\r\n0\r\n*1\r\n$1\r\nz\r\n"},
+		{"scan 0 count 100 type list", "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{"scan x", "-ERR invalid cursor\r\n"},
+		{"scan 0 count 0", "-ERR syntax error\r\n"},
+		{"scan 0 count", "-ERR syntax error\r\n"},
+		{"scan 0 limit 1", "-ERR syntax error\r\n"},
+		{"scan", "-ERR wrong number of arguments for 'scan' command\r\n"},
+
+		{"zscan z 0", "*2\r\n$1\r\n0\r\n*4\r\n$1\r\nn\r\n$1\r\n2\r\n$1\r\nm\r\n$3\r\n1.5\r\n"},
+		{"zscan z 0 match n", "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nn\r\n$1\r\n2\r\n"},
+		{"zscan z 0 type zset", "-ERR syntax error\r\n"},
+		{"zscan nope 0", "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{"zscan a 0", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"zscan z", "-ERR wrong number of arguments for 'zscan' command\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+func TestScanLargeKeyspace(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	const keys = 1000
+	for i := 0; i < keys; i++ {
+		execCommand(s, c, "set", "k"+strconv.Itoa(i), "v")
+	}
+
+	// every key is returned although the keyspace keeps growing during the scan
+	seen := make(map[string]bool)
+	cursor, added := "0", 0
+	for calls := 0; ; calls++ {
+		if calls > keys {
+			t.Fatal("scan doesn't finish")
+		}
+		r, err := DecodeFromBytes([]byte(execCommand(s, c, "scan", cursor, "count", "20", "match", "k*")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor = string(r.Array[0].Value)
+		for _, key := range r.Array[1].Array {
+			seen[string(key.Value)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+		execCommand(s, c, "set", "added"+strconv.Itoa(added), "v")
+		added++
+	}
+	for i := 0; i < keys; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Fatalf("k%d isn't returned", i)
+		}
+	}
+	if len(seen) != keys {
+		t.Fatalf("scan returns %d keys matching k*, expect %d", len(seen), keys)
+	}
+}
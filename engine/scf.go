@@ -1,46 +1,525 @@
 package godis
 
 import (
-	"bytes"
-	"io/ioutil"
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
 	"os"
-	"syscall"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// AppendToSCF ...
-func AppendToSCF(fileName string, content string) error {
-	f, err := os.OpenFile(fileName, os.O_WRONLY|syscall.O_CREAT, 0644)
-	if err != nil {
-		log.Errorf("scf file open %s failed", fileName+err.Error())
-	} else {
-		n, _ := f.Seek(0, os.SEEK_END)
-		_, err = f.WriteAt([]byte(content), n)
+const (
+	SCF_REWRITE_ITEMS_PER_CMD = 64
+	SCF_REWRITE_PERC          = 100
+	SCF_REWRITE_MIN_SIZE      = 64 * 1024 * 1024
+)
+
+const (
+	SCF_FSYNC_NO       = 0
+	SCF_FSYNC_ALWAYS   = 1
+	SCF_FSYNC_EVERYSEC = 2
+)
+
+// parseSCFFsync return the fsync policy named policy, everysec is the default one
+func parseSCFFsync(policy string) int {
+	switch strings.ToLower(policy) {
+	case "no":
+		return SCF_FSYNC_NO
+	case "always":
+		return SCF_FSYNC_ALWAYS
 	}
-	defer f.Close()
-	return err
+	return SCF_FSYNC_EVERYSEC
 }
 
-// ReadSCF ...
-func ReadSCF(fileName string) []string {
-	f, err := os.Open(fileName)
+// catCommandArgv encodes argv into multibulk which is the format of command in scf
+func catCommandArgv(argv []*Object) []byte {
+	r := NewMultiBulk(make([]*EncodeData, 0, len(argv)))
+	for _, arg := range argv {
+		r.Array = append(r.Array, NewBulk([]byte(arg.Ptr.(string))))
+	}
+	b, err := EncodeMultiBulk(r)
 	if err != nil {
-		log.Errorf("open scf file failed")
+		log.Errorf("encode command error:%v", err)
+	}
+	return b
+}
+
+// scfRecord return the record of cmd stored in scf,
+// the crc32 of cmd follows it as an integer if checksum is true
+func scfRecord(cmd []byte, checksum bool) []byte {
+	if !checksum {
+		return cmd
+	}
+	return append(cmd, fmt.Sprintf(":%d\r\n", crc32.ChecksumIEEE(cmd))...)
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// loadSCF replays the records in scf one by one from offset. A truncated record at the end of scf
+// is discarded if SCFLoadTruncated is true, otherwise an error is returned like other bad records
+func (s *Server) loadSCF(offset int64) error {
+	f, err := os.Open(s.SCFFileName)
+	if os.IsNotExist(err) {
 		return nil
+	} else if err != nil {
+		return err
 	}
 	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	c := s.CreateClient(nil)
+	c.VirtualFlag = true
+	cr := &countingReader{r: f, n: offset}
+	decoder := NewDecoder(cr)
+	// the latest command is replayed once it's known whether a checksum follows it
+	var pending *EncodeData
+	for {
+		offset := cr.n - int64(decoder.ByteReader.Buffered())
+		r, err := decoder.Decode()
+		if err == io.EOF && offset == info.Size() {
+			break
+		} else if err == io.EOF {
+			if !s.SCFLoadTruncated {
+				return fmt.Errorf("scf is truncated at offset %d", offset)
+			}
+			log.Warnf("scf is truncated at offset %d, the incomplete record is discarded", offset)
+			if err := os.Truncate(s.SCFFileName, offset); err != nil {
+				return err
+			}
+			break
+		} else if err != nil {
+			return fmt.Errorf("bad record at offset %d of scf:%v", offset, err)
+		}
+
+		switch {
+		case r.Type == TypeInt && pending != nil:
+			cmd, _ := EncodeMultiBulk(pending)
+			if string(r.Value) != strconv.FormatUint(uint64(crc32.ChecksumIEEE(cmd)), 10) {
+				return fmt.Errorf("checksum mismatch of the record before offset %d of scf", offset)
+			}
+			if err := replaySCFCommand(s, c, pending); err != nil {
+				return err
+			}
+			pending = nil
+		case r.Type == TypeMultiBulk && isCommand(r):
+			if pending != nil {
+				if err := replaySCFCommand(s, c, pending); err != nil {
+					return err
+				}
+			}
+			pending = r
+		default:
+			return fmt.Errorf("bad record at offset %d of scf", offset)
+		}
+	}
+	if pending != nil {
+		return replaySCFCommand(s, c, pending)
+	}
+	return nil
+}
+
+// isCommand return true if r is a non-empty array of bulks
+func isCommand(r *EncodeData) bool {
+	for _, bulk := range r.Array {
+		if bulk.Type != TypeBulk || bulk.Value == nil {
+			return false
+		}
+	}
+	return len(r.Array) > 0
+}
+
+// replaySCFCommand processes the command r read from scf, an error is returned if it selects a db
+// which isn't configured, since the commands after it can't be replayed into the right db
+func replaySCFCommand(s *Server, c *Client, r *EncodeData) error {
+	c.setArgv(r.Array)
+	if c.Argc == 2 && strings.ToLower(c.Argv[0].Ptr.(string)) == "select" {
+		id, err := strconv.Atoi(c.Argv[1].Ptr.(string))
+		if err != nil || id < 0 || id >= len(s.Db) {
+			return fmt.Errorf("scf selects db %s but only %d databases are configured", c.Argv[1].Ptr.(string), len(s.Db))
+		}
+	}
+	c.Buf = SdsNewEmpty()
+	s.ProcessCommand(c)
+	return nil
+}
+
+// feedSCF appends cmd executed in db dbID to scf, a SELECT is inserted before it if the db changes.
+// The records are also kept in the rewrite buffer during a rewrite so that they could be appended to the new scf
+func (s *Server) feedSCF(dbID int, cmd []byte) {
+	if s.scfFile == nil {
+		f, err := os.OpenFile(s.SCFFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Errorf("open scf %s error:%v", s.SCFFileName, err)
+			return
+		}
+		s.scfFile = f
+	}
+
+	var record []byte
+	if dbID != s.scfSelectedDb {
+		selectCmd := catCommandArgv([]*Object{NewObject(OBJString, "select"), NewObject(OBJString, strconv.Itoa(dbID))})
+		record = scfRecord(selectCmd, s.SCFChecksum)
+	}
+	record = append(record, scfRecord(cmd, s.SCFChecksum)...)
+	if _, err := s.scfFile.Write(record); err != nil {
+		log.Errorf("append to scf error:%v", err)
+		return
+	}
+	s.scfSelectedDb = dbID
+	s.scfCurrentSize += int64(len(record))
+	s.scfCRC = crc64.Update(s.scfCRC, crc64Table, record)
+	if s.SCFFsync == SCF_FSYNC_ALWAYS {
+		if err := s.scfFile.Sync(); err != nil {
+			log.Errorf("fsync scf error:%v", err)
+		}
+	}
+	if s.scfRewriting {
+		s.scfRewriteBuf = append(s.scfRewriteBuf, record...)
+	}
+}
+
+// propagateCommand feeds the command of client into scf, or the commands added by alsoPropagate instead
+func (s *Server) propagateCommand(dbID int, c *Client) {
+	if c.propagate == nil {
+		s.feedSCF(dbID, catCommandArgv(c.Argv))
+		return
+	}
+	for _, args := range c.propagate {
+		argv := make([]*Object, len(args))
+		for i, arg := range args {
+			argv[i] = NewObject(OBJString, arg)
+		}
+		s.feedSCF(dbID, catCommandArgv(argv))
+	}
+	c.propagate = nil
+}
+
+// fsyncSCFIfNeeded flushes scf to disk in background if it has been written
+// and the latest fsync is at least one second ago
+func (s *Server) fsyncSCFIfNeeded() {
+	if s.SCFFsync != SCF_FSYNC_EVERYSEC || s.scfFile == nil || s.scfSyncedSize == s.scfCurrentSize {
+		return
+	}
+	if time.Since(s.scfLastFsync) < time.Second {
+		return
+	}
+	// skip if the previous fsync hasn't finished
+	if !atomic.CompareAndSwapInt32(&s.scfFsyncing, 0, 1) {
+		return
+	}
+	s.scfLastFsync = time.Now()
+	s.scfSyncedSize = s.scfCurrentSize
+	go func(f *os.File) {
+		defer atomic.StoreInt32(&s.scfFsyncing, 0)
+		// f may be closed after it's replaced by a rewritten scf which has been synced
+		if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			log.Errorf("fsync scf error:%v", err)
+		}
+	}(s.scfFile)
+}
+
+// BgRewriteSCFCommand ...
+func BgRewriteSCFCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'bgrewritescf' command")
+		return
+	}
+	if s.scfRewriting {
+		addReplyError(c, "ERR Background SCF rewriting already in progress")
+		return
+	}
+	s.startRewriteSCF()
+	addReplyStatus(c, "Background SCF rewriting started")
+}
 
-	content, err := ioutil.ReadFile(fileName)
+// rewriteSCFIfNeeded starts a rewrite when scf has grown by SCFRewritePerc percent since the last rewrite
+func (s *Server) rewriteSCFIfNeeded() {
+	if s.scfRewriting || s.SCFRewritePerc <= 0 || s.scfCurrentSize < s.SCFRewriteMinSize {
+		return
+	}
+	base := s.scfRewriteBaseSize
+	if base == 0 {
+		base = 1
+	}
+	growth := (s.scfCurrentSize - base) * 100 / base
+	if growth >= int64(s.SCFRewritePerc) {
+		log.Infof("starting automatic rewriting of scf on %d%% growth", growth)
+		s.startRewriteSCF()
+	}
+}
+
+// startRewriteSCF starts the snapshots of all the dbs, they are copied and the commands rebuilding
+// them are written into a new scf in background, it must be called while holding s.mu
+func (s *Server) startRewriteSCF() {
+	snaps, _ := s.startSnapshots()
+	s.scfRewriting = true
+	s.scfRewriteBuf = nil
+	go s.rewriteSCF(snaps, s.SCFChecksum)
+}
+
+func (s *Server) rewriteSCF(snaps []*dbSnapshot, checksum bool) {
+	tmpFile := filepath.Join(filepath.Dir(s.SCFFileName), fmt.Sprintf("temp-rewrite-%d.scf", os.Getpid()))
+	crc, err := writeRewriteSCF(tmpFile, s.copySnapshots(snaps), checksum)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		err = s.finishRewriteSCF(tmpFile, crc)
+	}
+	s.scfRewriting = false
+	s.scfRewriteBuf = nil
 	if err != nil {
-		log.Errorf("read scf file error")
-		return nil
+		log.Errorf("rewrite scf error:%v", err)
+		os.Remove(tmpFile)
+		return
+	}
+	log.Infof("background scf rewriting finished, size:%d", s.scfCurrentSize)
+}
+
+// finishRewriteSCF appends the commands processed during the rewrite to the new scf,
+// and then replaces the old scf with it
+// crc is the crc64 of the content of tmpFile
+func (s *Server) finishRewriteSCF(tmpFile string, crc uint64) error {
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
 	}
+	if _, err = f.Write(s.scfRewriteBuf); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpFile, s.SCFFileName)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = f.Stat()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	// the following commands are appended to the new scf
+	if s.scfFile != nil {
+		s.scfFile.Close()
+	}
+	s.scfFile = f
+	s.scfCurrentSize = info.Size()
+	s.scfSyncedSize = info.Size()
+	s.scfRewriteBaseSize = info.Size()
+	s.scfCRC = crc64.Update(crc, crc64Table, s.scfRewriteBuf)
+	return nil
+}
 
-	lines := bytes.Split(content, []byte{'*'})
-	cmds := make([]string, len(lines)-1)
+// writeRewriteSCF writes the minimal commands rebuilding dbs into fileName,
+// it return the crc64 of the content
+func writeRewriteSCF(fileName string, dbs [][]*dbEntry, checksum bool) (uint64, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
 
-	for k, v := range lines[1:] {
-		v := append(v[:0], append([]byte{'*'}, v[0:]...)...)
-		cmds[k] = string(v)
+	bw := bufio.NewWriter(f)
+	h := crc64.New(crc64Table)
+	w := &scfWriter{w: io.MultiWriter(bw, h), checksum: checksum}
+	for id, entries := range dbs {
+		if len(entries) == 0 {
+			continue
+		}
+		if err = w.writeCommand([]byte("select"), []byte(strconv.Itoa(id))); err != nil {
+			return 0, err
+		}
+		for _, e := range entries {
+			if err = w.rewriteObject(e.key, e.value); err != nil {
+				return 0, err
+			}
+			if e.expire != -1 {
+				err = w.writeCommand([]byte("pexpireat"), []byte(e.key), []byte(strconv.FormatInt(e.expire, 10)))
+				if err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), f.Sync()
+}
+
+// scfWriter writes commands into w in the format of scf
+type scfWriter struct {
+	w        io.Writer
+	checksum bool
+}
+
+// rewriteObject writes the commands creating key whose value is o
+func (w *scfWriter) rewriteObject(key string, o *Object) error {
+	items := make([][]byte, 0)
+	switch o.ObjectType {
+	case OBJSDS:
+		return w.writeCommand([]byte("set"), []byte(key), o.Ptr.(*Sdshdr).SdsGetBuf())
+	case OBJList:
+		iter := o.Ptr.(*List).RewindHead()
+		for node := iter.NextNode(); node != nil; node = iter.NextNode() {
+			items = append(items, []byte(node.Value().Ptr.(string)))
+		}
+		return w.rewriteItems("rpush", key, items, 1)
+	case OBJZset:
+		for node := o.Ptr.(*ZskipList).header.level[0].forward; node != nil; node = node.level[0].forward {
+			items = append(items, []byte(formatFloat(node.score)), node.value.SdsGetBuf())
+		}
+		return w.rewriteItems("zadd", key, items, 2)
+	case OBJHash:
+		o.Ptr.(*HashMap).ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+			items = append(items, field.SdsGetBuf(), value.SdsGetBuf())
+			return true
+		})
+		return w.rewriteItems("hset", key, items, 2)
+	case OBJSet:
+		o.Ptr.(*Set).ForEach(func(member *Sdshdr) bool {
+			items = append(items, member.SdsGetBuf())
+			return true
+		})
+		return w.rewriteItems("sadd", key, items, 1)
+	case OBJStream:
+		return w.rewriteStream(key, o.Ptr.(*Stream))
+	}
+	return fmt.Errorf("unknown type %d of key %s", o.ObjectType, key)
+}
+
+// rewriteStream writes the entries of stream with XADD. The last id is restored by adding
+// an entry of it and deleting it at once if the entry isn't there, an empty stream without
+// any id is created by a group created with MKSTREAM and destroyed at once. And then the
+// groups are created and the pending entries are claimed by their consumers
+func (w *scfWriter) rewriteStream(key string, st *Stream) error {
+	k := []byte(key)
+	if len(st.entries) == 0 && st.lastID == (streamID{}) {
+		name := "scf-rewrite"
+		for st.groups[name] != nil {
+			name += "_"
+		}
+		err := w.writeCommand([]byte("xgroup"), []byte("create"), k, []byte(name), []byte("0"), []byte("mkstream"))
+		if err != nil {
+			return err
+		}
+		if err := w.writeCommand([]byte("xgroup"), []byte("destroy"), k, []byte(name)); err != nil {
+			return err
+		}
+	}
+	for _, entry := range st.entries {
+		args := [][]byte{[]byte("xadd"), k, []byte(entry.id.String())}
+		for _, field := range entry.fields {
+			args = append(args, []byte(field))
+		}
+		if err := w.writeCommand(args...); err != nil {
+			return err
+		}
+	}
+	if n := len(st.entries); st.lastID != (streamID{}) && (n == 0 || st.entries[n-1].id != st.lastID) {
+		id := []byte(st.lastID.String())
+		if err := w.writeCommand([]byte("xadd"), k, id, []byte("x"), []byte("y")); err != nil {
+			return err
+		}
+		if err := w.writeCommand([]byte("xdel"), k, id); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range st.sortedGroupNames() {
+		cg := st.groups[name]
+		if err := w.writeCommand([]byte("xgroup"), []byte("create"), k, []byte(name), []byte(cg.lastID.String())); err != nil {
+			return err
+		}
+		for consumer := range cg.consumers {
+			err := w.writeCommand([]byte("xgroup"), []byte("createconsumer"), k, []byte(name), []byte(consumer))
+			if err != nil {
+				return err
+			}
+		}
+		for _, nack := range cg.pel {
+			argv := streamClaimArgv(key, name, nack)
+			args := make([][]byte, 0, len(argv))
+			for _, arg := range argv {
+				args = append(args, []byte(arg))
+			}
+			if err := w.writeCommand(args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rewriteItems writes cmd with at most SCF_REWRITE_ITEMS_PER_CMD items in every command,
+// each item takes itemLen arguments
+func (w *scfWriter) rewriteItems(cmd string, key string, items [][]byte, itemLen int) error {
+	batch := SCF_REWRITE_ITEMS_PER_CMD * itemLen
+	for start := 0; start < len(items); start += batch {
+		end := start + batch
+		if end > len(items) {
+			end = len(items)
+		}
+		args := append([][]byte{[]byte(cmd), []byte(key)}, items[start:end]...)
+		if err := w.writeCommand(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCommand encodes args into multibulk and writes it into w
+func (w *scfWriter) writeCommand(args ...[]byte) error {
+	r := NewMultiBulk(make([]*EncodeData, 0, len(args)))
+	for _, arg := range args {
+		r.Array = append(r.Array, NewBulk(arg))
+	}
+	b, err := EncodeMultiBulk(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(scfRecord(b, w.checksum))
+	return err
+}
+
+// scfPrefixCRC return the crc64 of the first n bytes of scf
+func scfPrefixCRC(fileName string, n int64) (uint64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc64.New(crc64Table)
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return 0, err
 	}
-	return cmds
+	return h.Sum64(), nil
 }
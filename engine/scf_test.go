@@ -0,0 +1,275 @@
+package godis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// execCommand process args with client c and return the reply
+func execCommand(s *Server, c *Client, args ...string) string {
+	c.Argc = len(args)
+	c.Argv = make([]*Object, c.Argc)
+	for i, arg := range args {
+		c.Argv[i] = NewObject(OBJString, arg)
+	}
+	c.Buf = SdsNewEmpty()
+	s.ProcessCommand(c)
+	return *c.Buf.SdsGetString()
+}
+
+func newSCFTestServer(t *testing.T) (*Server, func()) {
+	log = zap.NewNop().Sugar()
+	dir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(&GodisConfig{
+		SCFFileName:      filepath.Join(dir, "test.scf"),
+		SnapshotFileName: filepath.Join(dir, "test.gdb"),
+	})
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// reloadServer create a server loading the files of s
+func reloadServer(s *Server, loadTruncated bool) (*Server, error) {
+	loaded := NewServer(&GodisConfig{
+		SCFFileName:      s.SCFFileName,
+		SnapshotFileName: s.SnapshotFileName,
+		SCFLoadTruncated: loadTruncated,
+	})
+	return loaded, LoadData(loaded)
+}
+
+func waitRewriteSCF(t *testing.T, s *Server) {
+	for i := 0; i < 500; i++ {
+		s.mu.Lock()
+		rewriting := s.scfRewriting
+		s.mu.Unlock()
+		if !rewriting {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("scf rewriting doesn't finish")
+}
+
+func TestRewriteSCF(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for i := 0; i < 100; i++ {
+		execCommand(s, c, "set", "str", strconv.Itoa(i))
+		execCommand(s, c, "rpush", "list", "v"+strconv.Itoa(i))
+		execCommand(s, c, "zadd", "zset", strconv.Itoa(i%7)+".5", "m"+strconv.Itoa(i))
+		execCommand(s, c, "hset", "hash", "f"+strconv.Itoa(i), "v")
+		execCommand(s, c, "sadd", "set", strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	execCommand(s, c, "set", "ttl", "v", "px", "100000")
+	execCommand(s, c, "set", "expired", "v", "px", "1")
+	time.Sleep(2 * time.Millisecond)
+	before := s.scfCurrentSize
+
+	// the command processed after the copy is taken is appended from the rewrite buffer
+	s.mu.Lock()
+	s.startRewriteSCF()
+	c.Argc, c.Argv = 3, []*Object{NewObject(OBJString, "rpush"), NewObject(OBJString, "list"), NewObject(OBJString, "last")}
+	c.Command = s.LookUpCommand("rpush")
+	process(c, s)
+	s.mu.Unlock()
+	waitRewriteSCF(t, s)
+
+	if s.scfCurrentSize >= before {
+		t.Fatalf("size of scf is %d after rewriting, %d before", s.scfCurrentSize, before)
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, cmd := range [][]string{
+		{"get", "str"},
+		{"get", "expired"},
+		{"lrange", "list", "0", "-1"},
+		{"zrange", "zset", "0", "-1", "withscores"},
+		{"hgetall", "hash"},
+		{"scard", "set"},
+		{"sismember", "set", "m99"},
+	} {
+		if expect, r := execCommand(s, c, cmd...), execCommand(loaded, lc, cmd...); r != expect {
+			t.Fatalf("%v replies %q after loading, expect %q", cmd, r, expect)
+		}
+	}
+	if ttl := loaded.Db[0].GetExpire(NewObject(OBJString, "ttl")); ttl != s.Db[0].GetExpire(NewObject(OBJString, "ttl")) {
+		t.Fatalf("wrong timeout %d after loading", ttl)
+	}
+}
+
+func TestRewriteSCFAutomatically(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+	s.SCFRewritePerc = 100
+	s.SCFRewriteMinSize = 1024
+
+	c := s.CreateClient(nil)
+	for i := 0; i < 100 && s.scfRewriteBaseSize == 0; i++ {
+		execCommand(s, c, "set", "k", strconv.Itoa(i))
+		s.mu.Lock()
+		s.rewriteSCFIfNeeded()
+		s.mu.Unlock()
+		waitRewriteSCF(t, s)
+	}
+	if s.scfRewriteBaseSize == 0 || s.scfCurrentSize >= 1024 {
+		t.Fatalf("scf isn't rewritten, size:%d", s.scfCurrentSize)
+	}
+}
+
+// TestRewriteSCFLockHold checks that BGREWRITESCF doesn't hold the lock for the whole copy of
+// a large keyspace, and the commands processed during the copy are kept after the rewrite
+func TestRewriteSCFLockHold(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	const keys = 200000
+	for i := 0; i < keys; i++ {
+		s.Db[0].Add(NewObject(OBJString, "k"+strconv.Itoa(i)), NewObject(OBJSDS, SdsNewString("v")))
+	}
+
+	// the whole copy under the lock, as SAVE does
+	start := time.Now()
+	s.mu.Lock()
+	s.snapshotDatabases()
+	s.mu.Unlock()
+	copied := time.Since(start)
+
+	start = time.Now()
+	if r := execCommand(s, c, "bgrewritescf"); r != "+Background SCF rewriting started\r\n" {
+		t.Fatalf("bgrewritescf replies %q", r)
+	}
+	rewrite := time.Since(start)
+	for i := 0; i < keys; i += 1000 {
+		execCommand(s, c, "set", "k"+strconv.Itoa(i), "changed")
+		execCommand(s, c, "del", "k"+strconv.Itoa(i+1))
+		execCommand(s, c, "set", "new"+strconv.Itoa(i), "v")
+	}
+	waitRewriteSCF(t, s)
+
+	t.Logf("%d keys: copy %v, bgrewritescf command %v", keys, copied, rewrite)
+	if rewrite > copied/10 {
+		t.Fatalf("lock is held too long, bgrewritescf command %v, copy %v", rewrite, copied)
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, cmd := range []string{"dbsize", "get k0", "get k1", "get k2", "get new0", "get k199000", "exists k199001", "get new199000"} {
+		if r, expect := execCommand(loaded, lc, strings.Fields(cmd)...), execCommand(s, c, strings.Fields(cmd)...); r != expect {
+			t.Fatalf("%s replies %q after loading, expect %q", cmd, r, expect)
+		}
+	}
+}
+
+func TestLoadSCFBinarySafe(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	values := []string{"*3\r\n$3\r\nset", "a*b*c", "line\r\nbreak", "", "**"}
+	c := s.CreateClient(nil)
+	for i, v := range values {
+		execCommand(s, c, "set", "k"+strconv.Itoa(i), v)
+		execCommand(s, c, "rpush", "list", v)
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for i := range values {
+		cmd := []string{"get", "k" + strconv.Itoa(i)}
+		if expect, r := execCommand(s, c, cmd...), execCommand(loaded, lc, cmd...); r != expect {
+			t.Fatalf("%v replies %q after loading, expect %q", cmd, r, expect)
+		}
+	}
+	if expect, r := execCommand(s, c, "lrange", "list", "0", "-1"), execCommand(loaded, lc, "lrange", "list", "0", "-1"); r != expect {
+		t.Fatalf("list is %q after loading, expect %q", r, expect)
+	}
+}
+
+func TestLoadSCFTruncated(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "set", "k1", "v1")
+	execCommand(s, c, "set", "k2", "v2")
+	size := s.scfCurrentSize
+	f, err := os.OpenFile(s.SCFFileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("*3\r\n$3\r\nset\r\n$2\r\nk3\r\n$2\r\nv"))
+	f.Close()
+
+	if _, err := reloadServer(s, false); err == nil {
+		t.Fatal("truncated scf should be refused")
+	}
+
+	loaded, err := reloadServer(s, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "get", "k2"); r != "$2\r\nv2\r\n" {
+		t.Fatalf("k2 is %q after loading", r)
+	}
+	if info, err := os.Stat(s.SCFFileName); err != nil || info.Size() != size {
+		t.Fatalf("scf isn't truncated to %d bytes, err:%v", size, err)
+	}
+
+	// garbage in the middle is never accepted
+	ioutil.WriteFile(s.SCFFileName, []byte("*1\r\n$4\r\nping\r\n+OK\r\n*1\r\n$4\r\nping\r\n"), 0644)
+	if _, err := reloadServer(s, true); err == nil {
+		t.Fatal("bad scf should be refused")
+	}
+}
+
+func TestLoadSCFChecksum(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+	s.SCFChecksum = true
+	s.SCFFsync = SCF_FSYNC_ALWAYS
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "set", "k", "value")
+	execCommand(s, c, "sadd", "set", "a", "b")
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := execCommand(loaded, loaded.CreateClient(nil), "get", "k"); r != "$5\r\nvalue\r\n" {
+		t.Fatalf("k is %q after loading", r)
+	}
+
+	content, err := ioutil.ReadFile(s.SCFFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = []byte(strings.Replace(string(content), "value", "vaLue", 1))
+	ioutil.WriteFile(s.SCFFileName, content, 0644)
+	if _, err := reloadServer(s, false); err == nil {
+		t.Fatal("scf with wrong checksum should be refused")
+	}
+}
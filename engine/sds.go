@@ -55,6 +55,11 @@ func (sds *Sdshdr) SdsGetBuf() []byte {
 	return buf
 }
 
+// SdsBuf return the content of sds without copying, modifying it modifies sds
+func (sds *Sdshdr) SdsBuf() []byte {
+	return sds.buf[:sds.len]
+}
+
 // SdsCopy uses str to replace previously existing content
 func (sds *Sdshdr) SdsCopy(str *string) {
 	l := len(*str)
@@ -67,6 +72,11 @@ func (sds *Sdshdr) SdsCopy(str *string) {
 	sds.len = l
 }
 
+// SdsDup return a copy of sds
+func (sds *Sdshdr) SdsDup() *Sdshdr {
+	return SdsNewBuf(sds.buf[:sds.len])
+}
+
 // SdsLen return the length of sds
 func (sds *Sdshdr) SdsLen() int {
 	return sds.len
@@ -82,36 +92,106 @@ func (sds *Sdshdr) SdsClear() {
 	sds.len = 0
 }
 
-//TODO: Optimize join performance
+// SDS_MAX_PREALLOC is the max size of space allocated in advance when sds grows
+const SDS_MAX_PREALLOC = 1024 * 1024
 
-// SdsCat appends str to end of buf
-func (sds *Sdshdr) SdsCat(str *string) {
-	l := len(*str)
-	if sds.Sdsavail() >= l {
-		copy(sds.buf[sds.len:], *str)
+// SdsMakeRoomFor makes sure there are at least addLen bytes available after the content,
+// more space is allocated in advance so that appending repeatedly is cheap
+func (sds *Sdshdr) SdsMakeRoomFor(addLen int) {
+	if sds.Sdsavail() >= addLen {
+		return
+	}
+	newLen := sds.len + addLen
+	if newLen < SDS_MAX_PREALLOC {
+		newLen *= 2
 	} else {
-		buf := make([]byte, sds.len+l)
-		copy(buf, sds.buf)
-		copy(buf[sds.len:], *str)
-		sds.buf = buf
-		sds.cap = sds.len + l
+		newLen += SDS_MAX_PREALLOC
 	}
-	sds.len += l
+	buf := make([]byte, newLen)
+	copy(buf, sds.buf[:sds.len])
+	sds.buf = buf
+	sds.cap = newLen
+}
+
+// SdsCat appends str to end of buf
+func (sds *Sdshdr) SdsCat(str *string) {
+	sds.SdsMakeRoomFor(len(*str))
+	copy(sds.buf[sds.len:], *str)
+	sds.len += len(*str)
 }
 
 // SdsCatSds append s.buf to sds
 func (sds *Sdshdr) SdsCatSds(s *Sdshdr) {
-	l := s.len
-	if sds.Sdsavail() >= l {
-		copy(sds.buf[sds.len:], s.buf)
-	} else {
-		buf := make([]byte, sds.len+l)
-		copy(buf, sds.buf)
-		copy(buf[sds.len:], s.buf)
-		sds.buf = buf
-		sds.cap = sds.len + s.len
+	sds.SdsMakeRoomFor(s.len)
+	copy(sds.buf[sds.len:], s.buf[:s.len])
+	sds.len += s.len
+}
+
+// SdsGrowZero grows sds to length l, the new bytes are set to 0,
+// nothing is done if sds is already longer than l
+func (sds *Sdshdr) SdsGrowZero(l int) {
+	if l <= sds.len {
+		return
+	}
+	sds.SdsMakeRoomFor(l - sds.len)
+	// bytes after len may be dirty
+	for i := sds.len; i < l; i++ {
+		sds.buf[i] = 0
+	}
+	sds.len = l
+}
+
+// SdsSetRange overwrites the content from offset with str, sds grows with 0 if it's not long enough
+func (sds *Sdshdr) SdsSetRange(offset int, str *string) {
+	sds.SdsGrowZero(offset + len(*str))
+	copy(sds.buf[offset:], *str)
+}
+
+// sdsRangeIndex converts start and end which may be negative into the indexes counted from head,
+// false is returned if the range is empty
+func sdsRangeIndex(start int, end int, l int) (int, int, bool) {
+	if start < 0 {
+		start += l
+		if start < 0 {
+			start = 0
+		}
+	}
+	if end < 0 {
+		end += l
+		if end < 0 {
+			end = 0
+		}
+	}
+	if end >= l {
+		end = l - 1
 	}
-	sds.len += l
+	if l == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// SdsRange keeps only the content from start to end, both of them are inclusive,
+// -1 is the last byte, -2 is the penultimate and so on
+func (sds *Sdshdr) SdsRange(start int, end int) {
+	start, end, ok := sdsRangeIndex(start, end, sds.len)
+	if !ok {
+		sds.len = 0
+		return
+	}
+	copy(sds.buf, sds.buf[start:end+1])
+	sds.len = end - start + 1
+}
+
+// SdsGetRange return a copy of the content from start to end like SdsRange
+func (sds *Sdshdr) SdsGetRange(start int, end int) []byte {
+	start, end, ok := sdsRangeIndex(start, end, sds.len)
+	if !ok {
+		return []byte{}
+	}
+	buf := make([]byte, end-start+1)
+	copy(buf, sds.buf[start:end+1])
+	return buf
 }
 
 // SdsCmp return 1 if the lexicographical order of s1 is larger than s2,-1 smaller,0 equal
@@ -1,9 +1,12 @@
 package godis
 
-import "fmt"
+import (
+	"fmt"
+	"testing"
+)
 
 // TestSds ...
-func TestSds() {
+func TestSds(t *testing.T) {
 
 	str := "abcdefse"
 	s1 := SdsNewString(str)
@@ -33,3 +36,28 @@ func TestSds() {
 	s1.SdsCatSds(s2)
 	fmt.Println(s1.SdsLen(), *s1.SdsGetString())
 }
+
+func TestSdsRange(t *testing.T) {
+	s := SdsNewString("hello")
+	s.SdsSetRange(7, &[]string{"world"}[0])
+	if *s.SdsGetString() != "hello\x00\x00world" {
+		t.Fatalf("wrong content %q after setting range", *s.SdsGetString())
+	}
+	if r := string(s.SdsGetRange(-5, -1)); r != "world" {
+		t.Fatalf("range -5 to -1 is %q", r)
+	}
+	if r := string(s.SdsGetRange(3, 1)); r != "" {
+		t.Fatalf("range 3 to 1 is %q", r)
+	}
+	s.SdsRange(1, -6)
+	if *s.SdsGetString() != "ello\x00\x00" {
+		t.Fatalf("wrong content %q after trimming", *s.SdsGetString())
+	}
+
+	// bytes left by the previous content are cleared when growing
+	s.SdsClear()
+	s.SdsGrowZero(3)
+	if *s.SdsGetString() != "\x00\x00\x00" {
+		t.Fatalf("wrong content %q after growing", *s.SdsGetString())
+	}
+}
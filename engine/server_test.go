@@ -0,0 +1,220 @@
+package godis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestServer start a server listening on a random port with its scf in a temp dir
+func newTestServer(t *testing.T) (*Server, string, func()) {
+	log = zap.NewNop().Sugar()
+	dir, err := ioutil.TempDir("", "godis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(&GodisConfig{
+		SCFFileName:      filepath.Join(dir, "test.scf"),
+		SnapshotFileName: filepath.Join(dir, "test.gdb"),
+	})
+	go s.serverCron()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.HandleConn(conn)
+		}
+	}()
+	return s, listener.Addr().String(), func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+type testConn struct {
+	conn    net.Conn
+	decoder *Decoder
+}
+
+func dialTestServer(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testConn{conn: conn, decoder: NewDecoder(conn)}
+}
+
+// send write commands at once without waiting for replies
+func (tc *testConn) send(cmds ...[]string) error {
+	var b []byte
+	for _, cmd := range cmds {
+		r := NewMultiBulk(nil)
+		for _, arg := range cmd {
+			r.Array = append(r.Array, NewBulk([]byte(arg)))
+		}
+		e, err := EncodeMultiBulk(r)
+		if err != nil {
+			return err
+		}
+		b = append(b, e...)
+	}
+	_, err := tc.conn.Write(b)
+	return err
+}
+
+func (tc *testConn) do(args ...string) (*EncodeData, error) {
+	if err := tc.send(args); err != nil {
+		return nil, err
+	}
+	return tc.decoder.Decode()
+}
+
+func TestConcurrentClients(t *testing.T) {
+	_, addr, stop := newTestServer(t)
+	defer stop()
+
+	const clients = 16
+	const rounds = 100
+
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			tc := dialTestServer(t, addr)
+			defer tc.conn.Close()
+
+			for j := 0; j < rounds; j++ {
+				member := fmt.Sprintf("m-%d-%d", id, j)
+				cmds := [][]string{
+					{"set", "str", member, "px", "5"},
+					{"incr", "counter"},
+					{"lpush", "list", member},
+					{"zadd", "zset", strconv.Itoa(j), member},
+				}
+				if err := tc.send(cmds...); err != nil {
+					errs <- err
+					return
+				}
+				for range cmds {
+					r, err := tc.decoder.Decode()
+					if err != nil {
+						errs <- err
+						return
+					}
+					if r.Type == TypeError {
+						errs <- fmt.Errorf("unexpected error reply %s", r.Value)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+	total := strconv.Itoa(clients * rounds)
+	if r, err := tc.do("get", "counter"); err != nil || string(r.Value) != total {
+		t.Fatalf("counter is %s, expect %s, err:%v", r.Value, total, err)
+	}
+	if r, err := tc.do("llen", "list"); err != nil || string(r.Value) != total {
+		t.Fatalf("length of list is %s, expect %s, err:%v", r.Value, total, err)
+	}
+	if r, err := tc.do("zrange", "zset", "0", "-1"); err != nil || len(r.Array) != clients*rounds {
+		t.Fatalf("size of zset is %d, expect %s, err:%v", len(r.Array), total, err)
+	}
+}
+
+func TestPipelinedReplies(t *testing.T) {
+	_, addr, stop := newTestServer(t)
+	defer stop()
+
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+
+	// a bulk larger than the query buffer and split commands in one write
+	value := string(make([]byte, 3*CLIENT_QUERY_BUF_SIZE))
+	if err := tc.send([]string{"set", "k", value}, []string{"get", "k"}, []string{"incr", "k"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expects := []byte{TypeStatus, TypeBulk, TypeError}
+	for i, tp := range expects {
+		r, err := tc.decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Type != tp {
+			t.Fatalf("type of reply %d is %c, expect %c", i, r.Type, tp)
+		}
+		if tp == TypeBulk && string(r.Value) != value {
+			t.Fatalf("wrong value of length %d", len(r.Value))
+		}
+	}
+}
+
+func TestIsCompleteMultiBulk(t *testing.T) {
+	for _, tc := range []struct {
+		content  string
+		complete bool
+	}{
+		{"*1\r\n$4\r\nping\r\n", true},
+		{"*2\r\n$3\r\nget\r\n$1\r\nk\r\n*1", true},
+		{"*0\r\n", true},
+		{"", false},
+		{"*2\r\n$3\r\nget\r\n", false},
+		{"*2\r\n$3\r\nget\r\n$1\r\nk", false},
+		{"*1\r\n$10\r\nping\r\n", false},
+		{"*1", false},
+		{"*x\r\n", false},
+	} {
+		if complete := isCompleteMultiBulk([]byte(tc.content)); complete != tc.complete {
+			t.Fatalf("isCompleteMultiBulk(%q) return %v", tc.content, complete)
+		}
+	}
+}
+
+func TestSplitCommandReply(t *testing.T) {
+	_, addr, stop := newTestServer(t)
+	defer stop()
+
+	tc := dialTestServer(t, addr)
+	defer tc.conn.Close()
+
+	// the reply of the first command is sent before the rest of the second one arrives
+	if _, err := tc.conn.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nget")); err != nil {
+		t.Fatal(err)
+	}
+	tc.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if r, err := tc.decoder.Decode(); err != nil || string(r.Value) != "OK" {
+		t.Fatalf("set replies %+v, err:%v", r, err)
+	}
+	if _, err := tc.conn.Write([]byte("\r\n$1\r\nk\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := tc.decoder.Decode(); err != nil || string(r.Value) != "v" {
+		t.Fatalf("get replies %+v, err:%v", r, err)
+	}
+}
@@ -0,0 +1,621 @@
+package godis
+
+import (
+	"strconv"
+)
+
+const (
+	SET_MAX_INTSET_ENTRIES = 512
+)
+
+const (
+	SET_OP_UNION = 0
+	SET_OP_INTER = 1
+	SET_OP_DIFF  = 2
+)
+
+// Set stores distinct members. A set which only contains integers is stored in intset,
+// and it's converted into dict once a non-integer member is added or it grows too large
+type Set struct {
+	is *IntSet // used when dt is nil
+	dt *Dict
+}
+
+// NewSet return a new set whose encoding fits the first member
+func NewSet(first *Sdshdr) *Set {
+	if _, ok := sdsToInt64(first); ok {
+		return &Set{is: NewIntSet()}
+	}
+	return &Set{dt: newSetDict()}
+}
+
+func newSetDict() *Dict {
+	df := &DictFunc{
+		calHash:    CalHashCommon,
+		keyCompare: CompareValueCommon,
+	}
+	return NewDict(df)
+}
+
+// sdsToInt64 return the integer if sds is the exact representation of it
+func sdsToInt64(sds *Sdshdr) (int64, bool) {
+	str := *sds.SdsGetString()
+	v, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != str {
+		return 0, false
+	}
+	return v, true
+}
+
+// IsIntSet return true if set is in intset encoding
+func (set *Set) IsIntSet() bool {
+	return set.dt == nil
+}
+
+// Len return the number of members
+func (set *Set) Len() int {
+	if set.IsIntSet() {
+		return set.is.Len()
+	}
+	return set.dt.Size()
+}
+
+// Add add member into set, return false if member already exists
+func (set *Set) Add(member *Sdshdr) bool {
+	if set.IsIntSet() {
+		if v, ok := sdsToInt64(member); ok {
+			if !set.is.Add(v) {
+				return false
+			}
+			if set.is.Len() > SET_MAX_INTSET_ENTRIES {
+				set.convert()
+			}
+			return true
+		}
+		set.convert()
+	}
+	return set.dt.Add(NewObject(OBJSDS, member), nil) == DICT_OK
+}
+
+// Remove remove member from set, return false if member doesn't exist
+func (set *Set) Remove(member *Sdshdr) bool {
+	if set.IsIntSet() {
+		if v, ok := sdsToInt64(member); ok {
+			return set.is.Remove(v)
+		}
+		return false
+	}
+	return set.dt.Delete(NewObject(OBJSDS, member)) == DICT_OK
+}
+
+// IsMember return true if member is in set
+func (set *Set) IsMember(member *Sdshdr) bool {
+	if set.IsIntSet() {
+		if v, ok := sdsToInt64(member); ok {
+			return set.is.Find(v)
+		}
+		return false
+	}
+	return set.dt.Search(NewObject(OBJSDS, member)) != nil
+}
+
+// RandomMember return a random member, set should not be empty
+func (set *Set) RandomMember() *Sdshdr {
+	if set.IsIntSet() {
+		return SdsNewString(strconv.FormatInt(set.is.Random(), 10))
+	}
+	return set.dt.GetRandomKey().key.Ptr.(*Sdshdr)
+}
+
+// ForEach call fn with each member until fn return false
+func (set *Set) ForEach(fn func(member *Sdshdr) bool) {
+	if set.IsIntSet() {
+		for i := 0; i < set.is.Len(); i++ {
+			if !fn(SdsNewString(strconv.FormatInt(set.is.Get(i), 10))) {
+				return
+			}
+		}
+		return
+	}
+
+	iter := NewSafeDictIterator(set.dt)
+	defer ReleaseIterator(iter)
+	for node := iter.Next(); node != nil; node = iter.Next() {
+		if !fn(node.key.Ptr.(*Sdshdr)) {
+			return
+		}
+	}
+}
+
+// convert convert intset into dict
+func (set *Set) convert() {
+	dt := newSetDict()
+	for i := 0; i < set.is.Len(); i++ {
+		dt.Add(NewObject(OBJSDS, SdsNewString(strconv.FormatInt(set.is.Get(i), 10))), nil)
+	}
+	set.dt = dt
+	set.is = nil
+}
+
+// setLookupRead return the set stored at key and whether the type of key is right,
+// the set is nil if key doesn't exist
+func setLookupRead(c *Client, key *Object) (*Set, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
+	}
+	if !checkType(c, value, OBJSet) {
+		return nil, false
+	}
+	return value.Ptr.(*Set), true
+}
+
+// SAddCommand ...
+// SADD key member [member ...]
+func SAddCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sadd' command")
+		return
+	}
+
+	key := c.Argv[1]
+	set, ok := setLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if set == nil {
+		set = NewSet(SdsNewString(c.Argv[2].Ptr.(string)))
+		c.Db.Add(key, NewObject(OBJSet, set))
+	}
+
+	added := 0
+	for i := 2; i < c.Argc; i++ {
+		if set.Add(SdsNewString(c.Argv[i].Ptr.(string))) {
+			added++
+		}
+	}
+	if added > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, int64(added))
+}
+
+// SRemCommand ...
+// SREM key member [member ...]
+func SRemCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'srem' command")
+		return
+	}
+
+	key := c.Argv[1]
+	set, ok := setLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if set == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	removed := 0
+	for i := 2; i < c.Argc; i++ {
+		if set.Remove(SdsNewString(c.Argv[i].Ptr.(string))) {
+			removed++
+		}
+	}
+	if set.Len() == 0 {
+		c.Db.Delete(key)
+	}
+	if removed > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, int64(removed))
+}
+
+// SIsMemberCommand ...
+func SIsMemberCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sismember' command")
+		return
+	}
+
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if set == nil || !set.IsMember(SdsNewString(c.Argv[2].Ptr.(string))) {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, 1)
+}
+
+// SMIsMemberCommand ...
+// SMISMEMBER key member [member ...]
+func SMIsMemberCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'smismember' command")
+		return
+	}
+
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		v := "0"
+		if set != nil && set.IsMember(SdsNewString(c.Argv[i].Ptr.(string))) {
+			v = "1"
+		}
+		array = append(array, NewInt([]byte(v)))
+	}
+	addReplyArray(c, array)
+}
+
+// SCardCommand ...
+func SCardCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'scard' command")
+		return
+	}
+
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if set == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(set.Len()))
+}
+
+// SMembersCommand ...
+func SMembersCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'smembers' command")
+		return
+	}
+
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	addReplySetMembers(c, set)
+}
+
+// addReplySetMembers replies all members of set, set could be nil
+func addReplySetMembers(c *Client, set *Set) {
+	array := make([]*EncodeData, 0)
+	if set != nil {
+		set.ForEach(func(member *Sdshdr) bool {
+			array = append(array, NewBulk(member.SdsGetBuf()))
+			return true
+		})
+	}
+	addReplyArray(c, array)
+}
+
+// SPopCommand ...
+// SPOP key [count]
+func SPopCommand(c *Client, s *Server) {
+	if c.Argc != 2 && c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'spop' command")
+		return
+	}
+
+	count := int64(1)
+	withCount := c.Argc == 3
+	if withCount {
+		var ok bool
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		if count < 0 {
+			addReplyError(c, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	set, ok := setLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if set == nil {
+		if withCount {
+			addReplyArray(c, []*EncodeData{})
+		} else {
+			addReplyNull(c)
+		}
+		return
+	}
+
+	popped := make([]*Sdshdr, 0)
+	for ; count > 0 && set.Len() > 0; count-- {
+		member := set.RandomMember()
+		set.Remove(member)
+		popped = append(popped, member)
+	}
+	if set.Len() == 0 {
+		c.Db.Delete(key)
+	}
+
+	if len(popped) > 0 {
+		// the members are chosen randomly, so scf stores the members removed
+		args := []string{"srem", key.Ptr.(string)}
+		for _, member := range popped {
+			args = append(args, *member.SdsGetString())
+		}
+		rewriteClientCommandArgv(c, args...)
+		s.Dirty++
+	}
+
+	if !withCount {
+		addReplyBulkSds(c, popped[0])
+		return
+	}
+	array := make([]*EncodeData, 0, len(popped))
+	for _, member := range popped {
+		array = append(array, NewBulk(member.SdsGetBuf()))
+	}
+	addReplyArray(c, array)
+}
+
+// SRandMemberCommand ...
+// SRANDMEMBER key [count], members could be repeated if count is negative
+func SRandMemberCommand(c *Client, s *Server) {
+	if c.Argc != 2 && c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'srandmember' command")
+		return
+	}
+
+	count := int64(1)
+	if c.Argc == 3 {
+		var ok bool
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		// members may repeat with a negative count, so the reply must be bounded
+		if count < -MaxMultiBulkLen {
+			addReplyError(c, "ERR value is out of range")
+			return
+		}
+	}
+
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if c.Argc == 2 {
+		if set == nil {
+			addReplyNull(c)
+		} else {
+			addReplyBulkSds(c, set.RandomMember())
+		}
+		return
+	}
+
+	array := make([]*EncodeData, 0)
+	if set == nil || count == 0 {
+		addReplyArray(c, array)
+		return
+	}
+
+	if count < 0 {
+		for ; count < 0; count++ {
+			array = append(array, NewBulk(set.RandomMember().SdsGetBuf()))
+		}
+		addReplyArray(c, array)
+		return
+	}
+
+	if count >= int64(set.Len()) {
+		addReplySetMembers(c, set)
+		return
+	}
+
+	// choose distinct members
+	chosen := NewSet(SdsNewEmpty())
+	for int64(chosen.Len()) < count {
+		member := set.RandomMember()
+		if chosen.Add(member) {
+			array = append(array, NewBulk(member.SdsGetBuf()))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// SMoveCommand ...
+// SMOVE source destination member
+func SMoveCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'smove' command")
+		return
+	}
+
+	src, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	dst, ok := setLookupRead(c, c.Argv[2])
+	if !ok {
+		return
+	}
+
+	member := SdsNewString(c.Argv[3].Ptr.(string))
+	if src == nil || !src.IsMember(member) {
+		addReplyInt(c, 0)
+		return
+	}
+	if src == dst {
+		addReplyInt(c, 1)
+		return
+	}
+
+	s.Dirty++
+	src.Remove(member)
+	if src.Len() == 0 {
+		c.Db.Delete(c.Argv[1])
+	}
+	if dst == nil {
+		dst = NewSet(member)
+		c.Db.Add(c.Argv[2], NewObject(OBJSet, dst))
+	}
+	dst.Add(member)
+	addReplyInt(c, 1)
+}
+
+// SInterCommand ...
+func SInterCommand(c *Client, s *Server) {
+	setOperationGenericCommand(c, s, "sinter", SET_OP_INTER, nil)
+}
+
+// SUnionCommand ...
+func SUnionCommand(c *Client, s *Server) {
+	setOperationGenericCommand(c, s, "sunion", SET_OP_UNION, nil)
+}
+
+// SDiffCommand ...
+func SDiffCommand(c *Client, s *Server) {
+	setOperationGenericCommand(c, s, "sdiff", SET_OP_DIFF, nil)
+}
+
+// SInterStoreCommand ...
+func SInterStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sinterstore' command")
+		return
+	}
+	setOperationGenericCommand(c, s, "sinterstore", SET_OP_INTER, c.Argv[1])
+}
+
+// SUnionStoreCommand ...
+func SUnionStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sunionstore' command")
+		return
+	}
+	setOperationGenericCommand(c, s, "sunionstore", SET_OP_UNION, c.Argv[1])
+}
+
+// SDiffStoreCommand ...
+func SDiffStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sdiffstore' command")
+		return
+	}
+	setOperationGenericCommand(c, s, "sdiffstore", SET_OP_DIFF, c.Argv[1])
+}
+
+// setOperationGenericCommand computes the union, intersection or difference of sets,
+// the result is stored at dstKey if it isn't nil, otherwise it's replied
+func setOperationGenericCommand(c *Client, s *Server, name string, op int, dstKey *Object) {
+	first := 1
+	if dstKey != nil {
+		first = 2
+	}
+	if c.Argc <= first {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	sets := make([]*Set, 0, c.Argc-first)
+	for i := first; i < c.Argc; i++ {
+		set, ok := setLookupRead(c, c.Argv[i])
+		if !ok {
+			return
+		}
+		sets = append(sets, set)
+	}
+
+	result := setOperation(sets, op)
+
+	if dstKey == nil {
+		addReplySetMembers(c, result)
+		return
+	}
+
+	if result.Len() == 0 {
+		c.Db.Delete(dstKey)
+	} else {
+		c.Db.SetKey(dstKey, NewObject(OBJSet, result), false)
+	}
+	s.Dirty++
+	addReplyInt(c, int64(result.Len()))
+}
+
+// setOperation return the result of op on sets, nil in sets is regarded as an empty set
+func setOperation(sets []*Set, op int) *Set {
+	result := &Set{is: NewIntSet()}
+	switch op {
+	case SET_OP_UNION:
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			set.ForEach(func(member *Sdshdr) bool {
+				result.Add(member)
+				return true
+			})
+		}
+	case SET_OP_INTER:
+		smallest := -1
+		for i, set := range sets {
+			if set == nil {
+				return result
+			}
+			if smallest == -1 || set.Len() < sets[smallest].Len() {
+				smallest = i
+			}
+		}
+		sets[smallest].ForEach(func(member *Sdshdr) bool {
+			for i, set := range sets {
+				if i != smallest && !set.IsMember(member) {
+					return true
+				}
+			}
+			result.Add(member)
+			return true
+		})
+	case SET_OP_DIFF:
+		if sets[0] == nil {
+			return result
+		}
+		sets[0].ForEach(func(member *Sdshdr) bool {
+			for _, set := range sets[1:] {
+				if set != nil && set.IsMember(member) {
+					return true
+				}
+			}
+			result.Add(member)
+			return true
+		})
+	}
+	return result
+}
+
+// SScanCommand ...
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScanCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'sscan' command")
+		return
+	}
+
+	cursor, ok := parseScanCursorOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	set, ok := setLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if set == nil {
+		addReplyScan(c, 0, []*EncodeData{})
+		return
+	}
+	scanGenericCommand(c, NewObject(OBJSet, set), cursor, 3)
+}
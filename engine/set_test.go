@@ -0,0 +1,110 @@
+package godis
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"sadd s 3 1 2", ":3\r\n"},
+		{"sadd s 2", ":0\r\n"},
+		{"smembers s", "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{"sismember s 2", ":1\r\n"},
+		{"smismember s 1 9", "*2\r\n:1\r\n:0\r\n"},
+		{"srem s 3 9", ":1\r\n"},
+		{"scard s", ":2\r\n"},
+		{"srandmember s -1048577", "-ERR value is out of range\r\n"},
+		{"srandmember s -9223372036854775808", "-ERR value is out of range\r\n"},
+		{"srandmember nope -5", "*0\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"sadd str a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{"sadd src 1 2", ":2\r\n"},
+		{"smove src dst 1", ":1\r\n"},
+		{"smove src dst 9", ":0\r\n"},
+		{"smove src src 2", ":1\r\n"},
+		{"smove src str 2", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"smove src dst 2", ":1\r\n"},
+		{"scard src", ":0\r\n"},
+		{"smembers dst", "*2\r\n$1\r\n1\r\n$1\r\n2\r\n"},
+		{"smove src", "-ERR wrong number of arguments for 'smove' command\r\n"},
+
+		{"sadd a 1 2 3 4", ":4\r\n"},
+		{"sadd b 3 4 5", ":3\r\n"},
+		{"sinter a b", "*2\r\n$1\r\n3\r\n$1\r\n4\r\n"},
+		{"sinter a nope", "*0\r\n"},
+		{"sunion a b nope", "*5\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n$1\r\n5\r\n"},
+		{"sdiff a b", "*2\r\n$1\r\n1\r\n$1\r\n2\r\n"},
+		{"sdiff a nope", "*4\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n$1\r\n4\r\n"},
+		{"sinter a str", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"sinterstore out a b", ":2\r\n"},
+		{"smembers out", "*2\r\n$1\r\n3\r\n$1\r\n4\r\n"},
+		{"sunionstore out a b", ":5\r\n"},
+		{"sdiffstore out a a", ":0\r\n"},
+		{"scard out", ":0\r\n"},
+		{"sinter", "-ERR wrong number of arguments for 'sinter' command\r\n"},
+		{"sinterstore", "-ERR wrong number of arguments for 'sinterstore' command\r\n"},
+		{"sunionstore dst", "-ERR wrong number of arguments for 'sunionstore' command\r\n"},
+		{"sdiffstore", "-ERR wrong number of arguments for 'sdiffstore' command\r\n"},
+	} {
+		if r := execCommand(s, c, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+	if c.Db.Dt.Get(NewObject(OBJString, "src")) != nil {
+		t.Fatal("src isn't deleted after all the members are moved")
+	}
+
+	// the members are random, only the number of them is checked
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"srandmember s 0", "*0\r\n"},
+		{"srandmember s 5", "*2\r\n"},
+		{"srandmember s -5", "*5\r\n"},
+		{"srandmember s -1048576", "*1048576\r\n"},
+	} {
+		if r := execCommand(s, c, strings.Fields(tc.cmd)...); !strings.HasPrefix(r, tc.expect) {
+			t.Fatalf("%s replies %.100q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+func TestSPopSCF(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "sadd", "s", "1", "2", "3")
+	r, err := DecodeFromBytes([]byte(execCommand(s, c, "spop", "s", "2")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Array) != 2 {
+		t.Fatalf("spop s 2 replies %d members", len(r.Array))
+	}
+
+	// the random members popped are stored in scf as SREM
+	srem := NewMultiBulk([]*EncodeData{NewBulk([]byte("srem")), NewBulk([]byte("s")), r.Array[0], r.Array[1]})
+	expect, err := EncodeMultiBulk(srem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(s.SCFFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(content), "spop") || !strings.Contains(string(content), string(expect)) {
+		t.Fatalf("scf %q doesn't store spop as %q", content, expect)
+	}
+}
@@ -0,0 +1,668 @@
+package godis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// A snapshot starts with "GODIS" and a 4 digits version, followed by the size and crc64 of scf
+// when it's taken. Each db starts with SELECTDB and its id, and then every key is stored as
+// [EXPIRETIME_MS ms] type key value. The file ends with EOF and the crc64 of all the bytes before.
+// Lengths are stored as uvarint and strings are stored as length + bytes
+const (
+	SNAPSHOT_MAGIC   = "GODIS"
+	SNAPSHOT_VERSION = 2
+
+	SNAPSHOT_TYPE_STRING = 0
+	SNAPSHOT_TYPE_LIST   = 1
+	SNAPSHOT_TYPE_SET    = 2
+	SNAPSHOT_TYPE_ZSET   = 3
+	SNAPSHOT_TYPE_HASH   = 4
+	SNAPSHOT_TYPE_STREAM = 5
+
+	SNAPSHOT_OPCODE_EXPIRETIME_MS = 0xFC
+	SNAPSHOT_OPCODE_SELECTDB      = 0xFE
+	SNAPSHOT_OPCODE_EOF           = 0xFF
+)
+
+const (
+	SNAPSHOT_COPY_BATCH = 1000 // keys copied in a batch while holding s.mu for the background persistence
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+var snapshotTypes = map[int]byte{
+	OBJSDS:    SNAPSHOT_TYPE_STRING,
+	OBJList:   SNAPSHOT_TYPE_LIST,
+	OBJSet:    SNAPSHOT_TYPE_SET,
+	OBJZset:   SNAPSHOT_TYPE_ZSET,
+	OBJHash:   SNAPSHOT_TYPE_HASH,
+	OBJStream: SNAPSHOT_TYPE_STREAM,
+}
+
+// snapshotInfo is the header of snapshot
+type snapshotInfo struct {
+	scfSize int64  // size of scf when the snapshot is taken
+	scfCRC  uint64 // crc64 of the scf content at that time
+}
+
+// snapshotWriter writes the encoded data and computes their crc64,
+// the first error is kept and the later writes are ignored
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+}
+
+func (sw *snapshotWriter) write(b []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc = crc64.Update(sw.crc, crc64Table, b)
+	_, sw.err = sw.w.Write(b)
+}
+
+func (sw *snapshotWriter) writeByte(b byte) {
+	sw.write([]byte{b})
+}
+
+func (sw *snapshotWriter) writeLen(l uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	sw.write(buf[:binary.PutUvarint(buf, l)])
+}
+
+func (sw *snapshotWriter) writeUint64(v uint64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	sw.write(buf)
+}
+
+func (sw *snapshotWriter) writeString(b []byte) {
+	sw.writeLen(uint64(len(b)))
+	sw.write(b)
+}
+
+// writeKey writes the type of o, key and then the value of o
+func (sw *snapshotWriter) writeKey(key string, o *Object) {
+	tp, ok := snapshotTypes[o.ObjectType]
+	if !ok {
+		sw.err = fmt.Errorf("unknown type %d of key %s", o.ObjectType, key)
+		return
+	}
+	sw.writeByte(tp)
+	sw.writeString([]byte(key))
+
+	switch o.ObjectType {
+	case OBJSDS:
+		sw.writeString(o.Ptr.(*Sdshdr).SdsGetBuf())
+	case OBJList:
+		l := o.Ptr.(*List)
+		sw.writeLen(uint64(l.Length()))
+		iter := l.RewindHead()
+		for node := iter.NextNode(); node != nil; node = iter.NextNode() {
+			sw.writeString([]byte(node.Value().Ptr.(string)))
+		}
+	case OBJSet:
+		set := o.Ptr.(*Set)
+		sw.writeLen(uint64(set.Len()))
+		set.ForEach(func(member *Sdshdr) bool {
+			sw.writeString(member.SdsGetBuf())
+			return true
+		})
+	case OBJZset:
+		zsl := o.Ptr.(*ZskipList)
+		sw.writeLen(uint64(zsl.length))
+		for node := zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+			sw.writeString(node.value.SdsGetBuf())
+			sw.writeUint64(math.Float64bits(node.score))
+		}
+	case OBJHash:
+		h := o.Ptr.(*HashMap)
+		sw.writeLen(uint64(h.Len()))
+		h.ForEach(func(field *Sdshdr, value *Sdshdr) bool {
+			sw.writeString(field.SdsGetBuf())
+			sw.writeString(value.SdsGetBuf())
+			return true
+		})
+	case OBJStream:
+		sw.writeStream(o.Ptr.(*Stream))
+	}
+}
+
+func (sw *snapshotWriter) writeStreamID(id streamID) {
+	sw.writeUint64(id.ms)
+	sw.writeUint64(id.seq)
+}
+
+// writeStream writes the ids of stream, entries as id + fields, and then groups with their
+// consumers and pending entries
+func (sw *snapshotWriter) writeStream(st *Stream) {
+	sw.writeStreamID(st.lastID)
+	sw.writeLen(uint64(len(st.entries)))
+	for _, entry := range st.entries {
+		sw.writeStreamID(entry.id)
+		sw.writeLen(uint64(len(entry.fields)))
+		for _, field := range entry.fields {
+			sw.writeString([]byte(field))
+		}
+	}
+
+	sw.writeLen(uint64(len(st.groups)))
+	for _, name := range st.sortedGroupNames() {
+		cg := st.groups[name]
+		sw.writeString([]byte(name))
+		sw.writeStreamID(cg.lastID)
+		sw.writeLen(uint64(len(cg.consumers)))
+		for _, consumer := range cg.consumers {
+			sw.writeString([]byte(consumer.name))
+			sw.writeUint64(uint64(consumer.seenTime))
+		}
+		sw.writeLen(uint64(len(cg.pel)))
+		for _, nack := range cg.pel {
+			sw.writeStreamID(nack.id)
+			sw.writeString([]byte(nack.consumer.name))
+			sw.writeUint64(uint64(nack.deliveryTime))
+			sw.writeUint64(nack.deliveryCount)
+		}
+	}
+}
+
+// writeSnapshot writes dbs into fileName through a temp file, so fileName is always a complete snapshot
+func writeSnapshot(fileName string, dbs [][]*dbEntry, info *snapshotInfo) error {
+	tmpFile := filepath.Join(filepath.Dir(fileName), fmt.Sprintf("temp-%d.gdb", os.Getpid()))
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+
+	sw := &snapshotWriter{w: bufio.NewWriter(f)}
+	sw.write([]byte(fmt.Sprintf("%s%04d", SNAPSHOT_MAGIC, SNAPSHOT_VERSION)))
+	sw.writeLen(uint64(info.scfSize))
+	sw.writeUint64(info.scfCRC)
+	for id, entries := range dbs {
+		if len(entries) == 0 {
+			continue
+		}
+		sw.writeByte(SNAPSHOT_OPCODE_SELECTDB)
+		sw.writeLen(uint64(id))
+		for _, e := range entries {
+			if e.expire != -1 {
+				sw.writeByte(SNAPSHOT_OPCODE_EXPIRETIME_MS)
+				sw.writeUint64(uint64(e.expire))
+			}
+			sw.writeKey(e.key, e.value)
+		}
+	}
+	sw.writeByte(SNAPSHOT_OPCODE_EOF)
+	crc := sw.crc
+	sw.writeUint64(crc)
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err == nil {
+		sw.err = f.Sync()
+	}
+	if err := f.Close(); sw.err == nil {
+		sw.err = err
+	}
+	if sw.err == nil {
+		sw.err = os.Rename(tmpFile, fileName)
+	}
+	if sw.err != nil {
+		os.Remove(tmpFile)
+	}
+	return sw.err
+}
+
+// snapshotReader reads the encoded data and computes their crc64
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc uint64
+	// bytes of the file not read yet, lengths are checked against it before allocating,
+	// because they may be corrupted and the crc64 is only checked at the end
+	remaining int64
+}
+
+func (sr *snapshotReader) read(n int) ([]byte, error) {
+	if int64(n) > sr.remaining {
+		return nil, io.ErrUnexpectedEOF
+	}
+	sr.remaining -= int64(n)
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		return nil, err
+	}
+	sr.crc = crc64.Update(sr.crc, crc64Table, b)
+	return b, nil
+}
+
+// ReadByte makes snapshotReader an io.ByteReader
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (sr *snapshotReader) readLen() (uint64, error) {
+	return binary.ReadUvarint(sr)
+}
+
+func (sr *snapshotReader) readUint64() (uint64, error) {
+	b, err := sr.read(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (sr *snapshotReader) readString() ([]byte, error) {
+	l, err := sr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if l > MaxBulkLen {
+		return nil, errors.New("bad string length")
+	}
+	return sr.read(int(l))
+}
+
+// readValue reads the value whose type is tp
+func (sr *snapshotReader) readValue(tp byte) (*Object, error) {
+	if tp == SNAPSHOT_TYPE_STRING {
+		b, err := sr.readString()
+		if err != nil {
+			return nil, err
+		}
+		return NewObject(OBJSDS, SdsNewBuf(b)), nil
+	}
+	// streams may be empty
+	if tp == SNAPSHOT_TYPE_STREAM {
+		st, err := sr.readStream()
+		if err != nil {
+			return nil, err
+		}
+		return NewObject(OBJStream, st), nil
+	}
+
+	n, err := sr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("empty value")
+	}
+	switch tp {
+	case SNAPSHOT_TYPE_LIST:
+		l := NewList()
+		for i := uint64(0); i < n; i++ {
+			b, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			l.AddNodeTail(NewObject(OBJString, string(b)))
+		}
+		return NewObject(OBJList, l), nil
+	case SNAPSHOT_TYPE_SET:
+		var set *Set
+		for i := uint64(0); i < n; i++ {
+			b, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			member := SdsNewBuf(b)
+			if set == nil {
+				set = NewSet(member)
+			}
+			set.Add(member)
+		}
+		return NewObject(OBJSet, set), nil
+	case SNAPSHOT_TYPE_ZSET:
+		zsl := NewZsl()
+		for i := uint64(0); i < n; i++ {
+			b, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			score, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			zsl.addMember(math.Float64frombits(score), SdsNewBuf(b))
+		}
+		return NewObject(OBJZset, zsl), nil
+	case SNAPSHOT_TYPE_HASH:
+		h := NewHashMap()
+		for i := uint64(0); i < n; i++ {
+			field, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			h.Set(SdsNewBuf(field), SdsNewBuf(value))
+		}
+		return NewObject(OBJHash, h), nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", tp)
+}
+
+func (sr *snapshotReader) readStreamID() (streamID, error) {
+	ms, err := sr.readUint64()
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, err := sr.readUint64()
+	if err != nil {
+		return streamID{}, err
+	}
+	return streamID{ms, seq}, nil
+}
+
+// readStream reads the stream written by writeStream
+func (sr *snapshotReader) readStream() (*Stream, error) {
+	st := NewStream()
+	var err error
+	if st.lastID, err = sr.readStreamID(); err != nil {
+		return nil, err
+	}
+	n, err := sr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		id, err := sr.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		numFields, err := sr.readLen()
+		if err != nil {
+			return nil, err
+		}
+		if numFields == 0 || numFields%2 != 0 || numFields > uint64(sr.remaining) {
+			return nil, errors.New("bad stream entry")
+		}
+		fields := make([]string, 0, numFields)
+		for j := uint64(0); j < numFields; j++ {
+			b, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, string(b))
+		}
+		if len(st.entries) > 0 && id.compare(st.entries[len(st.entries)-1].id) <= 0 || id.compare(st.lastID) > 0 {
+			return nil, errors.New("stream entries out of order")
+		}
+		st.entries = append(st.entries, &streamEntry{id: id, fields: fields})
+	}
+
+	numGroups, err := sr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numGroups; i++ {
+		name, err := sr.readString()
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := sr.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		cg := st.createGroup(string(name), lastID)
+		numConsumers, err := sr.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < numConsumers; j++ {
+			consumerName, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			seenTime, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			consumer, _ := cg.lookupConsumer(string(consumerName), true)
+			consumer.seenTime = int64(seenTime)
+		}
+		numPending, err := sr.readLen()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < numPending; j++ {
+			id, err := sr.readStreamID()
+			if err != nil {
+				return nil, err
+			}
+			consumerName, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			deliveryTime, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			deliveryCount, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			consumer, _ := cg.lookupConsumer(string(consumerName), false)
+			if consumer == nil || cg.pelLookup(id) != nil {
+				return nil, errors.New("bad pending entry of stream")
+			}
+			cg.pelAdd(id, consumer, int64(deliveryTime), deliveryCount)
+		}
+	}
+	return st, nil
+}
+
+// loadSnapshot loads the snapshot of fileName into dbs, nil is returned if the file doesn't exist.
+// Keys which have been expired are skipped
+func loadSnapshot(fileName string, dbs []*GodisDB) (*snapshotInfo, error) {
+	f, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sr := &snapshotReader{r: bufio.NewReader(f), remaining: stat.Size()}
+	header, err := sr.read(len(SNAPSHOT_MAGIC) + 4)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(SNAPSHOT_MAGIC)]) != SNAPSHOT_MAGIC {
+		return nil, errors.New("wrong signature of snapshot")
+	}
+	version, err := strconv.Atoi(string(header[len(SNAPSHOT_MAGIC):]))
+	if err != nil || version < 1 || version > SNAPSHOT_VERSION {
+		return nil, fmt.Errorf("can't handle snapshot format version %s", header[len(SNAPSHOT_MAGIC):])
+	}
+
+	info := &snapshotInfo{}
+	scfSize, err := sr.readLen()
+	if err != nil {
+		return nil, err
+	}
+	info.scfSize = int64(scfSize)
+	if info.scfCRC, err = sr.readUint64(); err != nil {
+		return nil, err
+	}
+
+	var db *GodisDB
+	var expire int64 = -1
+	now := mstime()
+	for {
+		opcode, err := sr.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case SNAPSHOT_OPCODE_EOF:
+			crc := sr.crc
+			expect, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			if crc != expect {
+				return nil, errors.New("wrong crc64 checksum of snapshot")
+			}
+			return info, nil
+		case SNAPSHOT_OPCODE_SELECTDB:
+			id, err := sr.readLen()
+			if err != nil {
+				return nil, err
+			}
+			if id >= uint64(len(dbs)) {
+				return nil, fmt.Errorf("snapshot selects db %d but only %d databases are configured", id, len(dbs))
+			}
+			db = dbs[id]
+		case SNAPSHOT_OPCODE_EXPIRETIME_MS:
+			when, err := sr.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			expire = int64(when)
+		default:
+			if db == nil {
+				return nil, errors.New("key before selecting db")
+			}
+			key, err := sr.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := sr.readValue(opcode)
+			if err != nil {
+				return nil, err
+			}
+			if expire == -1 || expire > now {
+				keyObj := NewObject(OBJString, string(key))
+				db.Add(keyObj, value)
+				if expire != -1 {
+					db.SetExpire(keyObj, expire)
+				}
+			}
+			expire = -1
+		}
+	}
+}
+
+// startSnapshots starts taking the snapshots of all the dbs and return the state of scf,
+// it must be called while holding s.mu
+func (s *Server) startSnapshots() ([]*dbSnapshot, *snapshotInfo) {
+	snaps := make([]*dbSnapshot, len(s.Db))
+	for i, db := range s.Db {
+		snaps[i] = db.startSnapshot()
+	}
+	// the records after the snapshots may be replayed alone, so they must start with a SELECT
+	s.scfSelectedDb = -1
+	return snaps, &snapshotInfo{scfSize: s.scfCurrentSize, scfCRC: s.scfCRC}
+}
+
+// copySnapshots copies the keys into snaps and return the copies of all the dbs,
+// s.mu is held only while copying a batch so that commands are served between batches
+func (s *Server) copySnapshots(snaps []*dbSnapshot) [][]*dbEntry {
+	dbs := make([][]*dbEntry, len(snaps))
+	for i, snap := range snaps {
+		for done := false; !done; {
+			s.mu.Lock()
+			done = snap.copyBatch()
+			s.mu.Unlock()
+			// let the clients waiting for the lock run before the next batch
+			runtime.Gosched()
+		}
+		dbs[i] = snap.entries
+	}
+	return dbs
+}
+
+// snapshotDatabases return the copies of all the dbs and the state of scf,
+// it must be called while holding s.mu and the whole copy is done before it returns
+func (s *Server) snapshotDatabases() ([][]*dbEntry, *snapshotInfo) {
+	snaps, info := s.startSnapshots()
+	dbs := make([][]*dbEntry, len(snaps))
+	for i, snap := range snaps {
+		for !snap.copyBatch() {
+		}
+		dbs[i] = snap.entries
+	}
+	return dbs, info
+}
+
+// SaveCommand ...
+func SaveCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'save' command")
+		return
+	}
+	if s.bgsaving {
+		addReplyError(c, "ERR Background save already in progress")
+		return
+	}
+	dbs, info := s.snapshotDatabases()
+	if err := writeSnapshot(s.SnapshotFileName, dbs, info); err != nil {
+		log.Errorf("save snapshot error:%v", err)
+		addReplyError(c, "ERR "+err.Error())
+		return
+	}
+	s.lastSave = time.Now().Unix()
+	addReplyStatus(c, "OK")
+}
+
+// BgSaveCommand ...
+func BgSaveCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'bgsave' command")
+		return
+	}
+	if s.bgsaving {
+		addReplyError(c, "ERR Background save already in progress")
+		return
+	}
+	snaps, info := s.startSnapshots()
+	s.bgsaving = true
+	go s.bgsave(snaps, info)
+	addReplyStatus(c, "Background saving started")
+}
+
+func (s *Server) bgsave(snaps []*dbSnapshot, info *snapshotInfo) {
+	err := writeSnapshot(s.SnapshotFileName, s.copySnapshots(snaps), info)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bgsaving = false
+	if err != nil {
+		log.Errorf("background save error:%v", err)
+		return
+	}
+	s.lastSave = time.Now().Unix()
+	log.Infof("background saving finished")
+}
+
+// LastSaveCommand ...
+func LastSaveCommand(c *Client, s *Server) {
+	if c.Argc != 1 {
+		addReplyError(c, "ERR wrong number of arguments for 'lastsave' command")
+		return
+	}
+	addReplyInt(c, s.lastSave)
+}
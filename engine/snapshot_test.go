@@ -0,0 +1,283 @@
+package godis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func waitBgsave(t *testing.T, s *Server) {
+	for i := 0; i < 500; i++ {
+		s.mu.Lock()
+		saving := s.bgsaving
+		s.mu.Unlock()
+		if !saving {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("background saving doesn't finish")
+}
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for i := 0; i < 100; i++ {
+		execCommand(s, c, "rpush", "list", "v"+strconv.Itoa(i))
+		execCommand(s, c, "zadd", "zset", strconv.Itoa(i%7)+".5", "m"+strconv.Itoa(i))
+		execCommand(s, c, "hset", "hash", "f"+strconv.Itoa(i), "v")
+		execCommand(s, c, "sadd", "set", strconv.Itoa(i))
+		execCommand(s, c, "incr", "counter")
+	}
+	execCommand(s, c, "set", "ttl", "v", "px", "100000")
+	execCommand(s, c, "zadd", "zset", "-inf", "min")
+
+	if r := execCommand(s, c, "bgsave"); r != "+Background saving started\r\n" {
+		t.Fatalf("bgsave replies %q", r)
+	}
+	waitBgsave(t, s)
+	if r := execCommand(s, c, "lastsave"); r < ":"+strconv.FormatInt(time.Now().Unix()-1, 10) {
+		t.Fatalf("lastsave replies %q", r)
+	}
+
+	// only the commands after the snapshot are replayed, otherwise counter is increased twice
+	execCommand(s, c, "incr", "counter")
+	execCommand(s, c, "sadd", "set", "x")
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, cmd := range [][]string{
+		{"get", "counter"},
+		{"lrange", "list", "0", "-1"},
+		{"zrange", "zset", "0", "-1", "withscores"},
+		{"hgetall", "hash"},
+		{"scard", "set"},
+		{"sismember", "set", "x"},
+	} {
+		if expect, r := execCommand(s, c, cmd...), execCommand(loaded, lc, cmd...); r != expect {
+			t.Fatalf("%v replies %q after loading, expect %q", cmd, r, expect)
+		}
+	}
+	if ttl := loaded.Db[0].GetExpire(NewObject(OBJString, "ttl")); ttl != s.Db[0].GetExpire(NewObject(OBJString, "ttl")) {
+		t.Fatalf("wrong timeout %d after loading", ttl)
+	}
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "set", "k", "value")
+	if r := execCommand(s, c, "save"); r != "+OK\r\n" {
+		t.Fatalf("save replies %q", r)
+	}
+	content, err := ioutil.ReadFile(s.SnapshotFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)-12] ^= 0xff
+	ioutil.WriteFile(s.SnapshotFileName, content, 0644)
+	if _, err := reloadServer(s, false); err == nil {
+		t.Fatal("corrupted snapshot should be refused")
+	}
+}
+
+func TestSnapshotCorruptedLength(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "set", "k", "value")
+	if r := execCommand(s, c, "save"); r != "+OK\r\n" {
+		t.Fatalf("save replies %q", r)
+	}
+	content, err := ioutil.ReadFile(s.SnapshotFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the length of value is replaced with 256MB
+	huge := make([]byte, binary.MaxVarintLen64)
+	huge = huge[:binary.PutUvarint(huge, 256<<20)]
+	content = bytes.Replace(content, []byte("\x05value"), append(huge, "value"...), 1)
+	ioutil.WriteFile(s.SnapshotFileName, content, 0644)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := reloadServer(s, false); err == nil {
+		t.Fatal("corrupted snapshot should be refused")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Fatalf("%d bytes are allocated loading the corrupted snapshot", allocated)
+	}
+}
+
+func TestSnapshotWithoutMatchedSCF(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	execCommand(s, c, "incr", "counter")
+	execCommand(s, c, "save")
+	execCommand(s, c, "incr", "counter")
+
+	// scf rewritten after the snapshot is loaded as a whole
+	execCommand(s, c, "bgrewritescf")
+	waitRewriteSCF(t, s)
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := execCommand(loaded, loaded.CreateClient(nil), "get", "counter"); r != "$1\r\n2\r\n" {
+		t.Fatalf("counter is %q after loading", r)
+	}
+
+	// the lost scf is rebuilt from the snapshot
+	os.Remove(s.SCFFileName)
+	loaded, err = reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRewriteSCF(t, loaded)
+	os.Remove(s.SnapshotFileName)
+	loaded, err = reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := execCommand(loaded, loaded.CreateClient(nil), "get", "counter"); r != "$1\r\n1\r\n" {
+		t.Fatalf("counter is %q after loading", r)
+	}
+}
+
+func TestSnapshotPointInTime(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	const keys = 5000
+	for i := 0; i < keys; i++ {
+		execCommand(s, c, "set", "k"+strconv.Itoa(i), "v")
+	}
+	execCommand(s, c, "rpush", "list", "a")
+	execCommand(s, c, "set", "ttl", "v", "px", "100000")
+	execCommand(s, c, "select", "1")
+	execCommand(s, c, "set", "k", "db1")
+	execCommand(s, c, "select", "0")
+
+	s.mu.Lock()
+	snaps, info := s.startSnapshots()
+	s.mu.Unlock()
+
+	// the changes between the batches don't go into the snapshots
+	for i := 0; i < keys; i += 100 {
+		execCommand(s, c, "set", "k"+strconv.Itoa(i), "changed")
+		execCommand(s, c, "del", "k"+strconv.Itoa(i+1))
+		execCommand(s, c, "set", "new"+strconv.Itoa(i), "v")
+		s.mu.Lock()
+		snaps[0].copyBatch()
+		s.mu.Unlock()
+	}
+	execCommand(s, c, "rpush", "list", "b")
+	execCommand(s, c, "persist", "ttl")
+	execCommand(s, c, "select", "1")
+	execCommand(s, c, "flushdb")
+	if err := writeSnapshot(s.SnapshotFileName, s.copySnapshots(snaps), info); err != nil {
+		t.Fatal(err)
+	}
+	for _, db := range s.Db {
+		if len(db.snapshots) != 0 {
+			t.Fatalf("db %d still has %d snapshots", db.ID, len(db.snapshots))
+		}
+	}
+
+	loaded := NewServer(&GodisConfig{})
+	if _, err := loadSnapshot(s.SnapshotFileName, loaded.Db); err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"dbsize", ":" + strconv.Itoa(keys+2) + "\r\n"},
+		{"get k0", "$1\r\nv\r\n"},
+		{"get k1", "$1\r\nv\r\n"},
+		{"exists new0", ":0\r\n"},
+		{"lrange list 0 -1", "*1\r\n$1\r\na\r\n"},
+		{"pttl ttl", ":-1\r\n"},
+		{"select 1", "+OK\r\n"},
+		{"get k", "$3\r\ndb1\r\n"},
+	} {
+		r := execCommand(loaded, lc, strings.Fields(tc.cmd)...)
+		if tc.cmd == "pttl ttl" {
+			if r == tc.expect || r == ":-2\r\n" {
+				t.Fatalf("timeout of ttl is lost, pttl replies %q", r)
+			}
+			continue
+		}
+		if r != tc.expect {
+			t.Fatalf("%s replies %q after loading, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+// TestBgSaveLockHold checks that BGSAVE only holds the lock for a batch of keys at a time,
+// instead of the whole copy of a large keyspace
+func TestBgSaveLockHold(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	const keys = 200000
+	for i := 0; i < keys; i++ {
+		s.Db[0].Add(NewObject(OBJString, "k"+strconv.Itoa(i)), NewObject(OBJSDS, SdsNewString("v")))
+	}
+
+	// SAVE holds the lock for the whole copy and write
+	start := time.Now()
+	if r := execCommand(s, c, "save"); r != "+OK\r\n" {
+		t.Fatalf("save replies %q", r)
+	}
+	save := time.Since(start)
+
+	// BGSAVE holds it to start the snapshots
+	start = time.Now()
+	if r := execCommand(s, c, "bgsave"); r != "+Background saving started\r\n" {
+		t.Fatalf("bgsave replies %q", r)
+	}
+	bgsave := time.Since(start)
+	waitBgsave(t, s)
+
+	// and then to copy every batch in background
+	s.mu.Lock()
+	snaps, _ := s.startSnapshots()
+	s.mu.Unlock()
+	var longest time.Duration
+	for done := false; !done; {
+		s.mu.Lock()
+		start := time.Now()
+		done = snaps[0].copyBatch()
+		if d := time.Since(start); d > longest {
+			longest = d
+		}
+		s.mu.Unlock()
+	}
+	s.copySnapshots(snaps)
+
+	t.Logf("%d keys: save %v, bgsave command %v, longest batch %v", keys, save, bgsave, longest)
+	if bgsave > save/10 || longest > save/10 {
+		t.Fatalf("lock is held too long, bgsave command %v, longest batch %v, save %v", bgsave, longest, save)
+	}
+}
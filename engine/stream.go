@@ -0,0 +1,1498 @@
+package godis
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamID is the id of a stream entry, ms is the unix time in milliseconds and
+// seq tells apart the entries added in the same millisecond
+type streamID struct {
+	ms  uint64
+	seq uint64
+}
+
+var streamMaxID = streamID{math.MaxUint64, math.MaxUint64}
+
+// compare return 1 if id is larger than other, -1 smaller, 0 equal
+func (id streamID) compare(other streamID) int {
+	switch {
+	case id.ms < other.ms:
+		return -1
+	case id.ms > other.ms:
+		return 1
+	case id.seq < other.seq:
+		return -1
+	case id.seq > other.seq:
+		return 1
+	}
+	return 0
+}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+// incr return the smallest id larger than id, false is returned if id is the max
+func (id streamID) incr() (streamID, bool) {
+	if id.seq < math.MaxUint64 {
+		return streamID{id.ms, id.seq + 1}, true
+	}
+	if id.ms < math.MaxUint64 {
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// decr return the largest id smaller than id, false is returned if id is 0-0
+func (id streamID) decr() (streamID, bool) {
+	if id.seq > 0 {
+		return streamID{id.ms, id.seq - 1}, true
+	}
+	if id.ms > 0 {
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses id in the form of ms-seq, seq is missingSeq if it's omitted
+func parseStreamID(str string, missingSeq uint64) (streamID, bool) {
+	msPart, seqPart := str, ""
+	if i := strings.IndexByte(str, '-'); i != -1 {
+		msPart, seqPart = str[:i], str[i+1:]
+	}
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	if seqPart == "" && len(msPart) == len(str) {
+		return streamID{ms, missingSeq}, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, false
+	}
+	return streamID{ms, seq}, true
+}
+
+// addReplyInvalidStreamID replies the error when an argument isn't a stream id
+func addReplyInvalidStreamID(c *Client) {
+	addReplyError(c, "ERR Invalid stream ID specified as stream command argument")
+}
+
+// parseStreamIDOrReply parses id like parseStreamID, reply error to client if it's invalid
+func parseStreamIDOrReply(c *Client, o *Object, missingSeq uint64) (streamID, bool) {
+	id, ok := parseStreamID(o.Ptr.(string), missingSeq)
+	if !ok {
+		addReplyInvalidStreamID(c)
+	}
+	return id, ok
+}
+
+// parseStreamRangeIDOrReply parses the start or end of a range, "-" and "+" are the min and max id,
+// seq is 0 for start and the max for end if it's omitted, and the id prefixed with "(" is exclusive
+func parseStreamRangeIDOrReply(c *Client, o *Object, isStart bool) (streamID, bool) {
+	str := o.Ptr.(string)
+	switch str {
+	case "-":
+		return streamID{}, true
+	case "+":
+		return streamMaxID, true
+	}
+
+	var missingSeq uint64
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+	if !strings.HasPrefix(str, "(") {
+		return parseStreamIDOrReply(c, o, missingSeq)
+	}
+
+	id, ok := parseStreamID(str[1:], missingSeq)
+	if !ok {
+		addReplyInvalidStreamID(c)
+		return streamID{}, false
+	}
+	if isStart {
+		if id, ok = id.incr(); !ok {
+			addReplyError(c, "ERR invalid start ID for the interval")
+		}
+	} else if id, ok = id.decr(); !ok {
+		addReplyError(c, "ERR invalid end ID for the interval")
+	}
+	return id, ok
+}
+
+// streamEntry is an entry of stream, entries are never changed after being added
+type streamEntry struct {
+	id     streamID
+	fields []string // fields and values alternately
+}
+
+// Stream is an append only log of entries sorted by id
+type Stream struct {
+	entries []*streamEntry
+	lastID  streamID // id of the latest entry added, it's kept after the entry is deleted
+	groups  map[string]*streamCG
+}
+
+// streamCG is a consumer group of stream
+type streamCG struct {
+	lastID    streamID      // id of the latest entry delivered to the group
+	pel       []*streamNACK // pending entries list of entries delivered but not acknowledged, sorted by id
+	consumers map[string]*streamConsumer
+}
+
+// streamNACK is an entry delivered to a consumer but not acknowledged
+type streamNACK struct {
+	id            streamID
+	deliveryTime  int64 // unix time in milliseconds of the latest delivery
+	deliveryCount uint64
+	consumer      *streamConsumer
+}
+
+// streamConsumer is a consumer of group
+type streamConsumer struct {
+	name     string
+	seenTime int64 // unix time in milliseconds the consumer is active lastly
+	pending  int   // number of entries owned by the consumer in the pel of group
+}
+
+// NewStream create an empty stream
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*streamCG)}
+}
+
+// seek return the index of the first entry whose id isn't less than id
+func (st *Stream) seek(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return st.entries[i].id.compare(id) >= 0
+	})
+}
+
+// lookup return the entry of id, nil if it doesn't exist
+func (st *Stream) lookup(id streamID) *streamEntry {
+	if i := st.seek(id); i < len(st.entries) && st.entries[i].id == id {
+		return st.entries[i]
+	}
+	return nil
+}
+
+// appendEntry adds entry which is larger than the last id
+func (st *Stream) appendEntry(id streamID, fields []string) {
+	st.entries = append(st.entries, &streamEntry{id: id, fields: fields})
+	st.lastID = id
+}
+
+// deleteEntry deletes the entry of id, false is returned if it doesn't exist
+func (st *Stream) deleteEntry(id streamID) bool {
+	i := st.seek(id)
+	if i == len(st.entries) || st.entries[i].id != id {
+		return false
+	}
+	st.entries = append(st.entries[:i], st.entries[i+1:]...)
+	return true
+}
+
+// rangeEntries return the entries from start to end, both of them are inclusive,
+// at most count entries are returned if count isn't 0
+func (st *Stream) rangeEntries(start streamID, end streamID, count int64, rev bool) []*streamEntry {
+	result := make([]*streamEntry, 0)
+	if start.compare(end) > 0 {
+		return result
+	}
+	first, last := st.seek(start), st.seek(end)
+	if last < len(st.entries) && st.entries[last].id == end {
+		last++
+	}
+	for i := first; i < last; i++ {
+		index := i
+		if rev {
+			index = last - 1 - (i - first)
+		}
+		result = append(result, st.entries[index])
+		if count != 0 && int64(len(result)) == count {
+			break
+		}
+	}
+	return result
+}
+
+// dup return a deep copy of stream, entries are shared as they're immutable
+func (st *Stream) dup() *Stream {
+	dst := &Stream{
+		entries: append([]*streamEntry(nil), st.entries...),
+		lastID:  st.lastID,
+		groups:  make(map[string]*streamCG),
+	}
+	for name, cg := range st.groups {
+		group := dst.createGroup(name, cg.lastID)
+		for _, consumer := range cg.consumers {
+			group.consumers[consumer.name] = &streamConsumer{name: consumer.name, seenTime: consumer.seenTime}
+		}
+		for _, nack := range cg.pel {
+			consumer := group.consumers[nack.consumer.name]
+			group.pel = append(group.pel, &streamNACK{
+				id:            nack.id,
+				deliveryTime:  nack.deliveryTime,
+				deliveryCount: nack.deliveryCount,
+				consumer:      consumer,
+			})
+			consumer.pending++
+		}
+	}
+	return dst
+}
+
+// sortedGroupNames return the names of groups in order
+func (st *Stream) sortedGroupNames() []string {
+	names := make([]string, 0, len(st.groups))
+	for name := range st.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// createGroup adds a group which doesn't exist
+func (st *Stream) createGroup(name string, lastID streamID) *streamCG {
+	cg := &streamCG{lastID: lastID, consumers: make(map[string]*streamConsumer)}
+	st.groups[name] = cg
+	return cg
+}
+
+// pelSeek return the index of the first pending entry whose id isn't less than id
+func (cg *streamCG) pelSeek(id streamID) int {
+	return sort.Search(len(cg.pel), func(i int) bool {
+		return cg.pel[i].id.compare(id) >= 0
+	})
+}
+
+// pelLookup return the pending entry of id, nil if it doesn't exist
+func (cg *streamCG) pelLookup(id streamID) *streamNACK {
+	if i := cg.pelSeek(id); i < len(cg.pel) && cg.pel[i].id == id {
+		return cg.pel[i]
+	}
+	return nil
+}
+
+// pelAdd adds a pending entry of id owned by consumer, it must not be in pel
+func (cg *streamCG) pelAdd(id streamID, consumer *streamConsumer, deliveryTime int64, deliveryCount uint64) *streamNACK {
+	nack := &streamNACK{id: id, deliveryTime: deliveryTime, deliveryCount: deliveryCount, consumer: consumer}
+	i := cg.pelSeek(id)
+	cg.pel = append(cg.pel, nil)
+	copy(cg.pel[i+1:], cg.pel[i:])
+	cg.pel[i] = nack
+	consumer.pending++
+	return nack
+}
+
+// pelRemove removes the pending entry of id, false is returned if it doesn't exist
+func (cg *streamCG) pelRemove(id streamID) bool {
+	i := cg.pelSeek(id)
+	if i == len(cg.pel) || cg.pel[i].id != id {
+		return false
+	}
+	cg.pel[i].consumer.pending--
+	cg.pel = append(cg.pel[:i], cg.pel[i+1:]...)
+	return true
+}
+
+// claim transfers nack to consumer
+func (cg *streamCG) claim(nack *streamNACK, consumer *streamConsumer) {
+	nack.consumer.pending--
+	nack.consumer = consumer
+	consumer.pending++
+}
+
+// lookupConsumer return the consumer of name, it's created if create is true and it doesn't exist,
+// the second value returned is true if it's created
+func (cg *streamCG) lookupConsumer(name string, create bool) (*streamConsumer, bool) {
+	if consumer, ok := cg.consumers[name]; ok {
+		return consumer, false
+	}
+	if !create {
+		return nil, false
+	}
+	consumer := &streamConsumer{name: name, seenTime: mstime()}
+	cg.consumers[name] = consumer
+	return consumer, true
+}
+
+// deleteConsumer deletes the consumer and its pending entries, return the number of the pending entries
+func (cg *streamCG) deleteConsumer(consumer *streamConsumer) int {
+	pending := consumer.pending
+	pel := cg.pel[:0]
+	for _, nack := range cg.pel {
+		if nack.consumer != consumer {
+			pel = append(pel, nack)
+		}
+	}
+	cg.pel = pel
+	delete(cg.consumers, consumer.name)
+	return pending
+}
+
+// streamLookupRead return the stream stored at key and whether the type of key is right,
+// the stream is nil if key doesn't exist
+func streamLookupRead(c *Client, key *Object) (*Stream, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
+	}
+	if !checkType(c, value, OBJStream) {
+		return nil, false
+	}
+	return value.Ptr.(*Stream), true
+}
+
+// streamGroupLookupOrReply return the stream at key and its group, reply error to client if either doesn't exist
+func streamGroupLookupOrReply(c *Client, key *Object, groupName string) (*Stream, *streamCG, bool) {
+	st, ok := streamLookupRead(c, key)
+	if !ok {
+		return nil, nil, false
+	}
+	if st != nil {
+		if cg, ok := st.groups[groupName]; ok {
+			return st, cg, true
+		}
+	}
+	addReplyError(c, "NOGROUP No such key '"+key.Ptr.(string)+"' or consumer group '"+groupName+"'")
+	return nil, nil, false
+}
+
+// streamEntryReply return the reply of entry, fields are null if the entry is deleted
+func streamEntryReply(id streamID, entry *streamEntry) *EncodeData {
+	if entry == nil {
+		return NewMultiBulk([]*EncodeData{NewBulk([]byte(id.String())), NewMultiBulk(nil)})
+	}
+	fields := make([]*EncodeData, 0, len(entry.fields))
+	for _, field := range entry.fields {
+		fields = append(fields, NewBulk([]byte(field)))
+	}
+	return NewMultiBulk([]*EncodeData{NewBulk([]byte(id.String())), NewMultiBulk(fields)})
+}
+
+// streamEntriesReply return the reply of entries
+func streamEntriesReply(entries []*streamEntry) *EncodeData {
+	array := make([]*EncodeData, 0, len(entries))
+	for _, entry := range entries {
+		array = append(array, streamEntryReply(entry.id, entry))
+	}
+	return NewMultiBulk(array)
+}
+
+// strategies of trimming streams
+const (
+	STREAM_TRIM_NONE = iota
+	STREAM_TRIM_MAXLEN
+	STREAM_TRIM_MINID
+)
+
+// streamTrimArgs is how XADD and XTRIM trim the stream, entries are always trimmed exactly,
+// which is allowed by "~" meaning at least so many entries are kept
+type streamTrimArgs struct {
+	strategy int
+	maxLen   int64
+	minID    streamID
+	approx   bool
+	limit    int64 // max number of entries deleted, 0 means no limit
+}
+
+// parseStreamTrimOptionOrReply parses the trim option at c.Argv[i] if there is one,
+// it return the index of the last argument parsed and whether it's a trim option
+func parseStreamTrimOptionOrReply(c *Client, i int, args *streamTrimArgs) (int, bool, bool) {
+	opt := strings.ToLower(c.Argv[i].Ptr.(string))
+	remaining := c.Argc - i - 1
+	switch {
+	case opt == "limit" && remaining >= 1:
+		limit, ok := getInt64FromObjectOrReply(c, c.Argv[i+1])
+		if !ok {
+			return i, true, false
+		}
+		if limit < 0 {
+			addReplyError(c, "ERR The LIMIT argument must be >= 0.")
+			return i, true, false
+		}
+		args.limit = limit
+		return i + 1, true, true
+	case (opt == "maxlen" || opt == "minid") && remaining >= 1:
+		if args.strategy != STREAM_TRIM_NONE {
+			addReplyError(c, "ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+			return i, true, false
+		}
+		i++
+		if next := c.Argv[i].Ptr.(string); (next == "~" || next == "=") && remaining >= 2 {
+			args.approx = next == "~"
+			i++
+		}
+		if opt == "minid" {
+			id, ok := parseStreamIDOrReply(c, c.Argv[i], 0)
+			if !ok {
+				return i, true, false
+			}
+			args.strategy, args.minID = STREAM_TRIM_MINID, id
+			return i, true, true
+		}
+		maxLen, ok := getInt64FromObjectOrReply(c, c.Argv[i])
+		if !ok {
+			return i, true, false
+		}
+		if maxLen < 0 {
+			addReplyError(c, "ERR The MAXLEN argument must be >= 0.")
+			return i, true, false
+		}
+		args.strategy, args.maxLen = STREAM_TRIM_MAXLEN, maxLen
+		return i, true, true
+	}
+	return i, false, true
+}
+
+// checkStreamTrimArgsOrReply checks the trim options parsed
+func checkStreamTrimArgsOrReply(c *Client, args *streamTrimArgs) bool {
+	if args.limit != 0 && !args.approx {
+		addReplyError(c, "ERR syntax error, LIMIT cannot be used without the special ~ option")
+		return false
+	}
+	return true
+}
+
+// trim deletes the entries from head according to args, return the number of entries deleted
+func (st *Stream) trim(args *streamTrimArgs) int64 {
+	var n int
+	switch args.strategy {
+	case STREAM_TRIM_MAXLEN:
+		if int64(len(st.entries)) > args.maxLen {
+			n = len(st.entries) - int(args.maxLen)
+		}
+	case STREAM_TRIM_MINID:
+		n = st.seek(args.minID)
+	}
+	if args.limit != 0 && int64(n) > args.limit {
+		n = int(args.limit)
+	}
+	st.entries = st.entries[n:]
+	return int64(n)
+}
+
+// XAddCommand ...
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func XAddCommand(c *Client, s *Server) {
+	if c.Argc < 5 {
+		addReplyError(c, "ERR wrong number of arguments for 'xadd' command")
+		return
+	}
+
+	trimArgs := &streamTrimArgs{}
+	noMkStream := false
+	i := 2
+	for ; i < c.Argc; i++ {
+		if strings.ToLower(c.Argv[i].Ptr.(string)) == "nomkstream" {
+			noMkStream = true
+			continue
+		}
+		var isTrim, ok bool
+		if i, isTrim, ok = parseStreamTrimOptionOrReply(c, i, trimArgs); !ok {
+			return
+		} else if !isTrim {
+			break
+		}
+	}
+	if !checkStreamTrimArgsOrReply(c, trimArgs) {
+		return
+	}
+	idIndex := i
+	if c.Argc-idIndex-1 < 2 || (c.Argc-idIndex-1)%2 != 0 {
+		addReplyError(c, "ERR wrong number of arguments for 'xadd' command")
+		return
+	}
+
+	// the seq or the whole id is generated if it's "*"
+	idStr := c.Argv[idIndex].Ptr.(string)
+	autoID, autoSeq := idStr == "*", false
+	var id streamID
+	if !autoID {
+		var ok bool
+		if strings.HasSuffix(idStr, "-*") {
+			autoSeq = true
+			id.ms, ok = func() (uint64, bool) {
+				ms, err := strconv.ParseUint(strings.TrimSuffix(idStr, "-*"), 10, 64)
+				return ms, err == nil
+			}()
+		} else {
+			id, ok = parseStreamID(idStr, 0)
+		}
+		if !ok {
+			addReplyInvalidStreamID(c)
+			return
+		}
+		if !autoSeq && id == (streamID{}) {
+			addReplyError(c, "ERR The ID specified in XADD must be greater than 0-0")
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	st, ok := streamLookupRead(c, key)
+	if !ok {
+		return
+	}
+	created := false
+	if st == nil {
+		if noMkStream {
+			addReplyNull(c)
+			return
+		}
+		st = NewStream()
+		created = true
+	}
+
+	switch {
+	case autoID:
+		if st.lastID == streamMaxID {
+			addReplyError(c, "ERR The stream has exhausted the last possible ID, unable to add more items")
+			return
+		}
+		if ms := uint64(mstime()); ms > st.lastID.ms {
+			id = streamID{ms, 0}
+		} else {
+			id, _ = st.lastID.incr()
+		}
+	case autoSeq && id.ms == st.lastID.ms:
+		if st.lastID.seq == math.MaxUint64 {
+			addReplyError(c, "ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			return
+		}
+		id.seq = st.lastID.seq + 1
+	}
+	if id.compare(st.lastID) <= 0 {
+		addReplyError(c, "ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		return
+	}
+
+	fields := make([]string, 0, c.Argc-idIndex-1)
+	for j := idIndex + 1; j < c.Argc; j++ {
+		fields = append(fields, c.Argv[j].Ptr.(string))
+	}
+	st.appendEntry(id, fields)
+	if created {
+		c.Db.Add(key, NewObject(OBJStream, st))
+	} else {
+		c.Db.signalKeyAsReady(key)
+	}
+	st.trim(trimArgs)
+
+	// scf stores the id generated
+	c.Argv[idIndex] = NewObject(OBJString, id.String())
+	s.Dirty++
+	addReplyBulk(c, id.String())
+}
+
+// XLenCommand ...
+func XLenCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'xlen' command")
+		return
+	}
+
+	st, ok := streamLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if st == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(len(st.entries)))
+}
+
+// XRangeCommand ...
+// XRANGE key start end [COUNT count]
+func XRangeCommand(c *Client, s *Server) {
+	xrangeGenericCommand(c, s, "xrange", false)
+}
+
+// XRevRangeCommand ...
+// XREVRANGE key end start [COUNT count]
+func XRevRangeCommand(c *Client, s *Server) {
+	xrangeGenericCommand(c, s, "xrevrange", true)
+}
+
+func xrangeGenericCommand(c *Client, s *Server, name string, rev bool) {
+	if c.Argc != 4 && c.Argc != 6 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	startArg, endArg := c.Argv[2], c.Argv[3]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, ok := parseStreamRangeIDOrReply(c, startArg, true)
+	if !ok {
+		return
+	}
+	end, ok := parseStreamRangeIDOrReply(c, endArg, false)
+	if !ok {
+		return
+	}
+	var count int64
+	if c.Argc == 6 {
+		if strings.ToLower(c.Argv[4].Ptr.(string)) != "count" {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[5]); !ok {
+			return
+		}
+		if count <= 0 {
+			addReplyNullArray(c)
+			return
+		}
+	}
+
+	st, ok := streamLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if st == nil {
+		addReplyArray(c, []*EncodeData{})
+		return
+	}
+	addReply(c, streamEntriesReply(st.rangeEntries(start, end, count, rev)))
+}
+
+// XDelCommand ...
+// XDEL key id [id ...]
+func XDelCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'xdel' command")
+		return
+	}
+
+	ids := make([]streamID, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		id, ok := parseStreamIDOrReply(c, c.Argv[i], 0)
+		if !ok {
+			return
+		}
+		ids = append(ids, id)
+	}
+	st, ok := streamLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if st == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	var deleted int64
+	for _, id := range ids {
+		if st.deleteEntry(id) {
+			deleted++
+		}
+	}
+	if deleted > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, deleted)
+}
+
+// XTrimCommand ...
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func XTrimCommand(c *Client, s *Server) {
+	if c.Argc < 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'xtrim' command")
+		return
+	}
+
+	trimArgs := &streamTrimArgs{}
+	for i := 2; i < c.Argc; i++ {
+		var isTrim, ok bool
+		if i, isTrim, ok = parseStreamTrimOptionOrReply(c, i, trimArgs); !ok {
+			return
+		} else if !isTrim {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+	if trimArgs.strategy == STREAM_TRIM_NONE {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	if !checkStreamTrimArgsOrReply(c, trimArgs) {
+		return
+	}
+
+	st, ok := streamLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if st == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	deleted := st.trim(trimArgs)
+	if deleted > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, deleted)
+}
+
+// getStreamBlockTimeoutOrReply parses the timeout in milliseconds of BLOCK,
+// the zero time returned means blocking forever
+func getStreamBlockTimeoutOrReply(c *Client, o *Object) (time.Time, bool) {
+	timeout, err := strconv.ParseInt(o.Ptr.(string), 10, 64)
+	if err != nil {
+		addReplyError(c, "ERR timeout is not an integer or out of range")
+		return time.Time{}, false
+	}
+	if timeout < 0 {
+		addReplyError(c, "ERR timeout is negative")
+		return time.Time{}, false
+	}
+	if timeout == 0 {
+		return time.Time{}, true
+	}
+	return time.Now().Add(time.Duration(timeout) * time.Millisecond), true
+}
+
+// XReadCommand ...
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func XReadCommand(c *Client, s *Server) {
+	xreadGenericCommand(c, s, "xread", false)
+}
+
+// XReadGroupCommand ...
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func XReadGroupCommand(c *Client, s *Server) {
+	xreadGenericCommand(c, s, "xreadgroup", true)
+}
+
+// xreadGenericCommand reads entries after the ids from streams, entries are delivered to the consumer of group
+// if the command is XREADGROUP, ">" means the entries never delivered to the group and other ids mean
+// the pending entries of the consumer
+func xreadGenericCommand(c *Client, s *Server, name string, group bool) {
+	var count int64
+	var deadline time.Time
+	blocking, noAck := false, false
+	groupName, consumerName := "", ""
+	streamsIndex := 0
+	for i := 1; i < c.Argc && streamsIndex == 0; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		remaining := c.Argc - i - 1
+		var ok bool
+		switch {
+		case opt == "count" && remaining >= 1:
+			i++
+			if count, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			if count < 0 {
+				count = 0
+			}
+		case opt == "block" && remaining >= 1:
+			i++
+			if deadline, ok = getStreamBlockTimeoutOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			blocking = true
+		case opt == "streams" && remaining >= 1:
+			streamsIndex = i + 1
+		case opt == "group" && group && remaining >= 2:
+			groupName, consumerName = c.Argv[i+1].Ptr.(string), c.Argv[i+2].Ptr.(string)
+			i += 2
+		case opt == "noack" && group:
+			noAck = true
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+	if streamsIndex == 0 {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	if (c.Argc-streamsIndex)%2 != 0 {
+		addReplyError(c, "ERR Unbalanced '"+name+"' list of streams: for each stream key an ID or '$' must be specified.")
+		return
+	}
+	if group && groupName == "" {
+		addReplyError(c, "ERR Missing GROUP option for XREADGROUP")
+		return
+	}
+
+	numKeys := (c.Argc - streamsIndex) / 2
+	keys := c.Argv[streamsIndex : streamsIndex+numKeys]
+	// ids are the ids after which entries are read, or nil for ">"
+	ids := make([]*streamID, numKeys)
+	for i, key := range keys {
+		idStr := c.Argv[streamsIndex+numKeys+i].Ptr.(string)
+		st, ok := streamLookupRead(c, key)
+		if !ok {
+			return
+		}
+		if group {
+			if st == nil || st.groups[groupName] == nil {
+				addReplyError(c, "NOGROUP No such key '"+key.Ptr.(string)+"' or consumer group '"+groupName+
+					"' in XREADGROUP with GROUP option")
+				return
+			}
+			if idStr == ">" {
+				continue
+			}
+			if idStr == "$" {
+				addReplyError(c, "ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history "+
+					"of this consumer by specifying a proper ID, or use the > ID to get new messages. "+
+					"The $ ID would just return an empty result set.")
+				return
+			}
+		} else if idStr == "$" {
+			id := streamID{}
+			if st != nil {
+				id = st.lastID
+			}
+			ids[i] = &id
+			continue
+		}
+		id, ok := parseStreamIDOrReply(c, c.Argv[streamsIndex+numKeys+i], 0)
+		if !ok {
+			return
+		}
+		ids[i] = &id
+	}
+
+	// read reads the entries of key, the result is nil if nothing is read from it
+	read := func(c *Client, s *Server, i int) *EncodeData {
+		st, _ := streamLookupRead(c, keys[i])
+		if st == nil {
+			return nil
+		}
+		var entries *EncodeData
+		if group {
+			cg := st.groups[groupName]
+			consumer, created := cg.lookupConsumer(consumerName, true)
+			consumer.seenTime = mstime()
+			if created {
+				alsoPropagate(c, "xgroup", "createconsumer", keys[i].Ptr.(string), groupName, consumerName)
+				s.Dirty++
+			}
+			if ids[i] == nil {
+				entries = streamDeliverNewEntries(c, s, keys[i], st, groupName, consumer, count, noAck)
+			} else {
+				// the history of consumer is replied even if it's empty
+				entries = streamDeliverPendingEntries(c, s, keys[i], st, groupName, consumer, *ids[i], count)
+			}
+		} else if start, ok := ids[i].incr(); ok {
+			if result := st.rangeEntries(start, streamMaxID, count, false); len(result) > 0 {
+				entries = streamEntriesReply(result)
+			}
+		}
+		if entries == nil {
+			return nil
+		}
+		return NewMultiBulk([]*EncodeData{NewBulk([]byte(keys[i].Ptr.(string))), entries})
+	}
+
+	array := make([]*EncodeData, 0)
+	for i := range keys {
+		if result := read(c, s, i); result != nil {
+			array = append(array, result)
+		}
+	}
+	if len(array) > 0 {
+		addReplyArray(c, array)
+		return
+	}
+
+	// only reading new entries blocks
+	for _, id := range ids {
+		if group && id != nil {
+			blocking = false
+		}
+	}
+	if !blocking {
+		addReplyNullArray(c)
+		return
+	}
+
+	indexOf := func(key *Object) int {
+		for i, k := range keys {
+			if k.Ptr.(string) == key.Ptr.(string) {
+				return i
+			}
+		}
+		return -1
+	}
+	serve := func(c *Client, s *Server, key *Object) {
+		if group && lookupKey(c, key).Ptr.(*Stream).groups[groupName] == nil {
+			addReplyError(c, "NOGROUP the consumer group this client was blocked on no longer exists")
+			return
+		}
+		// the entries may be deleted before the client is served
+		if result := read(c, s, indexOf(key)); result != nil {
+			addReplyArray(c, []*EncodeData{result})
+		} else {
+			addReplyNullArray(c)
+		}
+	}
+	blockForKeys(c, keys, OBJStream, deadline, serve, addReplyNullArray)
+	c.blocked.ready = func(key *Object, value *Object) bool {
+		st := value.Ptr.(*Stream)
+		if !group {
+			return st.lastID.compare(*ids[indexOf(key)]) > 0
+		}
+		cg := st.groups[groupName]
+		// the client is woken up to know the group is destroyed
+		return cg == nil || st.lastID.compare(cg.lastID) > 0
+	}
+}
+
+// streamDeliverNewEntries delivers the entries never delivered to the group to consumer,
+// nil is returned if there is no such entry
+func streamDeliverNewEntries(c *Client, s *Server, key *Object, st *Stream, groupName string,
+	consumer *streamConsumer, count int64, noAck bool) *EncodeData {
+	cg := st.groups[groupName]
+	start, ok := cg.lastID.incr()
+	if !ok {
+		return nil
+	}
+	entries := st.rangeEntries(start, streamMaxID, count, false)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	k := key.Ptr.(string)
+	now := mstime()
+	cg.lastID = entries[len(entries)-1].id
+	for _, entry := range entries {
+		if noAck {
+			continue
+		}
+		// the entry may be delivered again after the last id of group is set to a smaller one
+		nack := cg.pelLookup(entry.id)
+		if nack == nil {
+			nack = cg.pelAdd(entry.id, consumer, now, 1)
+		} else {
+			cg.claim(nack, consumer)
+			nack.deliveryTime, nack.deliveryCount = now, 1
+		}
+		alsoPropagate(c, streamClaimArgv(k, groupName, nack)...)
+	}
+	alsoPropagate(c, "xgroup", "setid", k, groupName, cg.lastID.String())
+	s.Dirty++
+	return streamEntriesReply(entries)
+}
+
+// streamDeliverPendingEntries delivers the pending entries of consumer after id again
+func streamDeliverPendingEntries(c *Client, s *Server, key *Object, st *Stream, groupName string,
+	consumer *streamConsumer, id streamID, count int64) *EncodeData {
+	cg := st.groups[groupName]
+	array := make([]*EncodeData, 0)
+	start, ok := id.incr()
+	if !ok {
+		return NewMultiBulk(array)
+	}
+
+	now := mstime()
+	for i := cg.pelSeek(start); i < len(cg.pel); i++ {
+		nack := cg.pel[i]
+		if nack.consumer != consumer {
+			continue
+		}
+		nack.deliveryTime = now
+		nack.deliveryCount++
+		alsoPropagate(c, streamClaimArgv(key.Ptr.(string), groupName, nack)...)
+		s.Dirty++
+		array = append(array, streamEntryReply(nack.id, st.lookup(nack.id)))
+		if count != 0 && int64(len(array)) == count {
+			break
+		}
+	}
+	return NewMultiBulk(array)
+}
+
+// streamClaimArgv return the XCLAIM which sets nack as it is when replayed
+func streamClaimArgv(key string, groupName string, nack *streamNACK) []string {
+	return []string{"xclaim", key, groupName, nack.consumer.name, "0", nack.id.String(),
+		"time", strconv.FormatInt(nack.deliveryTime, 10),
+		"retrycount", strconv.FormatUint(nack.deliveryCount, 10), "force", "justid"}
+}
+
+// XGroupCommand ...
+// XGROUP CREATE key group id|$ [MKSTREAM]
+// XGROUP SETID key group id|$
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func XGroupCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'xgroup' command")
+		return
+	}
+
+	sub := strings.ToLower(c.Argv[1].Ptr.(string))
+	argc := map[string]int{"create": 5, "setid": 5, "destroy": 4, "createconsumer": 5, "delconsumer": 5}
+	expect, ok := argc[sub]
+	if !ok || c.Argc != expect && !(sub == "create" && c.Argc == 6) {
+		addReplyError(c, "ERR unknown subcommand or wrong number of arguments for '"+c.Argv[1].Ptr.(string)+
+			"'. Try XGROUP HELP.")
+		return
+	}
+	mkStream := false
+	if c.Argc == 6 {
+		if strings.ToLower(c.Argv[5].Ptr.(string)) != "mkstream" {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		mkStream = true
+	}
+
+	key, groupName := c.Argv[2], c.Argv[3].Ptr.(string)
+	st, ok := streamLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if st == nil && !mkStream {
+		addReplyError(c, "ERR The XGROUP subcommand requires the key to exist. "+
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		return
+	}
+
+	// parseGroupID parses the id of CREATE and SETID, "$" is the last id of stream
+	parseGroupID := func() (streamID, bool) {
+		if c.Argv[4].Ptr.(string) == "$" {
+			if st == nil {
+				return streamID{}, true
+			}
+			return st.lastID, true
+		}
+		return parseStreamIDOrReply(c, c.Argv[4], 0)
+	}
+
+	var cg *streamCG
+	if st != nil {
+		cg = st.groups[groupName]
+	}
+	if sub != "create" && sub != "destroy" && cg == nil {
+		addReplyError(c, "NOGROUP No such consumer group '"+groupName+"' for key name '"+key.Ptr.(string)+"'")
+		return
+	}
+
+	switch sub {
+	case "create":
+		id, ok := parseGroupID()
+		if !ok {
+			return
+		}
+		if cg != nil {
+			addReplyError(c, "BUSYGROUP Consumer Group name already exists")
+			return
+		}
+		if st == nil {
+			st = NewStream()
+			c.Db.Add(key, NewObject(OBJStream, st))
+		}
+		st.createGroup(groupName, id)
+		s.Dirty++
+		addReplyStatus(c, "OK")
+	case "setid":
+		id, ok := parseGroupID()
+		if !ok {
+			return
+		}
+		cg.lastID = id
+		s.Dirty++
+		addReplyStatus(c, "OK")
+	case "destroy":
+		if cg == nil {
+			addReplyInt(c, 0)
+			return
+		}
+		delete(st.groups, groupName)
+		// clients blocked on the group are woken up
+		c.Db.signalKeyAsReady(key)
+		s.Dirty++
+		addReplyInt(c, 1)
+	case "createconsumer":
+		if _, created := cg.lookupConsumer(c.Argv[4].Ptr.(string), true); !created {
+			addReplyInt(c, 0)
+			return
+		}
+		s.Dirty++
+		addReplyInt(c, 1)
+	case "delconsumer":
+		consumer, _ := cg.lookupConsumer(c.Argv[4].Ptr.(string), false)
+		if consumer == nil {
+			addReplyInt(c, 0)
+			return
+		}
+		s.Dirty++
+		addReplyInt(c, int64(cg.deleteConsumer(consumer)))
+	}
+}
+
+// XAckCommand ...
+// XACK key group id [id ...]
+func XAckCommand(c *Client, s *Server) {
+	if c.Argc < 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'xack' command")
+		return
+	}
+
+	ids := make([]streamID, 0, c.Argc-3)
+	for i := 3; i < c.Argc; i++ {
+		id, ok := parseStreamIDOrReply(c, c.Argv[i], 0)
+		if !ok {
+			return
+		}
+		ids = append(ids, id)
+	}
+	st, ok := streamLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	var cg *streamCG
+	if st != nil {
+		cg = st.groups[c.Argv[2].Ptr.(string)]
+	}
+	if cg == nil {
+		addReplyInt(c, 0)
+		return
+	}
+
+	var acked int64
+	for _, id := range ids {
+		if cg.pelRemove(id) {
+			acked++
+		}
+	}
+	if acked > 0 {
+		s.Dirty++
+	}
+	addReplyInt(c, acked)
+}
+
+// XPendingCommand ...
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func XPendingCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'xpending' command")
+		return
+	}
+
+	extended := c.Argc > 3
+	var minIdle, count int64
+	var start, end streamID
+	consumerName := ""
+	if extended {
+		i := 3
+		if strings.ToLower(c.Argv[3].Ptr.(string)) == "idle" && c.Argc > 4 {
+			var ok bool
+			if minIdle, ok = getInt64FromObjectOrReply(c, c.Argv[4]); !ok {
+				return
+			}
+			i = 5
+		}
+		if c.Argc != i+3 && c.Argc != i+4 {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		var ok bool
+		if start, ok = parseStreamRangeIDOrReply(c, c.Argv[i], true); !ok {
+			return
+		}
+		if end, ok = parseStreamRangeIDOrReply(c, c.Argv[i+1], false); !ok {
+			return
+		}
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[i+2]); !ok {
+			return
+		}
+		if c.Argc == i+4 {
+			consumerName = c.Argv[i+3].Ptr.(string)
+		}
+	}
+
+	_, cg, ok := streamGroupLookupOrReply(c, c.Argv[1], c.Argv[2].Ptr.(string))
+	if !ok {
+		return
+	}
+
+	if !extended {
+		if len(cg.pel) == 0 {
+			addReplyArray(c, []*EncodeData{NewInt([]byte("0")), NewBulk(nil), NewBulk(nil), NewMultiBulk(nil)})
+			return
+		}
+		names := make([]string, 0, len(cg.consumers))
+		for name, consumer := range cg.consumers {
+			if consumer.pending > 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		consumers := make([]*EncodeData, 0, len(names))
+		for _, name := range names {
+			consumers = append(consumers, NewMultiBulk([]*EncodeData{
+				NewBulk([]byte(name)),
+				NewBulk([]byte(strconv.Itoa(cg.consumers[name].pending))),
+			}))
+		}
+		addReplyArray(c, []*EncodeData{
+			NewInt([]byte(strconv.Itoa(len(cg.pel)))),
+			NewBulk([]byte(cg.pel[0].id.String())),
+			NewBulk([]byte(cg.pel[len(cg.pel)-1].id.String())),
+			NewMultiBulk(consumers),
+		})
+		return
+	}
+
+	array := make([]*EncodeData, 0)
+	now := mstime()
+	for i := cg.pelSeek(start); i < len(cg.pel) && int64(len(array)) < count; i++ {
+		nack := cg.pel[i]
+		if nack.id.compare(end) > 0 {
+			break
+		}
+		idle := now - nack.deliveryTime
+		if idle < minIdle || consumerName != "" && nack.consumer.name != consumerName {
+			continue
+		}
+		array = append(array, NewMultiBulk([]*EncodeData{
+			NewBulk([]byte(nack.id.String())),
+			NewBulk([]byte(nack.consumer.name)),
+			NewInt([]byte(strconv.FormatInt(idle, 10))),
+			NewInt([]byte(strconv.FormatUint(nack.deliveryCount, 10))),
+		}))
+	}
+	addReplyArray(c, array)
+}
+
+// XClaimCommand ...
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func XClaimCommand(c *Client, s *Server) {
+	if c.Argc < 6 {
+		addReplyError(c, "ERR wrong number of arguments for 'xclaim' command")
+		return
+	}
+
+	minIdle, err := strconv.ParseInt(c.Argv[4].Ptr.(string), 10, 64)
+	if err != nil || minIdle < 0 {
+		addReplyError(c, "ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+	ids := make([]streamID, 0)
+	i := 5
+	for ; i < c.Argc; i++ {
+		id, ok := parseStreamID(c.Argv[i].Ptr.(string), 0)
+		if !ok {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		addReplyInvalidStreamID(c)
+		return
+	}
+
+	now := mstime()
+	deliveryTime := now
+	var retryCount int64 = -1
+	force, justID := false, false
+	var lastID *streamID
+	for ; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		remaining := c.Argc - i - 1
+		var ok bool
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "idle" && remaining >= 1:
+			i++
+			var idle int64
+			if idle, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			deliveryTime = now - idle
+		case opt == "time" && remaining >= 1:
+			i++
+			if deliveryTime, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+		case opt == "retrycount" && remaining >= 1:
+			i++
+			if retryCount, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+		case opt == "lastid" && remaining >= 1:
+			i++
+			id, ok := parseStreamIDOrReply(c, c.Argv[i], 0)
+			if !ok {
+				return
+			}
+			lastID = &id
+		default:
+			addReplyError(c, "ERR Unrecognized XCLAIM option '"+c.Argv[i].Ptr.(string)+"'")
+			return
+		}
+	}
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	key, groupName := c.Argv[1], c.Argv[2].Ptr.(string)
+	st, cg, ok := streamGroupLookupOrReply(c, key, groupName)
+	if !ok {
+		return
+	}
+	k := key.Ptr.(string)
+	if lastID != nil && lastID.compare(cg.lastID) > 0 {
+		cg.lastID = *lastID
+		alsoPropagate(c, "xgroup", "setid", k, groupName, lastID.String())
+		s.Dirty++
+	}
+	consumer, created := cg.lookupConsumer(c.Argv[3].Ptr.(string), true)
+	consumer.seenTime = now
+	if created {
+		alsoPropagate(c, "xgroup", "createconsumer", k, groupName, consumer.name)
+		s.Dirty++
+	}
+
+	array := make([]*EncodeData, 0, len(ids))
+	for _, id := range ids {
+		nack := cg.pelLookup(id)
+		entry := st.lookup(id)
+		if nack == nil {
+			// only entries existing can be claimed by force
+			if !force || entry == nil {
+				continue
+			}
+			nack = cg.pelAdd(id, consumer, now, 0)
+		} else if entry == nil {
+			// the pending entries deleted from stream are removed
+			cg.pelRemove(id)
+			alsoPropagate(c, "xack", k, groupName, id.String())
+			s.Dirty++
+			continue
+		} else if minIdle > 0 && now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		cg.claim(nack, consumer)
+		nack.deliveryTime = deliveryTime
+		if retryCount != -1 {
+			nack.deliveryCount = uint64(retryCount)
+		} else if !justID {
+			nack.deliveryCount++
+		}
+		alsoPropagate(c, streamClaimArgv(k, groupName, nack)...)
+		s.Dirty++
+		if justID {
+			array = append(array, NewBulk([]byte(id.String())))
+		} else {
+			array = append(array, streamEntryReply(id, entry))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// XAutoClaimCommand ...
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func XAutoClaimCommand(c *Client, s *Server) {
+	if c.Argc < 6 {
+		addReplyError(c, "ERR wrong number of arguments for 'xautoclaim' command")
+		return
+	}
+
+	minIdle, err := strconv.ParseInt(c.Argv[4].Ptr.(string), 10, 64)
+	if err != nil || minIdle < 0 {
+		addReplyError(c, "ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+	start, ok := parseStreamRangeIDOrReply(c, c.Argv[5], true)
+	if !ok {
+		return
+	}
+	var count int64 = 100
+	justID := false
+	for i := 6; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		switch {
+		case opt == "count" && i+1 < c.Argc:
+			i++
+			if count, ok = getInt64FromObjectOrReply(c, c.Argv[i]); !ok {
+				return
+			}
+			if count < 1 || count > math.MaxInt64/10 {
+				addReplyError(c, "ERR COUNT must be > 0")
+				return
+			}
+		case opt == "justid":
+			justID = true
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	key, groupName := c.Argv[1], c.Argv[2].Ptr.(string)
+	st, cg, ok := streamGroupLookupOrReply(c, key, groupName)
+	if !ok {
+		return
+	}
+	k := key.Ptr.(string)
+	now := mstime()
+	consumer, created := cg.lookupConsumer(c.Argv[3].Ptr.(string), true)
+	consumer.seenTime = now
+	if created {
+		alsoPropagate(c, "xgroup", "createconsumer", k, groupName, consumer.name)
+		s.Dirty++
+	}
+
+	// at most count*10 pending entries are scanned
+	attempts := count * 10
+	claimed := make([]*EncodeData, 0)
+	deleted := make([]*EncodeData, 0)
+	i := cg.pelSeek(start)
+	for ; i < len(cg.pel) && attempts > 0 && int64(len(claimed)) < count; attempts-- {
+		nack := cg.pel[i]
+		entry := st.lookup(nack.id)
+		if entry == nil {
+			cg.pelRemove(nack.id)
+			alsoPropagate(c, "xack", k, groupName, nack.id.String())
+			s.Dirty++
+			deleted = append(deleted, NewBulk([]byte(nack.id.String())))
+			continue
+		}
+		i++
+		if now-nack.deliveryTime < minIdle {
+			continue
+		}
+
+		cg.claim(nack, consumer)
+		nack.deliveryTime = now
+		if !justID {
+			nack.deliveryCount++
+		}
+		alsoPropagate(c, streamClaimArgv(k, groupName, nack)...)
+		s.Dirty++
+		if justID {
+			claimed = append(claimed, NewBulk([]byte(nack.id.String())))
+		} else {
+			claimed = append(claimed, streamEntryReply(nack.id, entry))
+		}
+	}
+
+	// the cursor is 0-0 if the whole pel is scanned
+	cursor := streamID{}
+	if i < len(cg.pel) {
+		cursor = cg.pel[i].id
+	}
+	addReplyArray(c, []*EncodeData{NewBulk([]byte(cursor.String())), NewMultiBulk(claimed), NewMultiBulk(deleted)})
+}
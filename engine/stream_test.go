@@ -0,0 +1,347 @@
+package godis
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStreamID(t *testing.T) {
+	for _, tc := range []struct {
+		str        string
+		missingSeq uint64
+		id         streamID
+		ok         bool
+	}{
+		{"1-2", 0, streamID{1, 2}, true},
+		{"5", 0, streamID{5, 0}, true},
+		{"5", 9, streamID{5, 9}, true},
+		{"18446744073709551615-18446744073709551615", 0, streamMaxID, true},
+		{"1-", 0, streamID{}, false},
+		{"-1", 0, streamID{}, false},
+		{"a-1", 0, streamID{}, false},
+		{"1-2-3", 0, streamID{}, false},
+		{"18446744073709551616", 0, streamID{}, false},
+	} {
+		id, ok := parseStreamID(tc.str, tc.missingSeq)
+		if ok != tc.ok || ok && id != tc.id {
+			t.Fatalf("parseStreamID(%q) return %v %v, expect %v %v", tc.str, id, ok, tc.id, tc.ok)
+		}
+	}
+
+	if id, ok := (streamID{1, ^uint64(0)}).incr(); !ok || id != (streamID{2, 0}) {
+		t.Fatalf("incr return %v %v", id, ok)
+	}
+	if _, ok := streamMaxID.incr(); ok {
+		t.Fatal("the max id is increased")
+	}
+	if id, ok := (streamID{2, 0}).decr(); !ok || id != (streamID{1, ^uint64(0)}) {
+		t.Fatalf("decr return %v %v", id, ok)
+	}
+	if _, ok := (streamID{}).decr(); ok {
+		t.Fatal("0-0 is decreased")
+	}
+}
+
+func TestStreamCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"xadd s 1-1 a 1", "$3\r\n1-1\r\n"},
+		{"xadd s 1-* b 2", "$3\r\n1-2\r\n"},
+		{"xadd s 2 c 3 d 4", "$3\r\n2-0\r\n"},
+		{"xadd s 2-0 e 5", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xadd s 1-* e 5", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xadd s2 0-0 a 1", "-ERR The ID specified in XADD must be greater than 0-0\r\n"},
+		{"xadd s 3-x a 1", "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{"xadd s 3 a", "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{"xadd s nomkstream 3 a", "-ERR wrong number of arguments for 'xadd' command\r\n"},
+		{"xadd s2 nomkstream * a 1", "$-1\r\n"},
+		{"xadd s maxlen = 3 limit 1 3 a 1", "-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n"},
+		{"xadd s maxlen 1 minid 1 3 a 1", "-ERR syntax error, MAXLEN and MINID options at the same time are not compatible\r\n"},
+		{"xadd s maxlen -1 3 a 1", "-ERR The MAXLEN argument must be >= 0.\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"xadd str * a 1", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"xlen s", ":3\r\n"},
+		{"xlen nope", ":0\r\n"},
+
+		{"xrange s - +", "*3\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n" +
+			"*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n"},
+		{"xrange s 1 1", "*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"xrange s (1-1 + count 1", "*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"xrange s - (1-2", "*1\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"xrange s - + count 0", "*-1\r\n"},
+		{"xrange s 3 +", "*0\r\n"},
+		{"xrange s 2 1", "*0\r\n"},
+		{"xrange s - + limit 1", "-ERR syntax error\r\n"},
+		{"xrange s (18446744073709551615-18446744073709551615 +", "-ERR invalid start ID for the interval\r\n"},
+		{"xrange nope - +", "*0\r\n"},
+		{"xrevrange s + - count 2", "*2\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n" +
+			"*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"xrevrange s 1-2 (1-1", "*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+
+		{"xdel s 1-2 9-9", ":1\r\n"},
+		{"xdel s 1-2", ":0\r\n"},
+		{"xlen s", ":2\r\n"},
+		{"xadd s 1-5 a 1", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xadd s 3 a 1", "$3\r\n3-0\r\n"},
+		{"xadd s 4 a 1", "$3\r\n4-0\r\n"},
+		{"xtrim s maxlen 3", ":1\r\n"},
+		{"xrange s - + count 1", "*1\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n"},
+		{"xtrim s minid ~ 4 limit 1", ":1\r\n"},
+		{"xtrim s minid 4", ":1\r\n"},
+		{"xtrim s minid 4", ":0\r\n"},
+		{"xtrim s", "-ERR wrong number of arguments for 'xtrim' command\r\n"},
+		{"xtrim s count 1", "-ERR syntax error\r\n"},
+		{"xadd s maxlen 1 5 a 1", "$3\r\n5-0\r\n"},
+		{"xrange s - +", "*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+
+		{"xadd s 9 a 1", "$3\r\n9-0\r\n"},
+		{"xdel s 9", ":1\r\n"},
+		{"xadd s 8 a 1", "-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n"},
+		{"xadd s 9-* a 1", "$3\r\n9-1\r\n"},
+
+		{"xread streams s", "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+		{"xread count 1 streams s nope 0 0", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n5-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"xread streams s 9-1", "*-1\r\n"},
+		{"xread streams s $", "*-1\r\n"},
+		{"xread block x streams s $", "-ERR timeout is not an integer or out of range\r\n"},
+		{"xread block -1 streams s $", "-ERR timeout is negative\r\n"},
+		{"xread streams str 0", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	} {
+		if r := execCommand(s, c, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	st := lookupKeyInDB(c, s.Db[0], NewObject(OBJString, "s")).Ptr.(*Stream)
+	if st.lastID != (streamID{9, 1}) {
+		t.Fatalf("last id %v, expect 9-1", st.lastID)
+	}
+}
+
+// pendingIdle matches the idle time in the entries replied by the extended form of XPENDING
+var pendingIdle = regexp.MustCompile(`:\d+\r\n:`)
+
+func TestStreamGroups(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"xgroup create s g $", "-ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n"},
+		{"xgroup create s g $ mkstream", "+OK\r\n"},
+		{"xgroup create s g 0", "-BUSYGROUP Consumer Group name already exists\r\n"},
+		{"xgroup setid s nope 0", "-NOGROUP No such consumer group 'nope' for key name 's'\r\n"},
+		{"xgroup create s g", "-ERR unknown subcommand or wrong number of arguments for 'create'. Try XGROUP HELP.\r\n"},
+		{"xlen s", ":0\r\n"},
+		{"xadd s 1 a 1", "$3\r\n1-0\r\n"},
+		{"xadd s 2 b 2", "$3\r\n2-0\r\n"},
+		{"xadd s 3 c 3", "$3\r\n3-0\r\n"},
+		{"xreadgroup group g alice streams s", "-ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '$' must be specified.\r\n"},
+		{"xreadgroup group nope alice streams s >", "-NOGROUP No such key 's' or consumer group 'nope' in XREADGROUP with GROUP option\r\n"},
+		{"xreadgroup group g alice streams s $", "-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n"},
+		{"xreadgroup count 2 group g alice streams s >", "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n" +
+			"*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"xreadgroup group g bob streams s >", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{"xreadgroup group g bob streams s >", "*-1\r\n"},
+		{"xreadgroup group g bob block 10 streams s 0", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{"xreadgroup group g carol streams s 0", "*1\r\n*2\r\n$1\r\ns\r\n*0\r\n"},
+		{"xpending s g", "*4\r\n:3\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*2\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n*2\r\n$3\r\nbob\r\n$1\r\n1\r\n"},
+		{"xpending s g - + 10 bob", "*1\r\n*4\r\n$3\r\n3-0\r\n$3\r\nbob\r\n:0\r\n:2\r\n"},
+		{"xpending s g idle 100000 - + 10", "*0\r\n"},
+		{"xpending s nope", "-NOGROUP No such key 's' or consumer group 'nope'\r\n"},
+		{"xack s g 1-0 1-0 9-0", ":1\r\n"},
+		{"xdel s 2", ":1\r\n"},
+		{"xreadgroup group g alice streams s 0", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*-1\r\n"},
+
+		{"xclaim s g carol 100000 3", "*0\r\n"},
+		{"xclaim s g carol 0 3 retrycount 5 justid", "*1\r\n$3\r\n3-0\r\n"},
+		{"xpending s g - + 10 carol", "*1\r\n*4\r\n$3\r\n3-0\r\n$5\r\ncarol\r\n:0\r\n:5\r\n"},
+		{"xclaim s g carol 0 1 force", "*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"xclaim s g carol 0 9 force", "*0\r\n"},
+		{"xclaim s g carol 0 2", "*0\r\n"},
+		{"xclaim s g carol 0 x", "-ERR Invalid stream ID specified as stream command argument\r\n"},
+		{"xclaim s g carol 0 1 bad", "-ERR Unrecognized XCLAIM option 'bad'\r\n"},
+		{"xpending s g", "*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$5\r\ncarol\r\n$1\r\n2\r\n"},
+		{"xautoclaim s g dave 0 0 count 1", "*3\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*0\r\n"},
+		{"xautoclaim s g dave 0 3 justid", "*3\r\n$3\r\n0-0\r\n*1\r\n$3\r\n3-0\r\n*0\r\n"},
+		{"xautoclaim s g dave 0 0 count 0", "-ERR COUNT must be > 0\r\n"},
+
+		{"xgroup createconsumer s g erin", ":1\r\n"},
+		{"xgroup createconsumer s g erin", ":0\r\n"},
+		{"xgroup delconsumer s g dave", ":2\r\n"},
+		{"xgroup delconsumer s g dave", ":0\r\n"},
+		{"xpending s g", "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"},
+		{"xgroup setid s g 0", "+OK\r\n"},
+		{"xreadgroup group g erin noack streams s >", "*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n" +
+			"*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{"xpending s g", "*4\r\n:0\r\n$-1\r\n$-1\r\n*-1\r\n"},
+		{"xgroup destroy s g", ":1\r\n"},
+		{"xgroup destroy s g", ":0\r\n"},
+		{"xack s g 1", ":0\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if strings.HasPrefix(tc.cmd, "xpending s g - +") {
+			// the idle time depends on the clock, it's expected to be 0 after being truncated to seconds
+			r = pendingIdle.ReplaceAllStringFunc(r, func(idle string) string {
+				ms, _ := strconv.ParseInt(idle[1:len(idle)-3], 10, 64)
+				return ":" + strconv.FormatInt(ms/1000, 10) + "\r\n:"
+			})
+		}
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+func TestBlockingStreamReads(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	waiters := make([]*Client, 4)
+	for i := range waiters {
+		waiters[i] = s.CreateClient(nil)
+	}
+	for _, tc := range []struct {
+		c      *Client
+		cmd    string
+		expect string
+	}{
+		{c, "xadd s 1 a 1", "$3\r\n1-0\r\n"},
+		{c, "xgroup create s g $", "+OK\r\n"},
+		{waiters[0], "xread block 0 streams s $", ""},
+		{waiters[1], "xread block 0 streams nope s 0 1", ""},
+		{waiters[2], "xreadgroup group g alice block 0 streams s >", ""},
+		{waiters[3], "xreadgroup group g bob block 0 streams s >", ""},
+		{c, "xadd s 2 b 2", "$3\r\n2-0\r\n"},
+		{waiters[0], "", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{waiters[1], "", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{waiters[2], "", "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{waiters[3], "", ""},
+		{c, "xgroup destroy s g", ":1\r\n"},
+		{waiters[3], "", "-NOGROUP the consumer group this client was blocked on no longer exists\r\n"},
+		{c, "xpending s g", "-NOGROUP No such key 's' or consumer group 'g'\r\n"},
+	} {
+		if tc.cmd == "" {
+			if r := *tc.c.Buf.SdsGetString(); r != tc.expect {
+				t.Fatalf("blocked client receives %q, expect %q", r, tc.expect)
+			}
+			continue
+		}
+		r := execCommand(s, tc.c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+	for _, db := range s.Db {
+		if len(db.blockingKeys) != 0 {
+			t.Fatalf("clients are still blocked on %v", db.blockingKeys)
+		}
+	}
+}
+
+func TestBlockingStreamReadConnection(t *testing.T) {
+	_, addr, stop := newTestServer(t)
+	defer stop()
+
+	waiter := dialTestServer(t, addr)
+	defer waiter.conn.Close()
+	if r, err := waiter.do("xread", "block", "50", "streams", "s", "$"); err != nil || r.Type != TypeMultiBulk || r.Array != nil {
+		t.Fatalf("xread replies %+v after timeout, err:%v", r, err)
+	}
+}
+
+// streamState return the content of stream which is persisted
+func streamState(st *Stream) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v", st.lastID)
+	for _, entry := range st.entries {
+		fmt.Fprintf(&b, " %v:%v", entry.id, entry.fields)
+	}
+	for _, name := range st.sortedGroupNames() {
+		cg := st.groups[name]
+		fmt.Fprintf(&b, " group %s %v %d consumers", name, cg.lastID, len(cg.consumers))
+		for _, nack := range cg.pel {
+			fmt.Fprintf(&b, " %v:%s:%d:%d", nack.id, nack.consumer.name, nack.deliveryTime, nack.deliveryCount)
+		}
+	}
+	return b.String()
+}
+
+func TestStreamPersistence(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, cmd := range []string{
+		"xadd s * a 1",
+		"xadd s * b 2 c 3",
+		"xadd s * d 4",
+		"xadd s maxlen 5 * e 5",
+		"xgroup create s g 0",
+		"xgroup create s g2 $",
+		"xgroup createconsumer s g2 idle",
+		"xreadgroup group g alice count 2 streams s >",
+		"xreadgroup group g bob streams s >",
+		"xack s g 1",
+		"xdel s 0-0",
+		"xgroup create empty g $ mkstream",
+		"xadd trimmed 7 a 1",
+		"xtrim trimmed maxlen 0",
+		"xadd deleted 5 a 1",
+		"xadd deleted 6 b 2",
+		"xdel deleted 6",
+	} {
+		if r := execCommand(s, c, strings.Fields(cmd)...); strings.HasPrefix(r, "-") {
+			t.Fatalf("%s replies %q", cmd, r)
+		}
+	}
+	// the entry acked can't be known before reading
+	first := lookupKeyInDB(c, s.Db[0], NewObject(OBJString, "s")).Ptr.(*Stream).entries[0].id.String()
+	execCommand(s, c, "xack", "s", "g", first)
+	execCommand(s, c, "xdel", "s", first)
+
+	check := func(stage string) {
+		loaded, err := reloadServer(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lc := loaded.CreateClient(nil)
+		for _, key := range []string{"s", "empty", "trimmed", "deleted"} {
+			k := NewObject(OBJString, key)
+			expect := streamState(lookupKeyInDB(c, s.Db[0], k).Ptr.(*Stream))
+			value := lookupKeyInDB(lc, loaded.Db[0], k)
+			if value == nil || value.ObjectType != OBJStream {
+				t.Fatalf("%s is %v after loading %s", key, value, stage)
+			}
+			if state := streamState(value.Ptr.(*Stream)); state != expect {
+				t.Fatalf("%s is %q after loading %s, expect %q", key, state, stage, expect)
+			}
+		}
+	}
+	check("scf")
+
+	s.mu.Lock()
+	s.startRewriteSCF()
+	s.mu.Unlock()
+	waitRewriteSCF(t, s)
+	check("rewritten scf")
+
+	if r := execCommand(s, c, "save"); r != "+OK\r\n" {
+		t.Fatalf("save replies %q", r)
+	}
+	check("snapshot")
+}
@@ -0,0 +1,536 @@
+package godis
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// checkStringLengthOrReply reply error to client if a string of size plus appended bytes is too large,
+// they are not added up since a large size such as the offset of SETRANGE may overflow
+func checkStringLengthOrReply(c *Client, size int64, appended int64) bool {
+	if size > MaxBulkLen-appended {
+		addReplyError(c, "ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+		return false
+	}
+	return true
+}
+
+// stringLookupRead return the string stored at key and whether the type of key is right,
+// the string is nil if key doesn't exist
+func stringLookupRead(c *Client, key *Object) (*Sdshdr, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
+	}
+	if !checkType(c, value, OBJSDS) {
+		return nil, false
+	}
+	return value.Ptr.(*Sdshdr), true
+}
+
+// addReplyStringOrNull replies str, or null if it's nil
+func addReplyStringOrNull(c *Client, str *Sdshdr) {
+	if str == nil {
+		addReplyNull(c)
+		return
+	}
+	addReplyBulkSds(c, str)
+}
+
+// getExpireTimeOrReply converts the argument of EX, PX, EXAT or PXAT into the unix time in milliseconds
+func getExpireTimeOrReply(c *Client, name string, opt string, o *Object) (int64, bool) {
+	v, ok := getInt64FromObjectOrReply(c, o)
+	if !ok {
+		return 0, false
+	}
+	if v <= 0 {
+		addReplyError(c, "ERR invalid expire time in '"+name+"' command")
+		return 0, false
+	}
+	// the timeout overflowing int64 is invalid rather than a time in the past
+	basetime, unit := int64(0), int64(1)
+	if opt == "ex" || opt == "px" {
+		basetime = mstime()
+	}
+	if opt == "ex" || opt == "exat" {
+		unit = 1000
+	}
+	if v > (math.MaxInt64-basetime)/unit {
+		addReplyError(c, "ERR invalid expire time in '"+name+"' command")
+		return 0, false
+	}
+	return basetime + v*unit, true
+}
+
+// SetCommand ...
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
+func SetCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'set' command")
+		return
+	}
+
+	var when int64 = -1
+	keepTTL, nx, xx, get := false, false, false, false
+	for i := 3; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		switch {
+		case opt == "nx" && !xx:
+			nx = true
+		case opt == "xx" && !nx:
+			xx = true
+		case opt == "get":
+			get = true
+		case opt == "keepttl" && when == -1:
+			keepTTL = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && !keepTTL && when == -1 && i+1 < c.Argc:
+			i++
+			var ok bool
+			if when, ok = getExpireTimeOrReply(c, "set", opt, c.Argv[i]); !ok {
+				return
+			}
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	var old *Sdshdr
+	if get {
+		var ok bool
+		if old, ok = stringLookupRead(c, key); !ok {
+			return
+		}
+	}
+	if nx || xx {
+		exists := lookupKey(c, key) != nil
+		if nx && exists || xx && !exists {
+			if get {
+				addReplyStringOrNull(c, old)
+			} else {
+				addReplyNull(c)
+			}
+			return
+		}
+	}
+
+	c.Db.SetKey(key, NewObject(OBJSDS, SdsNewString(c.Argv[2].Ptr.(string))), keepTTL)
+	if when != -1 {
+		c.Db.SetExpire(key, when)
+		// store the absolute time in scf so that replay sets the same timeout
+		rewriteClientCommandArgv(c, "set", key.Ptr.(string), c.Argv[2].Ptr.(string), "pxat", strconv.FormatInt(when, 10))
+	}
+	s.Dirty++
+	if get {
+		addReplyStringOrNull(c, old)
+	} else {
+		addReplyStatus(c, "OK")
+	}
+}
+
+// SetNXCommand ...
+func SetNXCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'setnx' command")
+		return
+	}
+
+	key := c.Argv[1]
+	if lookupKey(c, key) != nil {
+		addReplyInt(c, 0)
+		return
+	}
+	c.Db.SetKey(key, NewObject(OBJSDS, SdsNewString(c.Argv[2].Ptr.(string))), false)
+	s.Dirty++
+	addReplyInt(c, 1)
+}
+
+// GetCommand ...
+func GetCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'get' command")
+		return
+	}
+
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	addReplyStringOrNull(c, str)
+}
+
+// GetSetCommand ...
+func GetSetCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'getset' command")
+		return
+	}
+
+	key := c.Argv[1]
+	old, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	c.Db.SetKey(key, NewObject(OBJSDS, SdsNewString(c.Argv[2].Ptr.(string))), false)
+	s.Dirty++
+	addReplyStringOrNull(c, old)
+}
+
+// GetDelCommand ...
+func GetDelCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'getdel' command")
+		return
+	}
+
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if str != nil {
+		c.Db.Delete(key)
+		s.Dirty++
+	}
+	addReplyStringOrNull(c, str)
+}
+
+// GetExCommand ...
+// GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|PERSIST]
+func GetExCommand(c *Client, s *Server) {
+	if c.Argc < 2 || c.Argc > 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'getex' command")
+		return
+	}
+
+	var when int64 = -1
+	persist := false
+	if c.Argc > 2 {
+		opt := strings.ToLower(c.Argv[2].Ptr.(string))
+		switch {
+		case opt == "persist" && c.Argc == 3:
+			persist = true
+		case (opt == "ex" || opt == "px" || opt == "exat" || opt == "pxat") && c.Argc == 4:
+			var ok bool
+			if when, ok = getExpireTimeOrReply(c, "getex", opt, c.Argv[3]); !ok {
+				return
+			}
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if str == nil {
+		addReplyNull(c)
+		return
+	}
+
+	// scf stores the change of timeout instead of GETEX
+	switch {
+	case when != -1 && when <= mstime() && !c.VirtualFlag:
+		c.Db.Delete(key)
+		rewriteClientCommandArgv(c, "del", key.Ptr.(string))
+		s.Dirty++
+	case when != -1:
+		c.Db.SetExpire(key, when)
+		rewriteClientCommandArgv(c, "pexpireat", key.Ptr.(string), strconv.FormatInt(when, 10))
+		s.Dirty++
+	case persist && c.Db.RemoveExpire(key):
+		rewriteClientCommandArgv(c, "persist", key.Ptr.(string))
+		s.Dirty++
+	}
+	addReplyBulkSds(c, str)
+}
+
+// MSetCommand ...
+// MSET key value [key value ...]
+func MSetCommand(c *Client, s *Server) {
+	msetGenericCommand(c, s, "mset", false)
+}
+
+// MSetNXCommand ...
+// MSETNX key value [key value ...]
+func MSetNXCommand(c *Client, s *Server) {
+	msetGenericCommand(c, s, "msetnx", true)
+}
+
+// msetGenericCommand sets all the keys, nothing is done if nx is true and any of the keys exists
+func msetGenericCommand(c *Client, s *Server, name string, nx bool) {
+	if c.Argc < 3 || c.Argc%2 == 0 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	if nx {
+		for i := 1; i < c.Argc; i += 2 {
+			if lookupKey(c, c.Argv[i]) != nil {
+				addReplyInt(c, 0)
+				return
+			}
+		}
+	}
+	for i := 1; i < c.Argc; i += 2 {
+		c.Db.SetKey(c.Argv[i], NewObject(OBJSDS, SdsNewString(c.Argv[i+1].Ptr.(string))), false)
+	}
+	s.Dirty++
+	if nx {
+		addReplyInt(c, 1)
+	} else {
+		addReplyStatus(c, "OK")
+	}
+}
+
+// MGetCommand ...
+// MGET key [key ...]
+func MGetCommand(c *Client, s *Server) {
+	if c.Argc < 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'mget' command")
+		return
+	}
+
+	array := make([]*EncodeData, 0, c.Argc-1)
+	for i := 1; i < c.Argc; i++ {
+		value := lookupKey(c, c.Argv[i])
+		// values which aren't strings are regarded as not existing
+		if value == nil || value.ObjectType != OBJSDS {
+			array = append(array, NewBulk(nil))
+		} else {
+			array = append(array, NewBulk(value.Ptr.(*Sdshdr).SdsGetBuf()))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// AppendCommand ...
+func AppendCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'append' command")
+		return
+	}
+
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	appended := c.Argv[2].Ptr.(string)
+	if str == nil {
+		str = SdsNewString(appended)
+		c.Db.Add(key, NewObject(OBJSDS, str))
+	} else {
+		if !checkStringLengthOrReply(c, int64(str.SdsLen()), int64(len(appended))) {
+			return
+		}
+		str.SdsCat(&appended)
+	}
+	s.Dirty++
+	addReplyInt(c, int64(str.SdsLen()))
+}
+
+// StrLenCommand ...
+func StrLenCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'strlen' command")
+		return
+	}
+
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if str == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(str.SdsLen()))
+}
+
+// GetRangeCommand ...
+// GETRANGE key start end
+func GetRangeCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'getrange' command")
+		return
+	}
+
+	start, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := getInt64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	str, ok := stringLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if str == nil {
+		addReplyBulk(c, "")
+		return
+	}
+	addReply(c, NewBulk(str.SdsGetRange(int(start), int(end))))
+}
+
+// SetRangeCommand ...
+// SETRANGE key offset value
+func SetRangeCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'setrange' command")
+		return
+	}
+
+	offset, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	if offset < 0 {
+		addReplyError(c, "ERR offset is out of range")
+		return
+	}
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+
+	value := c.Argv[3].Ptr.(string)
+	if len(value) == 0 {
+		// nothing is changed, and the key isn't created
+		if str == nil {
+			addReplyInt(c, 0)
+		} else {
+			addReplyInt(c, int64(str.SdsLen()))
+		}
+		return
+	}
+	if !checkStringLengthOrReply(c, offset, int64(len(value))) {
+		return
+	}
+	if str == nil {
+		str = SdsNewEmpty()
+		c.Db.Add(key, NewObject(OBJSDS, str))
+	}
+	str.SdsSetRange(int(offset), &value)
+	s.Dirty++
+	addReplyInt(c, int64(str.SdsLen()))
+}
+
+// IncrCommand ...
+func IncrCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'incr' command")
+		return
+	}
+	incrDecrCommand(c, s, 1)
+}
+
+// DecrCommand ...
+func DecrCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'decr' command")
+		return
+	}
+	incrDecrCommand(c, s, -1)
+}
+
+// IncrByCommand ...
+func IncrByCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'incrby' command")
+		return
+	}
+	incr, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	incrDecrCommand(c, s, incr)
+}
+
+// DecrByCommand ...
+func DecrByCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'decrby' command")
+		return
+	}
+	decr, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	if decr == math.MinInt64 {
+		addReplyError(c, "ERR decrement would overflow")
+		return
+	}
+	incrDecrCommand(c, s, -decr)
+}
+
+// incrDecrCommand adds incr to the integer stored at argv[1], the timeout of key is kept
+func incrDecrCommand(c *Client, s *Server, incr int64) {
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	var num int64
+	if str != nil {
+		var err error
+		if num, err = strconv.ParseInt(*str.SdsGetString(), 10, 64); err != nil {
+			addReplyError(c, "ERR value is not an integer or out of range")
+			return
+		}
+	}
+	if incr > 0 && num > math.MaxInt64-incr || incr < 0 && num < math.MinInt64-incr {
+		addReplyError(c, "ERR increment or decrement would overflow")
+		return
+	}
+	num += incr
+	c.Db.SetKey(key, NewObject(OBJSDS, SdsNewString(strconv.FormatInt(num, 10))), true)
+	s.Dirty++
+	addReplyInt(c, num)
+}
+
+// IncrByFloatCommand ...
+func IncrByFloatCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'incrbyfloat' command")
+		return
+	}
+
+	incr, ok := getFloat64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	str, ok := stringLookupRead(c, key)
+	if !ok {
+		return
+	}
+	var num float64
+	if str != nil {
+		var err error
+		if num, err = strconv.ParseFloat(*str.SdsGetString(), 64); err != nil || math.IsNaN(num) {
+			addReplyError(c, "ERR value is not a valid float")
+			return
+		}
+	}
+	num += incr
+	if math.IsNaN(num) || math.IsInf(num, 0) {
+		addReplyError(c, "ERR increment would produce NaN or Infinity")
+		return
+	}
+
+	result := strconv.FormatFloat(num, 'f', -1, 64)
+	c.Db.SetKey(key, NewObject(OBJSDS, SdsNewString(result)), true)
+	// the result is stored in scf to avoid the difference of float calculation
+	rewriteClientCommandArgv(c, "set", key.Ptr.(string), result, "keepttl")
+	s.Dirty++
+	addReplyBulk(c, result)
+}
@@ -0,0 +1,96 @@
+package godis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"set k v nx", "+OK\r\n"},
+		{"set k v2 nx", "$-1\r\n"},
+		{"set k v2 xx get", "$1\r\nv\r\n"},
+		{"set nope v xx", "$-1\r\n"},
+		{"set k v nx xx", "-ERR syntax error\r\n"},
+		{"setnx k v", ":0\r\n"},
+		{"getset k v3", "$2\r\nv2\r\n"},
+		{"getdel k", "$2\r\nv3\r\n"},
+		{"getdel k", "$-1\r\n"},
+		{"mset a 1 b 2", "+OK\r\n"},
+		{"msetnx b 3 c 3", ":0\r\n"},
+		{"msetnx c 3 d 4", ":1\r\n"},
+		{"hset h f v", ":1\r\n"},
+		{"mget a h nope d", "*4\r\n$1\r\n1\r\n$-1\r\n$-1\r\n$1\r\n4\r\n"},
+		{"set h v get", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"append s hello", ":5\r\n"},
+		{"append s world", ":10\r\n"},
+		{"strlen s", ":10\r\n"},
+		{"getrange s -5 -1", "$5\r\nworld\r\n"},
+		{"getrange s 3 100", "$7\r\nloworld\r\n"},
+		{"getrange s 5 3", "$0\r\n\r\n"},
+		{"setrange s 5 _", ":10\r\n"},
+		{"setrange pad 3 x", ":4\r\n"},
+		{"getrange pad 0 -1", "$4\r\n\x00\x00\x00x\r\n"},
+		{"setrange nope2 0 ", "-ERR wrong number of arguments for 'setrange' command\r\n"},
+		{"setrange s -1 x", "-ERR offset is out of range\r\n"},
+		{"setrange s 9223372036854775807 x", "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		{"setrange s 536870912 x", "-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n"},
+		{"incrby a 10", ":11\r\n"},
+		{"decr a", ":10\r\n"},
+		{"decrby a 20", ":-10\r\n"},
+		{"set big 9223372036854775807", "+OK\r\n"},
+		{"incr big", "-ERR increment or decrement would overflow\r\n"},
+		{"incr s", "-ERR value is not an integer or out of range\r\n"},
+		{"set f 10.5 px 100000", "+OK\r\n"},
+		{"incrbyfloat f 0.1", "$4\r\n10.6\r\n"},
+		{"incrbyfloat f 5.0e3", "$6\r\n5010.6\r\n"},
+		{"incrbyfloat f +inf", "-ERR increment would produce NaN or Infinity\r\n"},
+		{"set big 1e16", "+OK\r\n"},
+		{"incrbyfloat big 0.00001", "$17\r\n10000000000000000\r\n"},
+		{"getex f persist", "$6\r\n5010.6\r\n"},
+		{"ttl f", ":-1\r\n"},
+		{"getex f px 100000", "$6\r\n5010.6\r\n"},
+		{"getex f ex 0", "-ERR invalid expire time in 'getex' command\r\n"},
+		{"getex f ex 9223372036854775", "-ERR invalid expire time in 'getex' command\r\n"},
+		{"set f v px 9223372036854775807", "-ERR invalid expire time in 'set' command\r\n"},
+		{"set f v exat 9223372036854776", "-ERR invalid expire time in 'set' command\r\n"},
+		{"get f", "$6\r\n5010.6\r\n"},
+		{"getex d pxat 1", "$1\r\n4\r\n"},
+		{"exists d", ":0\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"get s", "$10\r\nhello_orld\r\n"},
+		{"get f", "$6\r\n5010.6\r\n"},
+		{"get a", "$3\r\n-10\r\n"},
+		{"exists d k", ":0\r\n"},
+		{"strlen pad", ":4\r\n"},
+	} {
+		if r := execCommand(loaded, lc, strings.Fields(tc.cmd)...); r != tc.expect {
+			t.Fatalf("%s replies %q after loading, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+	if ttl := execCommand(loaded, lc, "pttl", "f"); ttl == ":-1\r\n" || ttl == ":-2\r\n" {
+		t.Fatalf("timeout of f is lost after loading: %q", ttl)
+	}
+}
@@ -34,7 +34,7 @@ func (w *Writer) Flush() error {
 		return nil
 	}
 
-	n, err := w.writer.Write(w.buf)
+	n, err := w.writer.Write(w.buf[:w.rpos])
 	if err != nil {
 		w.err = err
 	} else if n < w.rpos {
@@ -161,7 +161,7 @@ func (r *Reader) ReadBytesDelim(delim byte) ([]byte, error) {
 	}
 	for {
 		pos := bytes.IndexByte(r.buf[r.lpos:r.rpos], delim)
-		if pos > 0 {
+		if pos >= 0 {
 			newLpos := r.lpos + pos + 1
 			ans := r.buf[r.lpos:newLpos]
 			r.lpos = newLpos
@@ -179,20 +179,32 @@ func (r *Reader) ReadBytesDelim(delim byte) ([]byte, error) {
 
 // ReadBytesLen read bytes which length is n from r.lpos
 func (r *Reader) ReadBytesLen(n int) ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
 	re := make([]byte, n)
 	for l := 0; l < n; {
-		if length := r.bufferLen(); length >= (n-l) || length == len(r.buf) {
-			tmp := copy(re[l:], r.buf[r.lpos:])
-			l += tmp
-			r.lpos += tmp
-		}
-		if err := r.fillBuf(); err != nil {
-			return nil, err
+		if r.bufferLen() == 0 && r.fillBuf() != nil {
+			return nil, r.err
 		}
+		tmp := copy(re[l:], r.buf[r.lpos:r.rpos])
+		l += tmp
+		r.lpos += tmp
 	}
 	return re, nil
 }
 
+// Buffered return the number of bytes that have been read from reader but not consumed
+func (r *Reader) Buffered() int {
+	return r.bufferLen()
+}
+
+// Peek return the bytes that have been read from reader but not consumed without copying,
+// they're only valid until the next read
+func (r *Reader) Peek() []byte {
+	return r.buf[r.lpos:r.rpos]
+}
+
 // bufferLen return valid length of buf
 func (r *Reader) bufferLen() int {
 	return r.rpos - r.lpos
@@ -0,0 +1,48 @@
+package bufio2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriterFlush(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriterSize(&b, 16)
+	w.WriteString("abc")
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "abc" {
+		t.Fatalf("flush writes %q, expect %q", b.String(), "abc")
+	}
+}
+
+func TestReaderReadBytesDelim(t *testing.T) {
+	r := NewReaderSize(strings.NewReader("\nab\n"), 16)
+	for _, expect := range []string{"\n", "ab\n"} {
+		b, err := r.ReadBytesDelim('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expect {
+			t.Fatalf("read %q, expect %q", b, expect)
+		}
+	}
+}
+
+func TestReaderReadBytesLen(t *testing.T) {
+	r := NewReaderSize(strings.NewReader("hello world"), 4)
+	for _, expect := range []string{"hello", " world"} {
+		b, err := r.ReadBytesLen(len(expect))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expect {
+			t.Fatalf("read %q, expect %q", b, expect)
+		}
+	}
+	if _, err := r.ReadBytesLen(1); err == nil {
+		t.Fatal("read beyond the end of reader")
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import "testing"
+
+func TestStringMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		nocase  bool
+		match   bool
+	}{
+		{"*", "anything", false, true},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "heeeello", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[a-b]llo", "hcllo", false, false},
+		{"user:*:name", "user:42:name", false, true},
+		{"user:*:name", "user:42:mail", false, false},
+		{"a\\*b", "a*b", false, true},
+		{"a\\*b", "axb", false, false},
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"", "", false, true},
+		{"*a", "", false, false},
+	}
+	for _, cs := range cases {
+		if StringMatch(cs.pattern, cs.str, cs.nocase) != cs.match {
+			t.Errorf("StringMatch(%q, %q, %v) should be %v", cs.pattern, cs.str, cs.nocase, cs.match)
+		}
+	}
+}
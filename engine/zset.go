@@ -1,15 +1,15 @@
 package godis
 
 import (
-	"bytes"
-	"fmt"
+	"math"
 	"math/rand"
 	"strconv"
-	"time"
+	"strings"
 )
 
 const (
 	ZSL_MAX_LEVEL = (1 << 5)
+	ZSL_P         = 0.25 // probability of a node having one more level
 )
 
 // ZskipList ...
@@ -18,7 +18,7 @@ type ZskipList struct {
 	tail   *ZskipListNode
 	length uint32
 	level  int
-	dt     *Dict
+	dt     *Dict // maps member to its node in skiplist
 }
 
 // ZskipListNode ...
@@ -41,6 +41,18 @@ type zrangeTag struct {
 	emaxx bool
 }
 
+// zlexBound is a bound of lex range, inf is -1 for "-" and 1 for "+"
+type zlexBound struct {
+	value     *Sdshdr
+	inf       int
+	exclusive bool
+}
+
+type zlexrangeTag struct {
+	min zlexBound
+	max zlexBound
+}
+
 // NewZsl create a new zskipList
 func NewZsl() *ZskipList {
 	zsl := &ZskipList{
@@ -71,9 +83,13 @@ func NewZslNode(lv int, value *Sdshdr, score float64) *ZskipListNode {
 	}
 }
 
+// zslRandomLevel return the level of a new node, higher levels are less likely
 func zslRandomLevel() int {
-	rand.Seed(time.Now().Unix()) //TODO: change setting seed mod to others
-	return rand.Intn(ZSL_MAX_LEVEL) + 1
+	level := 1
+	for level < ZSL_MAX_LEVEL && rand.Float64() < ZSL_P {
+		level++
+	}
+	return level
 }
 
 // Insert insert node into skiplist
@@ -251,12 +267,66 @@ func (zsl *ZskipList) LastInRange(rg *zrangeTag) *ZskipListNode {
 	}
 	p := zsl.header
 	for i := zsl.level - 1; i >= 0; i-- {
-		for p.level[i].forward != nil && !zslCompareMax(p.level[i].forward.score, rg) {
+		for p.level[i].forward != nil && zslCompareMax(p.level[i].forward.score, rg) {
 			p = p.level[i].forward
 		}
 	}
 
-	if p == nil || !zslCompareMin(p.score, rg) {
+	if p == zsl.header || !zslCompareMin(p.score, rg) {
+		return nil
+	}
+	return p
+}
+
+// zslLexCompare compares value with bound like SdsCmp
+func zslLexCompare(value *Sdshdr, bound *zlexBound) int {
+	if bound.inf != 0 {
+		return -bound.inf
+	}
+	return SdsCmp(value, bound.value)
+}
+
+func zslLexValueGteMin(value *Sdshdr, rg *zlexrangeTag) bool {
+	if rg.min.exclusive {
+		return zslLexCompare(value, &rg.min) > 0
+	}
+	return zslLexCompare(value, &rg.min) >= 0
+}
+
+func zslLexValueLteMax(value *Sdshdr, rg *zlexrangeTag) bool {
+	if rg.max.exclusive {
+		return zslLexCompare(value, &rg.max) < 0
+	}
+	return zslLexCompare(value, &rg.max) <= 0
+}
+
+// FirstInLexRange return the first node in lex range,
+// lex range only makes sense when all the members have the same score
+func (zsl *ZskipList) FirstInLexRange(rg *zlexrangeTag) *ZskipListNode {
+	p := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && !zslLexValueGteMin(p.level[i].forward.value, rg) {
+			p = p.level[i].forward
+		}
+	}
+
+	p = p.level[0].forward
+	if p == nil || !zslLexValueLteMax(p.value, rg) {
+		return nil
+	}
+	return p
+}
+
+// LastInLexRange return the last node in lex range
+func (zsl *ZskipList) LastInLexRange(rg *zlexrangeTag) *ZskipListNode {
+	p := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && zslLexValueLteMax(p.level[i].forward.value, rg) {
+			p = p.level[i].forward
+		}
+	}
+
+	if p == zsl.header || !zslLexValueGteMin(p.value, rg) {
 		return nil
 	}
 	return p
@@ -286,6 +356,29 @@ func (zsl *ZskipList) DeleteRangeByScore(rg *zrangeTag, dt *Dict) uint32 {
 	return num
 }
 
+// DeleteRangeByLex delete nodes in lex range, which are also deleted in hash table
+func (zsl *ZskipList) DeleteRangeByLex(rg *zlexrangeTag, dt *Dict) uint32 {
+	borders := make([]*ZskipListNode, ZSL_MAX_LEVEL)
+	p := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for p.level[i].forward != nil && !zslLexValueGteMin(p.level[i].forward.value, rg) {
+			p = p.level[i].forward
+		}
+		borders[i] = p
+	}
+
+	var num uint32 = 0
+	p = p.level[0].forward
+	for p != nil && zslLexValueLteMax(p.value, rg) {
+		forward := p.level[0].forward
+		zsl.DeleteNode(p, borders)
+		dt.Delete(NewObject(OBJSDS, p.value))
+		num++
+		p = forward
+	}
+	return num
+}
+
 // DeleteRangeByRank delete node from rank start to rank end,
 // and delete these nodes from hash table
 func (zsl *ZskipList) DeleteRangeByRank(start uint32, end uint32, dt *Dict) uint32 {
@@ -295,6 +388,7 @@ func (zsl *ZskipList) DeleteRangeByRank(start uint32, end uint32, dt *Dict) uint
 	var num uint32 = 0
 	for i := zsl.level - 1; i >= 0; i-- {
 		for p.level[i].forward != nil && dis+p.level[i].span < start {
+			dis += p.level[i].span
 			p = p.level[i].forward
 		}
 		borders[i] = p
@@ -322,7 +416,7 @@ func (zsl *ZskipList) GetRank(score float64, value *Sdshdr) uint32 {
 			dis += p.level[i].span
 			p = p.level[i].forward
 		}
-		if p.score == score && SdsCmp(p.value, value) == 0 {
+		if p != zsl.header && p.score == score && SdsCmp(p.value, value) == 0 {
 			return dis
 		}
 	}
@@ -345,150 +439,555 @@ func (zsl *ZskipList) GetElementByRank(rank uint32) *ZskipListNode {
 	return nil
 }
 
+// GetScore return the score of member found by hash table
+func (zsl *ZskipList) GetScore(member *Sdshdr) (float64, bool) {
+	value := zsl.dt.Get(NewObject(OBJSDS, member))
+	if value == nil {
+		return 0, false
+	}
+	return value.Ptr.(*ZskipListNode).score, true
+}
+
+// addMember insert member which isn't in zsl with score
+func (zsl *ZskipList) addMember(score float64, member *Sdshdr) {
+	node := zsl.Insert(score, member)
+	zsl.dt.Add(NewObject(OBJSDS, member), NewObject(OBJZslNode, node))
+}
+
+// zsetLookupRead return the zset stored at key and whether the type of key is right,
+// the zset is nil if key doesn't exist
+func zsetLookupRead(c *Client, key *Object) (*ZskipList, bool) {
+	value := lookupKey(c, key)
+	if value == nil {
+		return nil, true
+	}
+	if !checkType(c, value, OBJZset) {
+		return nil, false
+	}
+	return value.Ptr.(*ZskipList), true
+}
+
+// deleteIfEmptyZset delete key if the zset stored at it has no member
+func deleteIfEmptyZset(c *Client, key *Object, zset *ZskipList) {
+	if zset.length == 0 {
+		c.Db.Delete(key)
+	}
+}
+
+const (
+	ZADD_IN_NONE = 0
+	ZADD_IN_INCR = 1 << iota // increment the score instead of setting it
+	ZADD_IN_NX               // don't touch elements already existing
+	ZADD_IN_XX               // only touch elements already existing
+	ZADD_IN_GT               // only update the score if it's greater
+	ZADD_IN_LT               // only update the score if it's less
+)
+
+const (
+	ZADD_OUT_NOP     = iota // nothing is done because of the conditions
+	ZADD_OUT_NAN            // the resulting score is NaN
+	ZADD_OUT_ADDED          // the element is added
+	ZADD_OUT_UPDATED        // the score of element is updated
+	ZADD_OUT_SAME           // the score of element is the same as before
+)
+
+// updateMember changes the score of member in zsl from curScore to score
+func (zsl *ZskipList) updateMember(member *Sdshdr, curScore float64, score float64) {
+	// the node may be recreated
+	node := zsl.Update(member, curScore, score)
+	zsl.dt.Search(NewObject(OBJSDS, member)).value = NewObject(OBJZslNode, node)
+}
+
+// zsetAdd adds member with score or updates its score according to flags,
+// it returns the result and the score of member after that
+func (zsl *ZskipList) zsetAdd(score float64, member *Sdshdr, flags int) (int, float64) {
+	curScore, exists := zsl.GetScore(member)
+	if !exists {
+		if flags&ZADD_IN_XX != 0 {
+			return ZADD_OUT_NOP, 0
+		}
+		zsl.addMember(score, member)
+		return ZADD_OUT_ADDED, score
+	}
+
+	if flags&ZADD_IN_NX != 0 {
+		return ZADD_OUT_NOP, curScore
+	}
+	if flags&ZADD_IN_INCR != 0 {
+		score += curScore
+		if math.IsNaN(score) {
+			return ZADD_OUT_NAN, curScore
+		}
+	}
+	if flags&ZADD_IN_GT != 0 && score <= curScore || flags&ZADD_IN_LT != 0 && score >= curScore {
+		return ZADD_OUT_NOP, curScore
+	}
+	if score == curScore {
+		return ZADD_OUT_SAME, curScore
+	}
+	zsl.updateMember(member, curScore, score)
+	return ZADD_OUT_UPDATED, score
+}
+
 // ZaddCommand ...
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 func ZaddCommand(c *Client, s *Server) {
-	if c.Argc < 4 || (c.Argc&1) == 1 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'zadd' command")
+	zaddGenericCommand(c, s, "zadd", ZADD_IN_NONE)
+}
+
+// ZIncrByCommand ...
+// ZINCRBY key increment member
+func ZIncrByCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zincrby' command")
 		return
 	}
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		value = NewObject(OBJZset, NewZsl())
-		c.Db.Dt.Add(key, value)
+	zaddGenericCommand(c, s, "zincrby", ZADD_IN_INCR)
+}
+
+func zaddGenericCommand(c *Client, s *Server, name string, flags int) {
+	ch := false
+	scoreIndex := 2
+options:
+	// ZINCRBY has no option
+	for ; name == "zadd" && scoreIndex < c.Argc; scoreIndex++ {
+		switch strings.ToLower(c.Argv[scoreIndex].Ptr.(string)) {
+		case "nx":
+			flags |= ZADD_IN_NX
+		case "xx":
+			flags |= ZADD_IN_XX
+		case "gt":
+			flags |= ZADD_IN_GT
+		case "lt":
+			flags |= ZADD_IN_LT
+		case "ch":
+			ch = true
+		case "incr":
+			flags |= ZADD_IN_INCR
+		default:
+			break options
+		}
+	}
+	elements := c.Argc - scoreIndex
+	if elements <= 0 || elements%2 != 0 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+	incr := flags&ZADD_IN_INCR != 0
+	if flags&ZADD_IN_NX != 0 && flags&ZADD_IN_XX != 0 {
+		addReplyError(c, "ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if flags&ZADD_IN_GT != 0 && flags&ZADD_IN_LT != 0 ||
+		flags&ZADD_IN_NX != 0 && flags&(ZADD_IN_GT|ZADD_IN_LT) != 0 {
+		addReplyError(c, "ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if incr && elements > 2 {
+		addReplyError(c, "ERR INCR option supports a single increment-element pair")
+		return
 	}
 
-	zset := value.Ptr.(*ZskipList)
-	for i := 2; i < c.Argc; i++ {
-		scStr := c.Argv[i].Ptr.(string)
-		score, err := strconv.ParseFloat(scStr, 64)
-		if err != nil {
-			addReplyError(c, "(error) ERR value is not a valid float")
+	// parse all the scores before changing the zset
+	scores := make([]float64, 0, elements/2)
+	for i := scoreIndex; i < c.Argc; i += 2 {
+		score, err := strconv.ParseFloat(c.Argv[i].Ptr.(string), 64)
+		if err != nil || math.IsNaN(score) {
+			addReplyError(c, "ERR value is not a valid float")
 			return
 		}
+		scores = append(scores, score)
+	}
 
-		member := c.Argv[i+1].Ptr.(string)
-		i++
-		key = NewObject(OBJSDS, SdsNewString(member))
-		if o := zset.dt.Get(key); o != nil {
-			curScore, _ := strconv.ParseFloat(*(o.Ptr.(*Sdshdr).SdsGetString()), 64)
-			zset.Update(SdsNewString(member), curScore, score)
-			zset.dt.Delete(key)
-			zset.dt.Add(key, NewObject(OBJSDS, SdsNewString(scStr)))
-			continue
+	key := c.Argv[1]
+	zset, ok := zsetLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if zset == nil {
+		if flags&ZADD_IN_XX != 0 {
+			if incr {
+				addReplyNull(c)
+			} else {
+				addReplyInt(c, 0)
+			}
+			return
 		}
-		zset.Insert(score, SdsNewString(member))
-		zset.dt.Add(key, NewObject(OBJSDS, SdsNewString(scStr)))
+		zset = NewZsl()
+		c.Db.Add(key, NewObject(OBJZset, zset))
+	}
+
+	added, updated := 0, 0
+	var score float64
+	for i, sc := range scores {
+		member := SdsNewString(c.Argv[scoreIndex+2*i+1].Ptr.(string))
+		var result int
+		result, score = zset.zsetAdd(sc, member, flags)
+		switch result {
+		case ZADD_OUT_NAN:
+			addReplyError(c, "ERR resulting score is not a number (NaN)")
+			return
+		case ZADD_OUT_ADDED:
+			added++
+		case ZADD_OUT_UPDATED:
+			updated++
+		}
+		if incr && result == ZADD_OUT_NOP {
+			addReplyNull(c)
+			return
+		}
+	}
+	if added+updated > 0 {
+		s.Dirty++
+	}
+
+	switch {
+	case incr:
+		addReplyBulk(c, formatFloat(score))
+	case ch:
+		addReplyInt(c, int64(added+updated))
+	default:
+		addReplyInt(c, int64(added))
 	}
-	s.Dirty++
-	addReplyInt(c, int64((c.Argc-2)/2))
 }
 
 // ZscoreCommand ...
 func ZscoreCommand(c *Client, s *Server) {
 	if c.Argc != 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'zscore' command")
+		addReplyError(c, "ERR wrong number of arguments for 'zscore' command")
 		return
 	}
 
 	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
+	value := lookupKey(c, key)
 	if value == nil {
-		addReplyStatus(c, "(nil)")
+		addReplyNull(c)
+		return
+	}
+	if !checkType(c, value, OBJZset) {
 		return
 	}
 
 	zset := value.Ptr.(*ZskipList)
-	member := c.Argv[2].Ptr.(string)
-	key = NewObject(OBJSDS, SdsNewString(member))
-	value = zset.dt.Get(key)
-	if value == nil {
-		addReplyStatus(c, "(nil)")
+	score, ok := zset.GetScore(SdsNewString(c.Argv[2].Ptr.(string)))
+	if !ok {
+		addReplyNull(c)
 		return
 	}
+	addReplyFloat(c, score)
+}
+
+const (
+	ZRANGE_RANK = iota
+	ZRANGE_SCORE
+	ZRANGE_LEX
+)
+
+// parseScoreBound parse a bound of score range, "(" means exclusive
+func parseScoreBound(o *Object) (float64, bool, bool) {
+	str := o.Ptr.(string)
+	exclusive := strings.HasPrefix(str, "(")
+	if exclusive {
+		str = str[1:]
+	}
+	score, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false, false
+	}
+	return score, exclusive, true
+}
+
+// parseScoreRangeOrReply parse the score range from min to max
+func parseScoreRangeOrReply(c *Client, min *Object, max *Object) (*zrangeTag, bool) {
+	rg := &zrangeTag{}
+	var minOK, maxOK bool
+	rg.minx, rg.eminx, minOK = parseScoreBound(min)
+	rg.maxx, rg.emaxx, maxOK = parseScoreBound(max)
+	if !minOK || !maxOK {
+		addReplyError(c, "ERR min or max is not a float")
+		return nil, false
+	}
+	return rg, true
+}
 
-	addReplyStatus(c, *(value.Ptr.(*Sdshdr).SdsGetString()))
+// parseLexBound parse a bound of lex range which is "-", "+" or starts with "(" or "["
+func parseLexBound(o *Object) (zlexBound, bool) {
+	str := o.Ptr.(string)
+	switch {
+	case str == "-":
+		return zlexBound{inf: -1}, true
+	case str == "+":
+		return zlexBound{inf: 1}, true
+	case strings.HasPrefix(str, "("):
+		return zlexBound{value: SdsNewString(str[1:]), exclusive: true}, true
+	case strings.HasPrefix(str, "["):
+		return zlexBound{value: SdsNewString(str[1:])}, true
+	}
+	return zlexBound{}, false
+}
+
+// parseLexRangeOrReply parse the lex range from min to max
+func parseLexRangeOrReply(c *Client, min *Object, max *Object) (*zlexrangeTag, bool) {
+	rg := &zlexrangeTag{}
+	var minOK, maxOK bool
+	rg.min, minOK = parseLexBound(min)
+	rg.max, maxOK = parseLexBound(max)
+	if !minOK || !maxOK {
+		addReplyError(c, "ERR min or max not valid string range item")
+		return nil, false
+	}
+	return rg, true
+}
+
+// zslCollect collects at most count nodes in range from first after skipping offset nodes,
+// count is unlimited if it's negative
+func zslCollect(first *ZskipListNode, reverse bool, inRange func(node *ZskipListNode) bool,
+	offset int64, count int64) []*ZskipListNode {
+	nodes := make([]*ZskipListNode, 0)
+	for node := first; node != nil && count != 0 && inRange(node); {
+		if offset > 0 {
+			offset--
+		} else {
+			nodes = append(nodes, node)
+			count--
+		}
+		if reverse {
+			node = node.backward
+		} else {
+			node = node.level[0].forward
+		}
+	}
+	return nodes
 }
 
 // ZrangeCommand ...
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
 func ZrangeCommand(c *Client, s *Server) {
-	if c.Argc != 4 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'zrange' command")
+	zrangeGenericCommand(c, s, "zrange", ZRANGE_RANK, false)
+}
+
+// ZRevRangeCommand ...
+// ZREVRANGE key start stop [WITHSCORES]
+func ZRevRangeCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, s, "zrevrange", ZRANGE_RANK, true)
+}
+
+// ZRangeByScoreCommand ...
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func ZRangeByScoreCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, s, "zrangebyscore", ZRANGE_SCORE, false)
+}
+
+// ZRangeByLexCommand ...
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func ZRangeByLexCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, s, "zrangebylex", ZRANGE_LEX, false)
+}
+
+// ZRevRangeByLexCommand ...
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func ZRevRangeByLexCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, s, "zrevrangebylex", ZRANGE_LEX, true)
+}
+
+// ZRevRangeByScoreCommand ...
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func ZRevRangeByScoreCommand(c *Client, s *Server) {
+	zrangeGenericCommand(c, s, "zrevrangebyscore", ZRANGE_SCORE, true)
+}
+
+// zrangeGenericCommand implements the range commands, argv[2] and argv[3] are the range,
+// options start from argv[4], and only ZRANGE accepts BYSCORE, BYLEX and REV
+func zrangeGenericCommand(c *Client, s *Server, name string, rangeType int, reverse bool) {
+	if c.Argc < 4 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
 		return
 	}
 
-	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
-	if value == nil {
-		addReplyStatus(c, "(nil)")
+	unified := name == "zrange"
+	withScores, limited := false, false
+	offset, count := int64(0), int64(-1)
+	for i := 4; i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		switch {
+		case opt == "withscores" && (unified || rangeType != ZRANGE_LEX):
+			withScores = true
+		case opt == "limit" && i+2 < c.Argc && (unified || rangeType != ZRANGE_RANK):
+			var ok bool
+			if offset, ok = getInt64FromObjectOrReply(c, c.Argv[i+1]); !ok {
+				return
+			}
+			if count, ok = getInt64FromObjectOrReply(c, c.Argv[i+2]); !ok {
+				return
+			}
+			limited = true
+			i += 2
+		case opt == "byscore" && unified && rangeType == ZRANGE_RANK:
+			rangeType = ZRANGE_SCORE
+		case opt == "bylex" && unified && rangeType == ZRANGE_RANK:
+			rangeType = ZRANGE_LEX
+		case opt == "rev" && unified:
+			reverse = true
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+	if limited && rangeType == ZRANGE_RANK {
+		addReplyError(c, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
 		return
 	}
-	zset := value.Ptr.(*ZskipList)
-	left, err := strconv.Atoi(c.Argv[2].Ptr.(string))
-	if err != nil {
-		addReplyStatus(c, "(error) ERR value is not an integer or out of range")
+	if withScores && rangeType == ZRANGE_LEX {
+		addReplyError(c, "ERR syntax error, WITHSCORES not supported in combination with BYLEX")
 		return
 	}
-	right, err := strconv.Atoi(c.Argv[3].Ptr.(string))
-	if err != nil {
-		addReplyStatus(c, "(error) ERR value is not an integer or out of range")
+
+	// the range is from max to min if it's reversed
+	minArg, maxArg := c.Argv[2], c.Argv[3]
+	if reverse {
+		minArg, maxArg = maxArg, minArg
+	}
+	var start, end int64
+	var scoreRange *zrangeTag
+	var lexRange *zlexrangeTag
+	var ok bool
+	switch rangeType {
+	case ZRANGE_RANK:
+		if start, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		if end, ok = getInt64FromObjectOrReply(c, c.Argv[3]); !ok {
+			return
+		}
+	case ZRANGE_SCORE:
+		if scoreRange, ok = parseScoreRangeOrReply(c, minArg, maxArg); !ok {
+			return
+		}
+	case ZRANGE_LEX:
+		if lexRange, ok = parseLexRangeOrReply(c, minArg, maxArg); !ok {
+			return
+		}
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyArray(c, []*EncodeData{})
 		return
 	}
 
-	b := bytes.Buffer{}
-	num := 0
-	for i := left; i <= right; i++ {
-		node := zset.GetElementByRank(uint32(i))
-		if node == nil {
-			break
+	var nodes []*ZskipListNode
+	switch rangeType {
+	case ZRANGE_RANK:
+		nodes = zset.rangeByRank(start, end, reverse)
+	case ZRANGE_SCORE:
+		first := zset.FirstInRange(scoreRange)
+		inRange := func(node *ZskipListNode) bool { return zslCompareMax(node.score, scoreRange) }
+		if reverse {
+			first = zset.LastInRange(scoreRange)
+			inRange = func(node *ZskipListNode) bool { return zslCompareMin(node.score, scoreRange) }
 		}
-		num++
-		b.WriteString(fmt.Sprintf("%d) \"%s\" \"%f\"\n", num, *(node.value.SdsGetString()), node.score))
+		nodes = zslCollect(first, reverse, inRange, offset, count)
+	case ZRANGE_LEX:
+		first := zset.FirstInLexRange(lexRange)
+		inRange := func(node *ZskipListNode) bool { return zslLexValueLteMax(node.value, lexRange) }
+		if reverse {
+			first = zset.LastInLexRange(lexRange)
+			inRange = func(node *ZskipListNode) bool { return zslLexValueGteMin(node.value, lexRange) }
+		}
+		nodes = zslCollect(first, reverse, inRange, offset, count)
 	}
-	if num == 0 {
-		addReplyStatus(c, "(nil)")
-	} else {
-		addReplyStatus(c, b.String())
+	if offset < 0 {
+		nodes = nil
+	}
+
+	array := make([]*EncodeData, 0, len(nodes))
+	for _, node := range nodes {
+		array = append(array, NewBulk(node.value.SdsGetBuf()))
+		if withScores {
+			array = append(array, NewBulk([]byte(formatFloat(node.score))))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// zsetRankRange converts start and end which may be negative into the ranks counted from 0,
+// false is returned if the range is empty
+func zsetRankRange(start int64, end int64, length int64) (int64, int64, bool) {
+	// negative index means the offset from tail
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
 	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end, start <= end
+}
+
+// rangeByRank return the nodes from rank start to end, ranks are counted from tail if reverse is true
+func (zsl *ZskipList) rangeByRank(start int64, end int64, reverse bool) []*ZskipListNode {
+	length := int64(zsl.length)
+	start, end, ok := zsetRankRange(start, end, length)
+	if !ok {
+		return nil
+	}
+	// rank of skiplist starts from 1
+	first := zsl.GetElementByRank(uint32(start + 1))
+	if reverse {
+		first = zsl.GetElementByRank(uint32(length - start))
+	}
+	return zslCollect(first, reverse, func(*ZskipListNode) bool { return true }, 0, end-start+1)
 }
 
 // ZrankCommand ...
 func ZrankCommand(c *Client, s *Server) {
 	if c.Argc != 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'zrank' command")
+		addReplyError(c, "ERR wrong number of arguments for 'zrank' command")
 		return
 	}
 	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
+	value := lookupKey(c, key)
 	if value == nil {
-		addReplyStatus(c, "(nil)")
+		addReplyNull(c)
+		return
+	}
+	if !checkType(c, value, OBJZset) {
 		return
 	}
 
 	zset := value.Ptr.(*ZskipList)
-	member := c.Argv[2].Ptr.(string)
-	key = NewObject(OBJSDS, SdsNewString(member))
-	value = zset.dt.Get(key)
-	if value == nil {
-		addReplyStatus(c, "(nil)")
+	member := SdsNewString(c.Argv[2].Ptr.(string))
+	score, ok := zset.GetScore(member)
+	if !ok {
+		addReplyNull(c)
 		return
 	}
 
-	score, _ := strconv.ParseFloat(*(value.Ptr.(*Sdshdr).SdsGetString()), 64)
-	rank := zset.GetRank(score, SdsNewString(member))
-	addReplyInt(c, int64(rank))
+	rank := zset.GetRank(score, member)
+	addReplyInt(c, int64(rank)-1)
 }
 
 // ZremCommand ...
 func ZremCommand(c *Client, s *Server) {
 	if c.Argc < 3 {
-		addReplyError(c, "(error) ERR wrong number of arguments for 'zrem' command")
+		addReplyError(c, "ERR wrong number of arguments for 'zrem' command")
 		return
 	}
 	key := c.Argv[1]
-	value := c.Db.Dt.Get(key)
+	value := lookupKey(c, key)
 	if value == nil {
-		addReplyStatus(c, "(nil)")
+		addReplyInt(c, 0)
+		return
+	}
+	if !checkType(c, value, OBJZset) {
 		return
 	}
 
@@ -496,17 +995,750 @@ func ZremCommand(c *Client, s *Server) {
 
 	num := 0
 	for i := 2; i < c.Argc; i++ {
-		member := c.Argv[i].Ptr.(string)
-		key = NewObject(OBJSDS, SdsNewString(member))
-		value = zset.dt.Get(key)
-		if value == nil {
+		member := SdsNewString(c.Argv[i].Ptr.(string))
+		score, ok := zset.GetScore(member)
+		if !ok {
 			continue
 		}
 		num++
-		score, _ := strconv.ParseFloat(*(value.Ptr.(*Sdshdr).SdsGetString()), 64)
-		zset.dt.Delete(key)
-		zset.Delete(score, SdsNewString(member))
+		zset.dt.Delete(NewObject(OBJSDS, member))
+		zset.Delete(score, member)
+	}
+	if num > 0 {
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
 	}
-	s.Dirty++
 	addReplyInt(c, int64(num))
 }
+
+// ZRevRankCommand ...
+func ZRevRankCommand(c *Client, s *Server) {
+	if c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zrevrank' command")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyNull(c)
+		return
+	}
+	member := SdsNewString(c.Argv[2].Ptr.(string))
+	score, ok := zset.GetScore(member)
+	if !ok {
+		addReplyNull(c)
+		return
+	}
+	addReplyInt(c, int64(zset.length)-int64(zset.GetRank(score, member)))
+}
+
+// ZCountCommand ...
+// ZCOUNT key min max
+func ZCountCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zcount' command")
+		return
+	}
+
+	rg, ok := parseScoreRangeOrReply(c, c.Argv[2], c.Argv[3])
+	if !ok {
+		return
+	}
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	first := zset.FirstInRange(rg)
+	if first == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	last := zset.LastInRange(rg)
+	addReplyInt(c, int64(zset.GetRank(last.score, last.value))-int64(zset.GetRank(first.score, first.value))+1)
+}
+
+// ZRemRangeByScoreCommand ...
+// ZREMRANGEBYSCORE key min max
+func ZRemRangeByScoreCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zremrangebyscore' command")
+		return
+	}
+
+	rg, ok := parseScoreRangeOrReply(c, c.Argv[2], c.Argv[3])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	zset, ok := zsetLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	removed := zset.DeleteRangeByScore(rg, zset.dt)
+	if removed > 0 {
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
+	}
+	addReplyInt(c, int64(removed))
+}
+
+// ZLexCountCommand ...
+// ZLEXCOUNT key min max
+func ZLexCountCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zlexcount' command")
+		return
+	}
+
+	rg, ok := parseLexRangeOrReply(c, c.Argv[2], c.Argv[3])
+	if !ok {
+		return
+	}
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	first := zset.FirstInLexRange(rg)
+	if first == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	last := zset.LastInLexRange(rg)
+	addReplyInt(c, int64(zset.GetRank(last.score, last.value))-int64(zset.GetRank(first.score, first.value))+1)
+}
+
+// ZRemRangeByLexCommand ...
+// ZREMRANGEBYLEX key min max
+func ZRemRangeByLexCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zremrangebylex' command")
+		return
+	}
+
+	rg, ok := parseLexRangeOrReply(c, c.Argv[2], c.Argv[3])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	zset, ok := zsetLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	removed := zset.DeleteRangeByLex(rg, zset.dt)
+	if removed > 0 {
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
+	}
+	addReplyInt(c, int64(removed))
+}
+
+// ZRemRangeByRankCommand ...
+// ZREMRANGEBYRANK key start stop
+func ZRemRangeByRankCommand(c *Client, s *Server) {
+	if c.Argc != 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zremrangebyrank' command")
+		return
+	}
+
+	start, ok := getInt64FromObjectOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	end, ok := getInt64FromObjectOrReply(c, c.Argv[3])
+	if !ok {
+		return
+	}
+	key := c.Argv[1]
+	zset, ok := zsetLookupRead(c, key)
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	start, end, ok = zsetRankRange(start, end, int64(zset.length))
+	if !ok {
+		addReplyInt(c, 0)
+		return
+	}
+	// rank of skiplist starts from 1
+	removed := zset.DeleteRangeByRank(uint32(start+1), uint32(end+1), zset.dt)
+	if removed > 0 {
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
+	}
+	addReplyInt(c, int64(removed))
+}
+
+// ZScanCommand ...
+func ZScanCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zscan' command")
+		return
+	}
+
+	cursor, ok := parseScanCursorOrReply(c, c.Argv[2])
+	if !ok {
+		return
+	}
+	value := lookupKey(c, c.Argv[1])
+	if value == nil {
+		addReplyScan(c, 0, []*EncodeData{})
+		return
+	}
+	if !checkType(c, value, OBJZset) {
+		return
+	}
+	scanGenericCommand(c, value, cursor, 3)
+}
+
+// pop removes the member with the lowest score, or the highest score if max is true
+func (zsl *ZskipList) pop(max bool) (*Sdshdr, float64) {
+	node := zsl.header.level[0].forward
+	if max {
+		node = zsl.tail
+	}
+	member, score := node.value, node.score
+	zsl.dt.Delete(NewObject(OBJSDS, member))
+	zsl.Delete(score, member)
+	return member, score
+}
+
+// BZPopMinCommand ...
+// BZPOPMIN key [key ...] timeout
+func BZPopMinCommand(c *Client, s *Server) {
+	blockingZPopGenericCommand(c, s, "bzpopmin", false)
+}
+
+// BZPopMaxCommand ...
+// BZPOPMAX key [key ...] timeout
+func BZPopMaxCommand(c *Client, s *Server) {
+	blockingZPopGenericCommand(c, s, "bzpopmax", true)
+}
+
+// blockingZPopGenericCommand pops from the first non-empty zset of keys,
+// the client is blocked if all of them are empty
+func blockingZPopGenericCommand(c *Client, s *Server, name string, max bool) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	deadline, ok := getBlockingTimeoutOrReply(c, c.Argv[c.Argc-1])
+	if !ok {
+		return
+	}
+	serve := func(c *Client, s *Server, key *Object) {
+		zset := lookupKey(c, key).Ptr.(*ZskipList)
+		member, score := zset.pop(max)
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
+		addReplyArray(c, []*EncodeData{
+			NewBulk([]byte(key.Ptr.(string))),
+			NewBulk(member.SdsGetBuf()),
+			NewBulk([]byte(formatFloat(score))),
+		})
+		rewriteClientCommandArgv(c, "zrem", key.Ptr.(string), *member.SdsGetString())
+	}
+
+	keys := c.Argv[1 : c.Argc-1]
+	for _, key := range keys {
+		value := lookupKey(c, key)
+		if value == nil {
+			continue
+		}
+		if !checkType(c, value, OBJZset) {
+			return
+		}
+		if blockingKeyReady(value, OBJZset) {
+			serve(c, s, key)
+			return
+		}
+	}
+	blockForKeys(c, keys, OBJZset, deadline, serve, addReplyNullArray)
+}
+
+// ZCardCommand ...
+func ZCardCommand(c *Client, s *Server) {
+	if c.Argc != 2 {
+		addReplyError(c, "ERR wrong number of arguments for 'zcard' command")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if zset == nil {
+		addReplyInt(c, 0)
+		return
+	}
+	addReplyInt(c, int64(zset.length))
+}
+
+// ZMScoreCommand ...
+// ZMSCORE key member [member ...]
+func ZMScoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zmscore' command")
+		return
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0, c.Argc-2)
+	for i := 2; i < c.Argc; i++ {
+		var score float64
+		found := false
+		if zset != nil {
+			score, found = zset.GetScore(SdsNewString(c.Argv[i].Ptr.(string)))
+		}
+		if found {
+			array = append(array, NewBulk([]byte(formatFloat(score))))
+		} else {
+			array = append(array, NewBulk(nil))
+		}
+	}
+	addReplyArray(c, array)
+}
+
+// ZPopMinCommand ...
+// ZPOPMIN key [count]
+func ZPopMinCommand(c *Client, s *Server) {
+	zpopGenericCommand(c, s, "zpopmin", false)
+}
+
+// ZPopMaxCommand ...
+// ZPOPMAX key [count]
+func ZPopMaxCommand(c *Client, s *Server) {
+	zpopGenericCommand(c, s, "zpopmax", true)
+}
+
+func zpopGenericCommand(c *Client, s *Server, name string, max bool) {
+	if c.Argc != 2 && c.Argc != 3 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	count := int64(1)
+	if c.Argc == 3 {
+		var ok bool
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		if count < 0 {
+			addReplyError(c, "ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	key := c.Argv[1]
+	zset, ok := zsetLookupRead(c, key)
+	if !ok {
+		return
+	}
+	array := make([]*EncodeData, 0)
+	if zset == nil {
+		addReplyArray(c, array)
+		return
+	}
+	for ; count > 0 && zset.length > 0; count-- {
+		member, score := zset.pop(max)
+		array = append(array, NewBulk(member.SdsGetBuf()), NewBulk([]byte(formatFloat(score))))
+	}
+	if len(array) > 0 {
+		deleteIfEmptyZset(c, key, zset)
+		s.Dirty++
+	}
+	addReplyArray(c, array)
+}
+
+// randomMember return a random member and its score, zsl should not be empty
+func (zsl *ZskipList) randomMember() (*Sdshdr, float64) {
+	node := zsl.dt.GetRandomKey()
+	return node.key.Ptr.(*Sdshdr), node.value.Ptr.(*ZskipListNode).score
+}
+
+// ZRandMemberCommand ...
+// ZRANDMEMBER key [count [WITHSCORES]], members could be repeated if count is negative
+func ZRandMemberCommand(c *Client, s *Server) {
+	if c.Argc < 2 || c.Argc > 4 {
+		addReplyError(c, "ERR wrong number of arguments for 'zrandmember' command")
+		return
+	}
+
+	count := int64(1)
+	withScores := false
+	if c.Argc >= 3 {
+		var ok bool
+		if count, ok = getInt64FromObjectOrReply(c, c.Argv[2]); !ok {
+			return
+		}
+		// members may repeat with a negative count, so the reply must be bounded
+		if count < -MaxMultiBulkLen {
+			addReplyError(c, "ERR value is out of range")
+			return
+		}
+	}
+	if c.Argc == 4 {
+		if strings.ToLower(c.Argv[3].Ptr.(string)) != "withscores" {
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+		withScores = true
+	}
+
+	zset, ok := zsetLookupRead(c, c.Argv[1])
+	if !ok {
+		return
+	}
+	if c.Argc == 2 {
+		if zset == nil {
+			addReplyNull(c)
+		} else {
+			member, _ := zset.randomMember()
+			addReplyBulkSds(c, member)
+		}
+		return
+	}
+
+	array := make([]*EncodeData, 0)
+	appendMember := func(member *Sdshdr, score float64) {
+		array = append(array, NewBulk(member.SdsGetBuf()))
+		if withScores {
+			array = append(array, NewBulk([]byte(formatFloat(score))))
+		}
+	}
+	switch {
+	case zset == nil || count == 0:
+	case count < 0:
+		for ; count < 0; count++ {
+			appendMember(zset.randomMember())
+		}
+	case count >= int64(zset.length):
+		for node := zset.header.level[0].forward; node != nil; node = node.level[0].forward {
+			appendMember(node.value, node.score)
+		}
+	default:
+		// choose distinct members
+		chosen := make(map[string]bool)
+		for int64(len(chosen)) < count {
+			member, score := zset.randomMember()
+			if !chosen[*member.SdsGetString()] {
+				chosen[*member.SdsGetString()] = true
+				appendMember(member, score)
+			}
+		}
+	}
+	addReplyArray(c, array)
+}
+
+const (
+	ZSET_AGGREGATE_SUM = iota
+	ZSET_AGGREGATE_MIN
+	ZSET_AGGREGATE_MAX
+)
+
+// zsetOpSource is an input of zset operations, which is a zset or a set whose scores are 1,
+// it's empty if both of them are nil
+type zsetOpSource struct {
+	zset *ZskipList
+	set  *Set
+}
+
+func (src *zsetOpSource) len() int {
+	if src.zset != nil {
+		return int(src.zset.length)
+	}
+	if src.set != nil {
+		return src.set.Len()
+	}
+	return 0
+}
+
+func (src *zsetOpSource) forEach(fn func(member *Sdshdr, score float64)) {
+	if src.zset != nil {
+		for node := src.zset.header.level[0].forward; node != nil; node = node.level[0].forward {
+			fn(node.value, node.score)
+		}
+	} else if src.set != nil {
+		src.set.ForEach(func(member *Sdshdr) bool {
+			fn(member, 1)
+			return true
+		})
+	}
+}
+
+func (src *zsetOpSource) score(member *Sdshdr) (float64, bool) {
+	if src.zset != nil {
+		return src.zset.GetScore(member)
+	}
+	if src.set != nil && src.set.IsMember(member) {
+		return 1, true
+	}
+	return 0, false
+}
+
+// zsetAggregate return the score aggregated from old and score
+func zsetAggregate(old float64, score float64, aggregate int) float64 {
+	switch aggregate {
+	case ZSET_AGGREGATE_MIN:
+		return math.Min(old, score)
+	case ZSET_AGGREGATE_MAX:
+		return math.Max(old, score)
+	}
+	sum := old + score
+	// +inf plus -inf is regarded as 0
+	if math.IsNaN(sum) {
+		return 0
+	}
+	return sum
+}
+
+// zsetWeightedScore return score multiplied by weight, inf multiplied by 0 is regarded as 0
+func zsetWeightedScore(score float64, weight float64) float64 {
+	score *= weight
+	if math.IsNaN(score) {
+		return 0
+	}
+	return score
+}
+
+// ZUnionCommand ...
+// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZUnionCommand(c *Client, s *Server) {
+	zsetOperationGenericCommand(c, s, "zunion", SET_OP_UNION, nil)
+}
+
+// ZInterCommand ...
+// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZInterCommand(c *Client, s *Server) {
+	zsetOperationGenericCommand(c, s, "zinter", SET_OP_INTER, nil)
+}
+
+// ZDiffCommand ...
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func ZDiffCommand(c *Client, s *Server) {
+	zsetOperationGenericCommand(c, s, "zdiff", SET_OP_DIFF, nil)
+}
+
+// ZUnionStoreCommand ...
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zunionstore' command")
+		return
+	}
+	zsetOperationGenericCommand(c, s, "zunionstore", SET_OP_UNION, c.Argv[1])
+}
+
+// ZInterStoreCommand ...
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func ZInterStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zinterstore' command")
+		return
+	}
+	zsetOperationGenericCommand(c, s, "zinterstore", SET_OP_INTER, c.Argv[1])
+}
+
+// ZDiffStoreCommand ...
+// ZDIFFSTORE destination numkeys key [key ...]
+func ZDiffStoreCommand(c *Client, s *Server) {
+	if c.Argc < 3 {
+		addReplyError(c, "ERR wrong number of arguments for 'zdiffstore' command")
+		return
+	}
+	zsetOperationGenericCommand(c, s, "zdiffstore", SET_OP_DIFF, c.Argv[1])
+}
+
+// zsetOperationGenericCommand computes the union, intersection or difference of zsets and sets,
+// the result is stored at dstKey if it isn't nil, otherwise it's replied
+func zsetOperationGenericCommand(c *Client, s *Server, name string, op int, dstKey *Object) {
+	numKeysIndex := 1
+	if dstKey != nil {
+		numKeysIndex = 2
+	}
+	if c.Argc <= numKeysIndex+1 {
+		addReplyError(c, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+
+	numKeys, ok := getInt64FromObjectOrReply(c, c.Argv[numKeysIndex])
+	if !ok {
+		return
+	}
+	if numKeys < 1 {
+		addReplyError(c, "ERR at least 1 input key is needed for '"+name+"' command")
+		return
+	}
+	if numKeys > int64(c.Argc-numKeysIndex-1) {
+		addReplyError(c, "ERR syntax error")
+		return
+	}
+	keys := c.Argv[numKeysIndex+1 : numKeysIndex+1+int(numKeys)]
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggregate := ZSET_AGGREGATE_SUM
+	withScores := false
+	for i := numKeysIndex + 1 + len(keys); i < c.Argc; i++ {
+		opt := strings.ToLower(c.Argv[i].Ptr.(string))
+		remaining := c.Argc - i - 1
+		switch {
+		case opt == "weights" && op != SET_OP_DIFF && remaining >= len(keys):
+			for j := range weights {
+				i++
+				weight, err := strconv.ParseFloat(c.Argv[i].Ptr.(string), 64)
+				if err != nil || math.IsNaN(weight) {
+					addReplyError(c, "ERR weight value is not a float")
+					return
+				}
+				weights[j] = weight
+			}
+		case opt == "aggregate" && op != SET_OP_DIFF && remaining >= 1:
+			i++
+			switch strings.ToLower(c.Argv[i].Ptr.(string)) {
+			case "sum":
+				aggregate = ZSET_AGGREGATE_SUM
+			case "min":
+				aggregate = ZSET_AGGREGATE_MIN
+			case "max":
+				aggregate = ZSET_AGGREGATE_MAX
+			default:
+				addReplyError(c, "ERR syntax error")
+				return
+			}
+		case opt == "withscores" && dstKey == nil:
+			withScores = true
+		default:
+			addReplyError(c, "ERR syntax error")
+			return
+		}
+	}
+
+	sources := make([]*zsetOpSource, 0, len(keys))
+	for _, key := range keys {
+		src := &zsetOpSource{}
+		if value := lookupKey(c, key); value != nil {
+			switch value.ObjectType {
+			case OBJZset:
+				src.zset = value.Ptr.(*ZskipList)
+			case OBJSet:
+				src.set = value.Ptr.(*Set)
+			default:
+				addReplyWrongType(c)
+				return
+			}
+		}
+		sources = append(sources, src)
+	}
+
+	result := zsetOperation(sources, weights, aggregate, op)
+
+	if dstKey == nil {
+		array := make([]*EncodeData, 0)
+		for node := result.header.level[0].forward; node != nil; node = node.level[0].forward {
+			array = append(array, NewBulk(node.value.SdsGetBuf()))
+			if withScores {
+				array = append(array, NewBulk([]byte(formatFloat(node.score))))
+			}
+		}
+		addReplyArray(c, array)
+		return
+	}
+
+	if result.length == 0 {
+		c.Db.Delete(dstKey)
+	} else {
+		c.Db.SetKey(dstKey, NewObject(OBJZset, result), false)
+	}
+	s.Dirty++
+	addReplyInt(c, int64(result.length))
+}
+
+// zsetOperation return the result of op on sources, the scores are multiplied by weights
+// and aggregated if a member is in several sources
+func zsetOperation(sources []*zsetOpSource, weights []float64, aggregate int, op int) *ZskipList {
+	// scores are accumulated before being inserted into the skiplist
+	scores := make(map[string]float64)
+	members := make([]*Sdshdr, 0)
+	accumulate := func(member *Sdshdr, score float64) {
+		m := *member.SdsGetString()
+		if old, ok := scores[m]; ok {
+			scores[m] = zsetAggregate(old, score, aggregate)
+			return
+		}
+		scores[m] = score
+		members = append(members, member)
+	}
+
+	switch op {
+	case SET_OP_UNION:
+		for i, src := range sources {
+			src.forEach(func(member *Sdshdr, score float64) {
+				accumulate(member, zsetWeightedScore(score, weights[i]))
+			})
+		}
+	case SET_OP_INTER:
+		smallest := 0
+		for i, src := range sources {
+			if src.len() < sources[smallest].len() {
+				smallest = i
+			}
+		}
+		sources[smallest].forEach(func(member *Sdshdr, score float64) {
+			total := zsetWeightedScore(score, weights[smallest])
+			for i, src := range sources {
+				if i == smallest {
+					continue
+				}
+				other, ok := src.score(member)
+				if !ok {
+					return
+				}
+				total = zsetAggregate(total, zsetWeightedScore(other, weights[i]), aggregate)
+			}
+			accumulate(member, total)
+		})
+	case SET_OP_DIFF:
+		sources[0].forEach(func(member *Sdshdr, score float64) {
+			for _, src := range sources[1:] {
+				if _, ok := src.score(member); ok {
+					return
+				}
+			}
+			accumulate(member, score)
+		})
+	}
+
+	result := NewZsl()
+	for _, member := range members {
+		result.addMember(scores[*member.SdsGetString()], member.SdsDup())
+	}
+	return result
+}
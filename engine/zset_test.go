@@ -0,0 +1,304 @@
+package godis
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestZslRange(t *testing.T) {
+	zsl := NewZsl()
+	for i := 1; i <= 100; i++ {
+		zsl.addMember(float64(i), SdsNewString("m"+strconv.Itoa(i)))
+	}
+
+	if node := zsl.LastInRange(&zrangeTag{minx: 0, maxx: 0.5}); node != nil {
+		t.Fatalf("last node in empty range is %v", node.score)
+	}
+	if node := zsl.LastInRange(&zrangeTag{minx: 10, maxx: 20, emaxx: true}); node == nil || node.score != 19 {
+		t.Fatal("wrong last node in range [10, 20)")
+	}
+	if removed := zsl.DeleteRangeByRank(11, 20, zsl.dt); removed != 10 {
+		t.Fatalf("%d nodes are removed by rank, expect 10", removed)
+	}
+	if node := zsl.GetElementByRank(11); node.score != 21 {
+		t.Fatalf("rank 11 is %v after removing, expect 21", node.score)
+	}
+	if removed := zsl.DeleteRangeByScore(&zrangeTag{minx: 90, maxx: 100, eminx: true}, zsl.dt); removed != 10 {
+		t.Fatalf("%d nodes are removed by score, expect 10", removed)
+	}
+	if zsl.length != 80 || zsl.tail.score != 90 || zsl.dt.Size() != 80 {
+		t.Fatalf("wrong skiplist after removing, length %d, tail %v", zsl.length, zsl.tail.score)
+	}
+}
+
+func TestZslMemberDict(t *testing.T) {
+	zsl := NewZsl()
+	for i := 0; i < 1000; i++ {
+		zsl.zsetAdd(float64(rand.Intn(100)), SdsNewString(strconv.Itoa(rand.Intn(200))), ZADD_IN_INCR)
+	}
+
+	// every member in dict points to its node in skiplist
+	prev := -1.0
+	for node := zsl.header.level[0].forward; node != nil; node = node.level[0].forward {
+		if node.score < prev {
+			t.Fatalf("score %v is after %v", node.score, prev)
+		}
+		prev = node.score
+		if v := zsl.dt.Get(NewObject(OBJSDS, node.value)); v == nil || v.Ptr.(*ZskipListNode) != node {
+			t.Fatalf("member %s doesn't point to its node", *node.value.SdsGetString())
+		}
+	}
+	if zsl.dt.Size() != int(zsl.length) {
+		t.Fatalf("dict has %d members, skiplist has %d", zsl.dt.Size(), zsl.length)
+	}
+}
+
+func TestZsetRangeCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"zadd z 1 a 2 b 3 c 4 d 5 e", ":5\r\n"},
+		{"zrange z -2 -1 withscores", "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\ne\r\n$1\r\n5\r\n"},
+		{"zrevrange z 0 1", "*2\r\n$1\r\ne\r\n$1\r\nd\r\n"},
+		{"zrevrank z e", ":0\r\n"},
+		{"zrevrank z nope", "$-1\r\n"},
+		{"zrangebyscore z (1 3", "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"zrangebyscore z -inf +inf limit 1 2", "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"zrangebyscore z 2 1", "*0\r\n"},
+		{"zrangebyscore z a 1", "-ERR min or max is not a float\r\n"},
+		{"zrevrangebyscore z 4 (2 withscores", "*4\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n3\r\n"},
+		{"zrevrangebyscore z +inf -inf limit 0 1", "*1\r\n$1\r\ne\r\n"},
+		{"zrange z (4 +inf byscore", "*1\r\n$1\r\ne\r\n"},
+		{"zrange z 5 1 byscore rev limit 1 -1", "*4\r\n$1\r\nd\r\n$1\r\nc\r\n$1\r\nb\r\n$1\r\na\r\n"},
+		{"zrange z 0 1 limit 0 1", "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{"zrevrange z 0 1 rev", "-ERR syntax error\r\n"},
+		{"zcount z (1 4", ":3\r\n"},
+		{"zcount z 6 +inf", ":0\r\n"},
+		{"zremrangebyrank z -1 -1", ":1\r\n"},
+		{"zremrangebyscore z -inf (2", ":1\r\n"},
+		{"zrange z 0 -1", "*3\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{"zadd lex 0 a 0 b 0 c 0 d", ":4\r\n"},
+		{"zrange lex [b (d bylex", "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"zrange lex + - bylex rev limit 0 2", "*2\r\n$1\r\nd\r\n$1\r\nc\r\n"},
+		{"zrange lex - + bylex withscores", "-ERR syntax error, WITHSCORES not supported in combination with BYLEX\r\n"},
+		{"zrange lex a + bylex", "-ERR min or max not valid string range item\r\n"},
+		{"zremrangebyrank lex 0 -1", ":4\r\n"},
+		{"exists lex", ":0\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "zrange", "z", "0", "-1"); r != "*3\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n" {
+		t.Fatalf("z is %q after loading", r)
+	}
+}
+
+func TestZsetCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"zadd z 1 a 2 b", ":2\r\n"},
+		{"zadd z 1 a 3 b 4 c", ":1\r\n"},
+		{"zadd z ch 1 a 2 b 4 c", ":1\r\n"},
+		{"zadd z nx 9 a 5 d", ":1\r\n"},
+		{"zadd z xx ch 9 a 5 e", ":1\r\n"},
+		{"zadd z gt ch 1 a 10 d", ":1\r\n"},
+		{"zadd z lt ch 1 a 10 c", ":1\r\n"},
+		{"zadd z incr 2 a", "$1\r\n3\r\n"},
+		{"zadd z xx incr 2 nope", "$-1\r\n"},
+		{"zadd z nx xx 1 a", "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{"zadd z nx gt 1 a", "-ERR GT, LT, and/or NX options at the same time are not compatible\r\n"},
+		{"zadd z incr 1 a 2 b", "-ERR INCR option supports a single increment-element pair\r\n"},
+		{"zadd z 1 a 2", "-ERR wrong number of arguments for 'zadd' command\r\n"},
+		{"zadd", "-ERR wrong number of arguments for 'zadd' command\r\n"},
+		{"zincrby", "-ERR wrong number of arguments for 'zincrby' command\r\n"},
+		{"zadd z nan a", "-ERR value is not a valid float\r\n"},
+		{"zadd nope xx 1 a", ":0\r\n"},
+		{"exists nope", ":0\r\n"},
+		{"zincrby z 1.5 b", "$3\r\n3.5\r\n"},
+		{"zincrby z 0 b", "$3\r\n3.5\r\n"},
+		{"zincrby z 2 new", "$1\r\n2\r\n"},
+		{"zincrby z +inf a", "$3\r\ninf\r\n"},
+		{"zincrby z -inf a", "-ERR resulting score is not a number (NaN)\r\n"},
+		{"zcard z", ":5\r\n"},
+		{"zmscore z b nope", "*2\r\n$3\r\n3.5\r\n$-1\r\n"},
+		{"zadd big 1e16 a 0.00001 b", ":2\r\n"},
+		{"zmscore big a b", "*2\r\n$5\r\n1e+16\r\n$5\r\n1e-05\r\n"},
+		{"zincrby big 1 a", "$5\r\n1e+16\r\n"},
+		{"zpopmin z", "*2\r\n$3\r\nnew\r\n$1\r\n2\r\n"},
+		{"zpopmax z 2", "*4\r\n$1\r\na\r\n$3\r\ninf\r\n$1\r\nd\r\n$2\r\n10\r\n"},
+		{"zpopmin z -1", "-ERR value is out of range, must be positive\r\n"},
+		{"zrandmember z 5 withscores", "*4\r\n$1\r\nb\r\n$3\r\n3.5\r\n$1\r\nc\r\n$1\r\n4\r\n"},
+		{"zadd one 1 a", ":1\r\n"},
+		{"zrandmember one -3", "*3\r\n$1\r\na\r\n$1\r\na\r\n$1\r\na\r\n"},
+		{"zrandmember one 1 withscores", "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"zrandmember z -1048577", "-ERR value is out of range\r\n"},
+		{"zrandmember z -9223372036854775808", "-ERR value is out of range\r\n"},
+		{"zrandmember nope", "$-1\r\n"},
+		{"zpopmin z 5", "*4\r\n$1\r\nb\r\n$3\r\n3.5\r\n$1\r\nc\r\n$1\r\n4\r\n"},
+		{"exists z", ":0\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+func TestZsetOperationCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"zadd z1 1 a 2 b 3 c", ":3\r\n"},
+		{"zadd z2 10 b 20 c 30 d", ":3\r\n"},
+		{"sadd set c d e", ":3\r\n"},
+		{"zunion 2 z1 z2 withscores", "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n23\r\n$1\r\nd\r\n$2\r\n30\r\n"},
+		{"zinter 2 z1 z2 weights 2 0.5 withscores", "*4\r\n$1\r\nb\r\n$1\r\n9\r\n$1\r\nc\r\n$2\r\n16\r\n"},
+		{"zinter 3 z1 z2 set aggregate max withscores", "*2\r\n$1\r\nc\r\n$2\r\n20\r\n"},
+		{"zunion 2 z1 set aggregate min withscores", "*10\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nc\r\n$1\r\n1\r\n$1\r\nd\r\n$1\r\n1\r\n$1\r\ne\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n"},
+		{"zdiff 3 z1 z2 nope withscores", "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"zdiff 2 z1 z2 weights 1 1", "-ERR syntax error\r\n"},
+		{"zunion 0 z1", "-ERR at least 1 input key is needed for 'zunion' command\r\n"},
+		{"zunion 3 z1 z2", "-ERR syntax error\r\n"},
+		{"zunion 2 z1 z2 weights 1 x", "-ERR weight value is not a float\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"zunion 2 z1 str", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"zunionstore dst 2 z1 z2 weights 1 -1", ":4\r\n"},
+		{"zrange dst 0 -1 withscores", "*8\r\n$1\r\nd\r\n$3\r\n-30\r\n$1\r\nc\r\n$3\r\n-17\r\n$1\r\nb\r\n$2\r\n-8\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"zinterstore dst 2 z1 set", ":1\r\n"},
+		{"zunionstore z1 2 z1 z1", ":3\r\n"},
+		{"zscore z1 c", "$1\r\n6\r\n"},
+		{"zdiffstore dst 2 z1 z1", ":0\r\n"},
+		{"exists dst", ":0\r\n"},
+		{"zdiffstore dst 2 z2 z1", ":1\r\n"},
+		{"zunionstore dst 1 z1 withscores", "-ERR syntax error\r\n"},
+		{"zunionstore", "-ERR wrong number of arguments for 'zunionstore' command\r\n"},
+		{"zinterstore dst", "-ERR wrong number of arguments for 'zinterstore' command\r\n"},
+		{"zdiffstore", "-ERR wrong number of arguments for 'zdiffstore' command\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+
+	loaded, err := reloadServer(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lc := loaded.CreateClient(nil)
+	if r := execCommand(loaded, lc, "zrange", "dst", "0", "-1", "withscores"); r != "*2\r\n$1\r\nd\r\n$2\r\n30\r\n" {
+		t.Fatalf("dst is %q after loading", r)
+	}
+	if r := execCommand(loaded, lc, "zscore", "z1", "c"); r != "$1\r\n6\r\n" {
+		t.Fatalf("score of c is %q after loading", r)
+	}
+}
+
+func TestZsetLexCommands(t *testing.T) {
+	s, clean := newSCFTestServer(t)
+	defer clean()
+
+	c := s.CreateClient(nil)
+	for _, tc := range []struct {
+		cmd    string
+		expect string
+	}{
+		{"zadd terms 0 apple 0 app 0 apply 0 banana 0 band 0 b", ":6\r\n"},
+		{"zrangebylex terms [app (apq", "*3\r\n$3\r\napp\r\n$5\r\napple\r\n$5\r\napply\r\n"},
+		{"zrangebylex terms (app [b", "*3\r\n$5\r\napple\r\n$5\r\napply\r\n$1\r\nb\r\n"},
+		{"zrangebylex terms - + limit 4 10", "*2\r\n$6\r\nbanana\r\n$4\r\nband\r\n"},
+		{"zrangebylex terms + -", "*0\r\n"},
+		{"zrangebylex terms [b [a", "*0\r\n"},
+		{"zrangebylex terms - + withscores", "-ERR syntax error\r\n"},
+		{"zrangebylex terms app +", "-ERR min or max not valid string range item\r\n"},
+		{"zrevrangebylex terms (band [b", "*2\r\n$6\r\nbanana\r\n$1\r\nb\r\n"},
+		{"zrevrangebylex terms + - limit 0 1", "*1\r\n$4\r\nband\r\n"},
+		{"zlexcount terms - +", ":6\r\n"},
+		{"zlexcount terms [b (c", ":3\r\n"},
+		{"zlexcount terms (band +", ":0\r\n"},
+		{"zremrangebylex terms [app (apq", ":3\r\n"},
+		{"zrange terms 0 -1", "*3\r\n$1\r\nb\r\n$6\r\nbanana\r\n$4\r\nband\r\n"},
+		{"zremrangebylex terms - +", ":3\r\n"},
+		{"exists terms", ":0\r\n"},
+	} {
+		r := execCommand(s, c, strings.Fields(tc.cmd)...)
+		if r != tc.expect {
+			t.Fatalf("%s replies %q, expect %q", tc.cmd, r, tc.expect)
+		}
+	}
+}
+
+// newBenchmarkZset creates a zset of n members whose scores are random floats
+func newBenchmarkZset(b *testing.B, n int) (*ZskipList, []*Sdshdr) {
+	zsl := NewZsl()
+	members := make([]*Sdshdr, n)
+	for i := range members {
+		members[i] = SdsNewString("member:" + strconv.Itoa(i))
+		zsl.addMember(rand.Float64()*1e6, members[i])
+	}
+	b.ResetTimer()
+	return zsl, members
+}
+
+// BenchmarkZScore measures the lookup done by ZSCORE
+func BenchmarkZScore(b *testing.B) {
+	zsl, members := newBenchmarkZset(b, 100000)
+	for i := 0; i < b.N; i++ {
+		zsl.GetScore(members[i%len(members)])
+	}
+}
+
+// BenchmarkZScoreParseSds measures the lookup done by ZSCORE when the member dict
+// held the scores as SDS strings, it's the baseline of BenchmarkZScore
+func BenchmarkZScoreParseSds(b *testing.B) {
+	zsl, members := newBenchmarkZset(b, 100000)
+	dt := NewDict(&DictFunc{calHash: CalHashCommon, keyCompare: CompareValueCommon})
+	for _, member := range members {
+		score, _ := zsl.GetScore(member)
+		dt.Add(NewObject(OBJSDS, member), NewObject(OBJSDS, SdsNewString(formatFloat(score))))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value := dt.Get(NewObject(OBJSDS, members[i%len(members)]))
+		strconv.ParseFloat(*(value.Ptr.(*Sdshdr).SdsGetString()), 64)
+	}
+}
+
+// BenchmarkZRank measures the skiplist walk done by ZRANK, the scores are looked up in advance
+func BenchmarkZRank(b *testing.B) {
+	zsl, members := newBenchmarkZset(b, 100000)
+	scores := make([]float64, len(members))
+	for i, member := range members {
+		scores[i], _ = zsl.GetScore(member)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zsl.GetRank(scores[i%len(members)], members[i%len(members)])
+	}
+}
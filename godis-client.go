@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	godis "github.com/nk-akun/godis/engine"
@@ -25,17 +26,58 @@ func main() {
 		fmt.Println("error ", err)
 		os.Exit(1)
 	}
+	decoder := godis.NewDecoder(conn)
 
 	for {
 		fmt.Printf("%s>", addr)
-		content, _ := reader.ReadString('\n')
-		content = strings.Replace(content, "\n", "", -1)
+		content, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		content = strings.TrimSpace(content)
+		if content == "" {
+			continue
+		}
 
-		sendToServer(conn, content)
+		if _, err := sendToServer(conn, content); err != nil {
+			fmt.Println("error ", err)
+			os.Exit(1)
+		}
 
-		buff := make([]byte, 10240)
-		conn.Read(buff)
-		fmt.Println(string(buff[1:]))
+		if isSubscribeCommand(content) {
+			readPushedMessages(decoder)
+			continue
+		}
+
+		reply, err := decoder.Decode()
+		if err != nil {
+			fmt.Println("error ", err)
+			os.Exit(1)
+		}
+		fmt.Println(formatReply(reply, ""))
+	}
+}
+
+// isSubscribeCommand return true if the command puts the connection into subscribe mode
+func isSubscribeCommand(content string) bool {
+	name := strings.ToLower(strings.Fields(content)[0])
+	return name == "subscribe" || name == "psubscribe"
+}
+
+// readPushedMessages prints the replies of subscribing and the messages pushed by server like redis-cli,
+// it returns only if subscribing fails, otherwise it keeps reading until the program is interrupted
+func readPushedMessages(decoder *godis.Decoder) {
+	fmt.Println("Reading messages... (press Ctrl-C to quit)")
+	for {
+		reply, err := decoder.Decode()
+		if err != nil {
+			fmt.Println("error ", err)
+			os.Exit(1)
+		}
+		fmt.Println(formatReply(reply, ""))
+		if reply.Type == godis.TypeError {
+			return
+		}
 	}
 }
 
@@ -47,3 +89,39 @@ func sendToServer(conn *net.TCPConn, content string) (n int, err error) {
 
 	return conn.Write(b)
 }
+
+// formatReply formats reply the same as redis-cli,
+// prefix is the indent of lines of nested array except the first one
+func formatReply(r *godis.EncodeData, prefix string) string {
+	switch r.Type {
+	case godis.TypeStatus:
+		return string(r.Value)
+	case godis.TypeError:
+		return "(error) " + string(r.Value)
+	case godis.TypeInt:
+		return "(integer) " + string(r.Value)
+	case godis.TypeBulk:
+		if r.Value == nil {
+			return "(nil)"
+		}
+		return strconv.Quote(string(r.Value))
+	case godis.TypeMultiBulk:
+		if r.Array == nil {
+			return "(nil)"
+		}
+		if len(r.Array) == 0 {
+			return "(empty array)"
+		}
+		width := len(strconv.Itoa(len(r.Array)))
+		lines := make([]string, len(r.Array))
+		for i, e := range r.Array {
+			index := fmt.Sprintf("%*d) ", width, i+1)
+			lines[i] = index + formatReply(e, prefix+strings.Repeat(" ", len(index)))
+			if i > 0 {
+				lines[i] = prefix + lines[i]
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+	return ""
+}
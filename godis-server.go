@@ -22,7 +22,7 @@ func main() {
 
 	listener, err := net.Listen("tcp", "127.0.0.1:10010")
 	if err != nil {
-		fmt.Println("listen :%v", err)
+		fmt.Printf("listen :%v\n", err)
 		os.Exit(-1)
 	}
 	for {
@@ -30,27 +30,6 @@ func main() {
 		if err != nil {
 			continue
 		}
-		go handle(conn)
+		go server.HandleConn(conn)
 	}
 }
-
-func handle(conn net.Conn) {
-	client := server.CreateClient()
-	for {
-		err := client.ReadClientContent(conn)
-		if err != nil {
-			engine.GetGodisLogger().Errorf("read query content error:%+v", err)
-			return
-		}
-		err = client.TransClientContent()
-		if err != nil {
-			engine.GetGodisLogger().Errorf("process input buffer error:%+v", err)
-		}
-		server.ProcessCommand(client)
-		responseClient(conn, client)
-	}
-}
-
-func responseClient(conn net.Conn, c *engine.Client) {
-	conn.Write([]byte(*c.Buf.SdsGetString()))
-}